@@ -5,13 +5,16 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"log"
 	"math"
 	"math/big"
 )
 
 // ========================Proof of Work========================
+
+// Leading zero bits required of block hashes
+const Difficulty = 14
+
 type PoW struct {
 	Block  *Block
 	target *big.Int
@@ -21,7 +24,7 @@ type PoW struct {
 func NewProof(b *Block) *PoW {
 	// Create a target value for the proof-of-work difficulty
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-14))
+	target.Lsh(target, uint(256-Difficulty))
 
 	// Create and return a PoW instance
 	pow := &PoW{b, target}
@@ -46,18 +49,13 @@ func ToBytes(num int64) []byte {
 }
 
 // ========================Prepares the data to be hashed with the given nonce========================
+// The transactions are covered by the Merkle root, so a header can be checked without them
 func (pow *PoW) Init(nonce int) []byte {
-	// Convert the slice of Transactions to a JSON byte slice
-	transactionsBytes, err := json.Marshal(pow.Block.Transactions)
-	if err != nil {
-		log.Fatal(err) // or handle the error as you see fit
-	}
-
 	data := bytes.Join([][]byte{
-		transactionsBytes, // Now a []byte
+		[]byte(pow.Block.MerkleRoot),
 		[]byte(pow.Block.PrevHash),
 		ToBytes(int64(nonce)),
-		ToBytes(int64(14)),
+		ToBytes(int64(Difficulty)),
 	}, []byte{})
 
 	return data
@@ -92,3 +90,12 @@ func (pow *PoW) GetHash() (int, string) {
 	// Return the valid nonce and the corresponding hash
 	return nonce, hash
 }
+
+// ========================Checks the proof-of-work of a block========================
+func (pow *PoW) Validate() bool {
+	var initHash big.Int
+	hashBytes := sha256.Sum256(pow.Init(pow.Block.Nonce))
+	initHash.SetBytes(hashBytes[:])
+
+	return hex.EncodeToString(hashBytes[:]) == pow.Block.Hash && initHash.Cmp(pow.target) == -1
+}
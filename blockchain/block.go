@@ -5,14 +5,24 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"sync"
 )
 
 // ========================Represents a transaction in the blockchain========================
 type Transaction struct {
-	DataHash     string // CID of the dataset stored on IPFS
-	AlgoHash     string // CID of the AI algorithm stored on IPFS
-	Requirements string // CID of the requirements file stored on IPFS
-	Output       string // Hash of expected output of the algorithm
+	DataHash     string          // CID of the dataset stored on IPFS
+	AlgoHash     string          // CID of the AI algorithm stored on IPFS
+	Requirements string          // CID of the requirements file stored on IPFS
+	Output       string          // Hash of expected output of the algorithm
+	OutputCID    string          `json:",omitempty"` // CID of the published output artifact, the result and output files Output commits to
+	Manifest     string          `json:",omitempty"` // CID of the job manifest, for jobs submitted as a manifest
+	JobID        string          `json:",omitempty"` // ID the generator gave the submission, for status tracking
+	Miner        string          `json:",omitempty"` // Ed25519 public key of the miner that computed Output, hex
+	MinerSig     string          `json:",omitempty"` // Signature of the transaction by Miner, hex
+	Spec         json.RawMessage `json:",omitempty"` // Job options (deadlines, output, verification) declared by the generator, re-used by verifiers
+	Result       json.RawMessage `json:",omitempty"` // Canonical result hashed into Output, kept when verified with tolerances
+	Environment  json.RawMessage `json:",omitempty"` // Fingerprint of the environment the miner computed Output in
 }
 
 // ========================Represents a block in the blockchain========================
@@ -20,21 +30,26 @@ type Block struct {
 	Hash         string        // Hash of the block
 	Transactions []Transaction // Transactions stored in the block
 	PrevHash     string        // Hash of the previous block
+	MerkleRoot   string        // Root of the Merkle tree of the transaction IDs
 	Nonce        int           // Nonce used in proof-of-work
+	Pruned       bool          `json:",omitempty"` // Whether the transactions were discarded, keeping the header
 }
 
 // ========================Blockchain========================
 type Blockchain struct {
-	Blocks []*Block // Slice of blocks forming the blockchain
+	Blocks []*Block     // Slice of blocks forming the blockchain
+	mu     sync.RWMutex // Guards Blocks, read by the API while blocks are mined
+	index  *Index       // Secondary indexes of the transactions in Blocks
+	pruned int          // Height of the latest block whose transactions were discarded, -1 if none
 }
 
 // ========================Calculates and sets the hash for the block========================
 func (b *Block) GetHash() {
-	// Serialize transactions into a single byte slice
-	txData, _ := json.Marshal(b.Transactions)
+	// Commit to the transactions through their Merkle root
+	b.MerkleRoot = MerkleRoot(b.Transactions)
 
-	// Join transaction data and previous hash
-	info := bytes.Join([][]byte{txData, []byte(b.PrevHash)}, []byte{})
+	// Join Merkle root and previous hash
+	info := bytes.Join([][]byte{[]byte(b.MerkleRoot), []byte(b.PrevHash)}, []byte{})
 
 	// Compute the SHA-256 hash of the joined data
 	hash := sha256.Sum256(info)
@@ -45,7 +60,7 @@ func (b *Block) GetHash() {
 
 // ========================Creates a new block========================
 func NewBlock(transactions []Transaction, prevhash string) *Block {
-	block := &Block{"", transactions, prevhash, 0}
+	block := &Block{"", transactions, prevhash, MerkleRoot(transactions), 0, false}
 	pow := NewProof(block)
 	nonce, hash := pow.GetHash()
 
@@ -55,33 +70,137 @@ func NewBlock(transactions []Transaction, prevhash string) *Block {
 	return block
 }
 
-// ========================Initializes the blockchain with a genesis block========================
-func InitBlockchain() (*Blockchain, string) {
+// ========================Creates the genesis block========================
+// Every node derives the same genesis block
+func GenesisBlock() *Block {
 	// Transaction stored in the Genesis Block
 	genesisTx := []Transaction{
 		{DataHash: "GenesisData", AlgoHash: "GenesisAlgo", Requirements: "GenesisReq", Output: "GenesisOutputHash"},
 	}
 
-	block := NewBlock(genesisTx, "")
+	return NewBlock(genesisTx, "")
+}
 
-	return &Blockchain{[]*Block{block}}, block.Hash
+// ========================Initializes the blockchain with a genesis block========================
+func InitBlockchain() (*Blockchain, string) {
+	block := GenesisBlock()
+
+	chain := &Blockchain{Blocks: []*Block{block}, index: NewIndex(), pruned: -1}
+	chain.index.Connect(block, 0)
+	return chain, block.Hash
 }
 
 // ========================Adds a new block to the blockchain========================
-func (chain *Blockchain) AddBlock(transactions []Transaction) {
-	prevB := chain.Blocks[len(chain.Blocks)-1]
-	newB := NewBlock(transactions, prevB.Hash)
-	chain.Blocks = append(chain.Blocks, newB)
+// Returns the new block and its height. The block is mined again if another block was
+// added to the chain while it was being mined.
+func (chain *Blockchain) AddBlock(transactions []Transaction) (*Block, int) {
+	for {
+		prevB := chain.GetLatestBlock()
+		newB := NewBlock(transactions, prevB.Hash)
+
+		chain.mu.Lock()
+		if chain.Blocks[len(chain.Blocks)-1] == prevB {
+			chain.Blocks = append(chain.Blocks, newB)
+			chain.index.Connect(newB, len(chain.Blocks)-1)
+			height := len(chain.Blocks) - 1
+			chain.mu.Unlock()
+			return newB, height
+		}
+		chain.mu.Unlock()
+	}
 }
 
 // ========================Get the latest block========================
 func (chain *Blockchain) GetLatestBlock() *Block {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
 	return chain.Blocks[len(chain.Blocks)-1]
 }
 
 // ========================Add a block to the chain========================
-func (chain *Blockchain) AddBlockToChain(block *Block) {
+// Returns the block and its height. The block must link to the latest block, checked
+// under the same lock as the append so no other block can be added in between.
+func (chain *Blockchain) AddBlockToChain(block *Block) (*Block, int, error) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	if tip := chain.Blocks[len(chain.Blocks)-1]; block.PrevHash != tip.Hash {
+		return nil, 0, fmt.Errorf("block %s does not link to the latest block %s", block.Hash, tip.Hash)
+	}
 	chain.Blocks = append(chain.Blocks, block)
+	chain.index.Connect(block, len(chain.Blocks)-1)
+	return block, len(chain.Blocks) - 1, nil
+}
+
+// ========================Remove the latest block from the chain========================
+// Returns the removed block; the genesis block and pruned blocks are never removed
+func (chain *Blockchain) DisconnectTip() (*Block, bool) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	height := len(chain.Blocks) - 1
+	if height == 0 || height <= chain.pruned {
+		return nil, false
+	}
+	block := chain.Blocks[height]
+	chain.index.Disconnect(block, height)
+	chain.Blocks = chain.Blocks[:height]
+	return block, true
+}
+
+// ========================Chain queries========================
+
+// Height of the latest block, the genesis block being at height 0
+func (chain *Blockchain) Height() int {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return len(chain.Blocks) - 1
+}
+
+// Returns the block at the given height
+func (chain *Blockchain) BlockAt(height int) (*Block, bool) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	if height < 0 || height >= len(chain.Blocks) {
+		return nil, false
+	}
+	return chain.Blocks[height], true
+}
+
+// Returns the block with the given hash and its height
+func (chain *Blockchain) BlockByHash(hash string) (*Block, int, bool) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	for i := len(chain.Blocks) - 1; i >= 0; i-- {
+		if chain.Blocks[i].Hash == hash {
+			return chain.Blocks[i], i, true
+		}
+	}
+	return nil, 0, false
+}
+
+// Returns a copy of the list of blocks, safe to iterate while blocks are added
+func (chain *Blockchain) Snapshot() []*Block {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return append([]*Block(nil), chain.Blocks...)
+}
+
+// ========================Verifies a sequence of blocks========================
+// Checks the Merkle root and proof-of-work of every block and that each one links to the block before it.
+// Pruned blocks no longer hold the transactions their Merkle root commits to; only their header is checked.
+// The first block is trusted to link to whatever precedes it.
+func VerifyBlocks(blocks []*Block) error {
+	for i, block := range blocks {
+		if !block.Pruned && block.MerkleRoot != MerkleRoot(block.Transactions) {
+			return fmt.Errorf("block %d (%s) does not match its Merkle root", i, block.Hash)
+		}
+		if !NewProof(block).Validate() {
+			return fmt.Errorf("block %d (%s) has an invalid hash or proof-of-work", i, block.Hash)
+		}
+		if i > 0 && block.PrevHash != blocks[i-1].Hash {
+			return fmt.Errorf("block %d (%s) does not link to block %d (%s)", i, block.Hash, i-1, blocks[i-1].Hash)
+		}
+	}
+	return nil
 }
 
 // ========================Hash data using SHA-256========================
@@ -0,0 +1,162 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// ========================Checkpoints========================
+
+// A checkpoint pins the hash of the block at a height. Blocks up to the highest checkpoint
+// are accepted on their hashes alone when a node syncs or loads a snapshot, without
+// re-executing their jobs. Checkpoints are hard-coded, or signed with Ed25519 by a key the
+// node trusts.
+
+type Checkpoint struct {
+	Height    int
+	Hash      string
+	Signer    string `json:",omitempty"` // Public key of the signer, hex; empty for hard-coded checkpoints
+	Signature string `json:",omitempty"` // Signature of "checkpoint:<height>:<hash>", hex
+}
+
+// Checkpoints of the network, compiled into every node. The genesis block is always one.
+var HardCheckpoints = []Checkpoint{}
+
+// The checkpoints a node enforces
+type Checkpoints struct {
+	byHeight map[int]Checkpoint
+	highest  Checkpoint
+}
+
+// ========================Signs a checkpoint========================
+func SignCheckpoint(key ed25519.PrivateKey, height int, hash string) Checkpoint {
+	return Checkpoint{
+		Height:    height,
+		Hash:      hash,
+		Signer:    hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(key, checkpointMessage(height, hash))),
+	}
+}
+
+// ========================Checks the signature of a checkpoint========================
+func (cp Checkpoint) Verify() bool {
+	signer, err := hex.DecodeString(cp.Signer)
+	if err != nil || len(signer) != ed25519.PublicKeySize {
+		return false
+	}
+	signature, err := hex.DecodeString(cp.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(signer), checkpointMessage(cp.Height, cp.Hash), signature)
+}
+
+func checkpointMessage(height int, hash string) []byte {
+	return []byte("checkpoint:" + strconv.Itoa(height) + ":" + hash)
+}
+
+// ========================Collects the checkpoints a node enforces========================
+// Hard-coded checkpoints are always kept; signed ones only if signed by one of the trusted
+// keys. Two checkpoints pinning different hashes at a height are an error.
+func NewCheckpoints(signed []Checkpoint, trusted []string) (*Checkpoints, error) {
+	genesis := GenesisBlock()
+	checkpoints := &Checkpoints{byHeight: map[int]Checkpoint{}}
+	all := append([]Checkpoint{{Height: 0, Hash: genesis.Hash}}, HardCheckpoints...)
+	for _, cp := range signed {
+		if !containsKey(trusted, cp.Signer) {
+			fmt.Println("Ignoring checkpoint at height", cp.Height, "signed by untrusted key", cp.Signer)
+			continue
+		}
+		if !cp.Verify() {
+			return nil, fmt.Errorf("checkpoint at height %d has an invalid signature", cp.Height)
+		}
+		all = append(all, cp)
+	}
+
+	for _, cp := range all {
+		if cp.Height < 0 {
+			return nil, fmt.Errorf("checkpoint at invalid height %d", cp.Height)
+		}
+		if existing, ok := checkpoints.byHeight[cp.Height]; ok && existing.Hash != cp.Hash {
+			return nil, fmt.Errorf("conflicting checkpoints at height %d: %s and %s", cp.Height, existing.Hash, cp.Hash)
+		}
+		checkpoints.byHeight[cp.Height] = cp
+		if cp.Height >= checkpoints.highest.Height {
+			checkpoints.highest = cp
+		}
+	}
+	return checkpoints, nil
+}
+
+// ========================Loads signed checkpoints from a file========================
+// The file holds a JSON array of checkpoints; a missing file holds none
+func LoadCheckpoints(path string, trusted []string) (*Checkpoints, error) {
+	var signed []Checkpoint
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	default:
+		if err := json.Unmarshal(data, &signed); err != nil {
+			return nil, fmt.Errorf("invalid checkpoints in %s: %w", path, err)
+		}
+	}
+	return NewCheckpoints(signed, trusted)
+}
+
+// Returns the highest checkpoint, below which jobs are not re-executed
+func (c *Checkpoints) Highest() Checkpoint {
+	return c.highest
+}
+
+// Returns the checkpoints, lowest first
+func (c *Checkpoints) List() []Checkpoint {
+	list := make([]Checkpoint, 0, len(c.byHeight))
+	for _, cp := range c.byHeight {
+		list = append(list, cp)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Height < list[j].Height })
+	return list
+}
+
+// Checks that a block does not contradict a checkpoint at its height
+func (c *Checkpoints) Check(height int, hash string) error {
+	if cp, ok := c.byHeight[height]; ok && cp.Hash != hash {
+		return fmt.Errorf("block %s at height %d contradicts checkpoint %s", hash, height, cp.Hash)
+	}
+	return nil
+}
+
+// Returns the highest checkpoint at or below a height. A chain reaching that height and
+// matching the checkpoint has its blocks up to it accepted without re-executing their jobs.
+func (c *Checkpoints) HighestUpTo(height int) Checkpoint {
+	var highest Checkpoint
+	for _, cp := range c.byHeight {
+		if cp.Height <= height && cp.Height >= highest.Height {
+			highest = cp
+		}
+	}
+	return highest
+}
+
+// Reports whether a checkpoint pins the block at a height
+func (c *Checkpoints) Pins(height int) bool {
+	_, ok := c.byHeight[height]
+	return ok
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
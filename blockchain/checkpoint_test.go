@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointVerify(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	valid := SignCheckpoint(key, 5, "abcd")
+	other := SignCheckpoint(otherKey, 5, "abcd")
+
+	tests := []struct {
+		name   string
+		modify func(cp *Checkpoint)
+		valid  bool
+	}{
+		{"valid", func(cp *Checkpoint) {}, true},
+		{"other height", func(cp *Checkpoint) { cp.Height = 6 }, false},
+		{"other hash", func(cp *Checkpoint) { cp.Hash = "abce" }, false},
+		{"other signer", func(cp *Checkpoint) { cp.Signer = other.Signer }, false},
+		{"other signature", func(cp *Checkpoint) { cp.Signature = other.Signature }, false},
+		{"unsigned", func(cp *Checkpoint) { cp.Signer, cp.Signature = "", "" }, false},
+		{"short signer", func(cp *Checkpoint) { cp.Signer = cp.Signer[:10] }, false},
+		{"signature not hex", func(cp *Checkpoint) { cp.Signature = "zz" }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cp := valid
+			test.modify(&cp)
+			if cp.Verify() != test.valid {
+				t.Fatalf("Verify = %v, want %v", !test.valid, test.valid)
+			}
+		})
+	}
+}
+
+func TestNewCheckpoints(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	_, untrustedKey, _ := ed25519.GenerateKey(nil)
+	trusted := []string{hex.EncodeToString(key.Public().(ed25519.PublicKey))}
+	forged := SignCheckpoint(key, 3, "abcd")
+	forged.Hash = "ffff"
+
+	tests := []struct {
+		name    string
+		signed  []Checkpoint
+		err     string
+		highest int
+	}{
+		{"none", nil, "", 0},
+		{"trusted", []Checkpoint{SignCheckpoint(key, 3, "abcd"), SignCheckpoint(key, 7, "ef01")}, "", 7},
+		{"untrusted ignored", []Checkpoint{SignCheckpoint(untrustedKey, 9, "abcd")}, "", 0},
+		{"forged", []Checkpoint{forged}, "checkpoint at height 3 has an invalid signature", 0},
+		{"conflicting", []Checkpoint{SignCheckpoint(key, 3, "abcd"), SignCheckpoint(key, 3, "ef01")}, "conflicting checkpoints at height 3", 0},
+		{"contradicts genesis", []Checkpoint{SignCheckpoint(key, 0, "abcd")}, "conflicting checkpoints at height 0", 0},
+		{"negative height", []Checkpoint{SignCheckpoint(key, -1, "abcd")}, "checkpoint at invalid height -1", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkpoints, err := NewCheckpoints(test.signed, trusted)
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+			if highest := checkpoints.Highest().Height; highest != test.highest {
+				t.Fatalf("highest checkpoint at %d, want %d", highest, test.highest)
+			}
+			if err := checkpoints.Check(0, GenesisBlock().Hash); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLoadCheckpoints(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	trusted := []string{hex.EncodeToString(key.Public().(ed25519.PublicKey))}
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	data := `[{"Height":4,"Hash":"abcd","Signer":"` + trusted[0] + `","Signature":"` + SignCheckpoint(key, 4, "abcd").Signature + `"}]`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkpoints, err := LoadCheckpoints(path, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoints.Check(4, "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	checkError(t, checkpoints.Check(4, "ef01"), "contradicts checkpoint abcd")
+	if err := checkpoints.Check(5, "ef01"); err != nil {
+		t.Fatal(err)
+	}
+	if cp := checkpoints.HighestUpTo(3); cp.Height != 0 {
+		t.Fatalf("highest checkpoint up to 3 at %d", cp.Height)
+	}
+	if cp := checkpoints.HighestUpTo(10); cp.Height != 4 {
+		t.Fatalf("highest checkpoint up to 10 at %d", cp.Height)
+	}
+
+	if _, err := LoadCheckpoints(filepath.Join(t.TempDir(), "missing.json"), trusted); err != nil {
+		t.Fatalf("missing checkpoint file: %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ========================Chain Export========================
+
+// A range of the chain can be exported to a file and imported into another node, in one of
+// two formats:
+//   - JSON Lines, one block per line, for people and scripts
+//   - binary: the magic "BCHN", a version byte, then one record per block, each prefixed
+//     with its length as a uvarint. A record holds the height and nonce as uvarints, the
+//     hashes as length-prefixed raw bytes, the transaction count as a uvarint and every
+//     field of every transaction as length-prefixed bytes.
+
+const (
+	FormatJSONL  = "jsonl"
+	FormatBinary = "binary"
+)
+
+// Prefix of files in the binary format
+var binaryMagic = []byte("BCHN")
+
+// Version of the binary format
+const binaryVersion = 1
+
+// Records larger than this are rejected when reading, so a corrupt length cannot exhaust memory
+const maxRecordSize = 64 << 20
+
+// A block with its height, as exported
+type ExportedBlock struct {
+	Height       int           `json:"height"`
+	Hash         string        `json:"hash"`
+	PrevHash     string        `json:"prevHash"`
+	MerkleRoot   string        `json:"merkleRoot"`
+	Nonce        int           `json:"nonce"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+func ExportBlock(block *Block, height int) ExportedBlock {
+	return ExportedBlock{
+		Height:       height,
+		Hash:         block.Hash,
+		PrevHash:     block.PrevHash,
+		MerkleRoot:   block.MerkleRoot,
+		Nonce:        block.Nonce,
+		Transactions: block.Transactions,
+	}
+}
+
+// Returns the block, to be verified before it is added to a chain
+func (e ExportedBlock) Block() *Block {
+	return &Block{Hash: e.Hash, PrevHash: e.PrevHash, MerkleRoot: e.MerkleRoot, Nonce: e.Nonce, Transactions: e.Transactions}
+}
+
+type BlockWriter interface {
+	Write(block ExportedBlock) error
+	Flush() error // Writes buffered blocks out
+}
+
+type BlockReader interface {
+	Read() (ExportedBlock, error) // Returns io.EOF after the last block
+}
+
+// ========================Exports blocks of the chain========================
+// Writes the blocks from height from to height to, the tip when negative, and returns their
+// number. Pruned blocks cannot be exported.
+func (chain *Blockchain) Export(w BlockWriter, from, to int) (int, error) {
+	from, to, err := chain.ExportRange(from, to)
+	if err != nil {
+		return 0, err
+	}
+	blocks := chain.Snapshot()
+	count := 0
+	for height := from; height <= to; height++ {
+		if err := w.Write(ExportBlock(blocks[height], height)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, w.Flush()
+}
+
+// Checks a range of heights to export and returns it, to being the tip when negative or past it
+func (chain *Blockchain) ExportRange(from, to int) (int, int, error) {
+	height := chain.Height()
+	if to < 0 || to > height {
+		to = height
+	}
+	if from < 0 || from > to {
+		return from, to, fmt.Errorf("invalid height range %d to %d", from, to)
+	}
+	if pruned := chain.PrunedHeight(); from <= pruned {
+		return from, to, fmt.Errorf("%w: the transactions of blocks up to height %d were discarded", ErrPruned, pruned)
+	}
+	return from, to, nil
+}
+
+// Returns a writer of blocks in the given format
+func NewBlockWriter(w io.Writer, format string) (BlockWriter, error) {
+	buffered := bufio.NewWriter(w)
+	switch format {
+	case FormatJSONL, "":
+		return &jsonlWriter{w: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case FormatBinary:
+		buffered.Write(binaryMagic)
+		buffered.WriteByte(binaryVersion)
+		return &binaryWriter{w: buffered}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q, use %s or %s", format, FormatJSONL, FormatBinary)
+	}
+}
+
+// Returns a reader of the blocks in r, in either format
+func NewBlockReader(r io.Reader) (BlockReader, error) {
+	buffered := bufio.NewReader(r)
+	prefix, err := buffered.Peek(len(binaryMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Equal(prefix, binaryMagic) {
+		return &jsonlReader{decoder: json.NewDecoder(buffered)}, nil
+	}
+	buffered.Discard(len(binaryMagic))
+	version, err := buffered.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("invalid export: %w", err)
+	}
+	if version != binaryVersion {
+		return nil, fmt.Errorf("unsupported export version %d", version)
+	}
+	return &binaryReader{r: buffered}, nil
+}
+
+// ========================JSON Lines========================
+
+type jsonlWriter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (j *jsonlWriter) Write(block ExportedBlock) error {
+	return j.encoder.Encode(block)
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.w.Flush()
+}
+
+type jsonlReader struct {
+	decoder *json.Decoder
+}
+
+func (j *jsonlReader) Read() (ExportedBlock, error) {
+	var block ExportedBlock
+	if err := j.decoder.Decode(&block); err != nil {
+		if errors.Is(err, io.EOF) {
+			return block, io.EOF
+		}
+		return block, fmt.Errorf("invalid block: %w", err)
+	}
+	return block, nil
+}
+
+// ========================Binary========================
+
+type binaryWriter struct {
+	w      *bufio.Writer
+	record []byte
+}
+
+func (b *binaryWriter) Write(block ExportedBlock) error {
+	record := b.record[:0]
+	record = binary.AppendUvarint(record, uint64(block.Height))
+	for _, hash := range []string{block.Hash, block.PrevHash, block.MerkleRoot} {
+		raw, err := hex.DecodeString(hash)
+		if err != nil {
+			return fmt.Errorf("block %d has an invalid hash %q", block.Height, hash)
+		}
+		record = appendBytes(record, raw)
+	}
+	record = binary.AppendUvarint(record, uint64(block.Nonce))
+	record = binary.AppendUvarint(record, uint64(len(block.Transactions)))
+	for i := range block.Transactions {
+		for _, field := range transactionFields(&block.Transactions[i]) {
+			record = appendBytes(record, []byte(*field))
+		}
+		for _, field := range transactionRawFields(&block.Transactions[i]) {
+			record = appendBytes(record, *field)
+		}
+	}
+	b.record = record
+
+	b.w.Write(binary.AppendUvarint(nil, uint64(len(record))))
+	_, err := b.w.Write(record)
+	return err
+}
+
+func (b *binaryWriter) Flush() error {
+	return b.w.Flush()
+}
+
+type binaryReader struct {
+	r *bufio.Reader
+}
+
+func (b *binaryReader) Read() (ExportedBlock, error) {
+	var block ExportedBlock
+	size, err := binary.ReadUvarint(b.r)
+	if errors.Is(err, io.EOF) {
+		return block, io.EOF
+	}
+	if err != nil {
+		return block, fmt.Errorf("invalid block record length: %w", err)
+	}
+	if size > maxRecordSize {
+		return block, fmt.Errorf("block record of %d bytes exceeds %d", size, maxRecordSize)
+	}
+	record := make([]byte, size)
+	if _, err := io.ReadFull(b.r, record); err != nil {
+		return block, fmt.Errorf("truncated block record: %w", err)
+	}
+
+	d := &recordDecoder{data: record}
+	block.Height = int(d.uvarint())
+	block.Hash = hex.EncodeToString(d.bytes())
+	block.PrevHash = hex.EncodeToString(d.bytes())
+	block.MerkleRoot = hex.EncodeToString(d.bytes())
+	block.Nonce = int(d.uvarint())
+	count := d.uvarint()
+	if count > uint64(len(record)) {
+		return block, fmt.Errorf("invalid block record at height %d", block.Height)
+	}
+	block.Transactions = make([]Transaction, count)
+	for i := range block.Transactions {
+		for _, field := range transactionFields(&block.Transactions[i]) {
+			*field = string(d.bytes())
+		}
+		for _, field := range transactionRawFields(&block.Transactions[i]) {
+			if raw := d.bytes(); len(raw) > 0 {
+				*field = raw
+			}
+		}
+	}
+	if d.err != nil || len(d.data) > 0 {
+		return block, fmt.Errorf("invalid block record at height %d", block.Height)
+	}
+	return block, nil
+}
+
+// Fields of a transaction in the order of the binary format: the strings, then the raw JSON
+// fields, kept byte for byte so the transaction's ID does not change
+func transactionFields(tx *Transaction) []*string {
+	return []*string{&tx.DataHash, &tx.AlgoHash, &tx.Requirements, &tx.Output, &tx.OutputCID, &tx.Manifest, &tx.JobID, &tx.Miner, &tx.MinerSig}
+}
+
+func transactionRawFields(tx *Transaction) []*json.RawMessage {
+	return []*json.RawMessage{&tx.Spec, &tx.Result, &tx.Environment}
+}
+
+func appendBytes(record, data []byte) []byte {
+	record = binary.AppendUvarint(record, uint64(len(data)))
+	return append(record, data...)
+}
+
+// Reads the fields of a record, remembering the first error
+type recordDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *recordDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.data = d.data[n:]
+	return value
+}
+
+func (d *recordDecoder) bytes() []byte {
+	size := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if size > uint64(len(d.data)) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	value := d.data[:size]
+	d.data = d.data[size:]
+	return value
+}
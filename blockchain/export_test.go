@@ -0,0 +1,168 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func exportTestChain(t *testing.T) *Blockchain {
+	t.Helper()
+	chain, _ := InitBlockchain()
+	chain.AddBlock(testTransactions(3))
+	signed := Transaction{
+		DataHash:    "dataset",
+		AlgoHash:    "algorithm",
+		Output:      "output",
+		OutputCID:   "output-cid",
+		JobID:       "job",
+		Spec:        json.RawMessage(`{"output":{"mode":"raw"}}`),
+		Environment: json.RawMessage(`{"os":"linux"}`),
+	}
+	_, key, _ := ed25519.GenerateKey(nil)
+	signed.SignMiner(key)
+	chain.AddBlock([]Transaction{signed})
+	chain.AddBlock(nil)
+	return chain
+}
+
+func readAll(r BlockReader) ([]ExportedBlock, error) {
+	var blocks []ExportedBlock
+	for {
+		block, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return blocks, nil
+		}
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	chain := exportTestChain(t)
+	for _, format := range []string{FormatJSONL, FormatBinary} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer, err := NewBlockWriter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, err := chain.Export(writer, 1, -1)
+			if err != nil || count != 3 {
+				t.Fatalf("exported %d blocks: %v", count, err)
+			}
+			if format == FormatBinary && !bytes.HasPrefix(buf.Bytes(), binaryMagic) {
+				t.Fatal("binary export does not start with the magic")
+			}
+
+			reader, err := NewBlockReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks, err := readAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(blocks) != count {
+				t.Fatalf("read %d blocks, want %d", len(blocks), count)
+			}
+			for i, exported := range blocks {
+				original := chain.Blocks[i+1]
+				if exported.Height != i+1 {
+					t.Errorf("block %d read at height %d", i+1, exported.Height)
+				}
+				block := exported.Block()
+				if block.Hash != original.Hash || MerkleRoot(block.Transactions) != original.MerkleRoot {
+					t.Errorf("block %d does not match the original", i+1)
+				}
+				if len(original.Transactions) > 0 && !reflect.DeepEqual(block.Transactions, original.Transactions) {
+					t.Errorf("transactions of block %d differ", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestBinaryImportMalformed(t *testing.T) {
+	chain := exportTestChain(t)
+	var buf bytes.Buffer
+	writer, _ := NewBlockWriter(&buf, FormatBinary)
+	if _, err := chain.Export(writer, 1, -1); err != nil {
+		t.Fatal(err)
+	}
+	export := buf.Bytes()
+	header := len(binaryMagic) + 1
+
+	frame := func(data ...byte) []byte {
+		return append(append([]byte(nil), export[:header]...), data...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"truncated record", export[:len(export)-1], "truncated block record"},
+		{"truncated length", frame(0x80), "invalid block record length"},
+		{"overflowing length", frame(0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01), "invalid block record length"},
+		{"oversized record", frame(0x80, 0x80, 0x80, 0x80, 0x10), "exceeds"},
+		{"hash past the record", frame(3, 1, 5, 0), "invalid block record at height 1"},
+		{"trailing bytes", append(append([]byte(nil), export...), 1, 0), "invalid block record at height 0"},
+		{"unknown version", append(append([]byte(nil), binaryMagic...), binaryVersion+1), "unsupported export version 2"},
+		{"magic only", binaryMagic, "invalid export"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := NewBlockReader(bytes.NewReader(test.data))
+			if err == nil {
+				_, err = readAll(reader)
+			}
+			checkError(t, err, test.err)
+		})
+	}
+}
+
+func TestExportRange(t *testing.T) {
+	chain := exportTestChain(t)
+	if _, err := chain.Prune(1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to int
+		want     int // Last height exported
+		err      string
+	}{
+		{"tip by default", 3, -1, 3, ""},
+		{"to past the tip", 3, 10, 3, ""},
+		{"from past to", 3, 2, 0, "invalid height range 3 to 2"},
+		{"negative from", -1, 2, 0, "invalid height range -1 to 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, to, err := chain.ExportRange(test.from, test.to)
+			checkError(t, err, test.err)
+			if err == nil && to != test.want {
+				t.Fatalf("range ends at %d, want %d", to, test.want)
+			}
+		})
+	}
+
+	// Blocks whose transactions were discarded cannot be exported
+	for _, from := range []int{0, 1, 2} {
+		if _, _, err := chain.ExportRange(from, -1); !errors.Is(err, ErrPruned) {
+			t.Fatalf("export from %d: error %v, want ErrPruned", from, err)
+		}
+	}
+	writer, _ := NewBlockWriter(io.Discard, FormatJSONL)
+	if _, err := chain.Export(writer, 1, 3); !errors.Is(err, ErrPruned) {
+		t.Fatalf("export of pruned blocks: error %v, want ErrPruned", err)
+	}
+}
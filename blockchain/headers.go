@@ -0,0 +1,86 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ========================Header Chain========================
+
+// Light clients follow the chain by its headers alone. Each header is checked for its
+// proof-of-work at the chain's difficulty and for its link to the header before it, so a
+// Merkle proof checked against a header shows a transaction is on the chain.
+
+type HeaderChain struct {
+	headers []BlockHeader
+	mu      sync.RWMutex
+}
+
+// Creates a header chain starting at the genesis block
+func NewHeaderChain() *HeaderChain {
+	return &HeaderChain{headers: []BlockHeader{GenesisBlock().Header()}}
+}
+
+// Height of the latest header, the genesis header being at height 0
+func (chain *HeaderChain) Height() int {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return len(chain.headers) - 1
+}
+
+// Returns the latest header
+func (chain *HeaderChain) Tip() BlockHeader {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return chain.headers[len(chain.headers)-1]
+}
+
+// Returns the header at the given height
+func (chain *HeaderChain) HeaderAt(height int) (BlockHeader, bool) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	if height < 0 || height >= len(chain.headers) {
+		return BlockHeader{}, false
+	}
+	return chain.headers[height], true
+}
+
+// Returns the header with the given hash and its height
+func (chain *HeaderChain) HeaderByHash(hash string) (BlockHeader, int, bool) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	for i := len(chain.headers) - 1; i >= 0; i-- {
+		if chain.headers[i].Hash == hash {
+			return chain.headers[i], i, true
+		}
+	}
+	return BlockHeader{}, 0, false
+}
+
+// ========================Extends the chain with headers========================
+// The headers follow the header at height from-1. Headers already at those heights are
+// replaced, which only a longer chain may do. None are added if any is invalid.
+func (chain *HeaderChain) Connect(from int, headers []BlockHeader) error {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	if from < 1 || from > len(chain.headers) {
+		return fmt.Errorf("cannot connect headers at height %d to a chain of height %d", from, len(chain.headers)-1)
+	}
+	if from < len(chain.headers) && from+len(headers) <= len(chain.headers) {
+		return fmt.Errorf("the chain forking at height %d is not longer than ours", from)
+	}
+	prev := chain.headers[from-1]
+	for i, header := range headers {
+		height := from + i
+		if header.PrevHash != prev.Hash {
+			return fmt.Errorf("header %d (%s) does not link to header %d (%s)", height, header.Hash, height-1, prev.Hash)
+		}
+		if !header.Validate() {
+			return fmt.Errorf("header %d (%s) has an invalid hash or proof-of-work", height, header.Hash)
+		}
+		prev = header
+	}
+	chain.headers = append(chain.headers[:from], headers...)
+	return nil
+}
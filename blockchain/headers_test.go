@@ -0,0 +1,58 @@
+package blockchain
+
+import "testing"
+
+// Returns the headers of n blocks mined on the genesis block, after it
+func minedHeaders(t *testing.T, n int, data string) []BlockHeader {
+	t.Helper()
+	chain, _ := InitBlockchain()
+	headers := make([]BlockHeader, n)
+	for i := range headers {
+		block, _ := chain.AddBlock([]Transaction{{DataHash: data, Output: string(rune('a' + i))}})
+		headers[i] = block.Header()
+	}
+	return headers
+}
+
+func TestHeaderChainConnect(t *testing.T) {
+	main := minedHeaders(t, 3, "main")
+	fork := minedHeaders(t, 4, "fork")
+	badWork := append([]BlockHeader(nil), main...)
+	badWork[1].Nonce++
+
+	tests := []struct {
+		name    string
+		before  []BlockHeader // Connected at height 1 first
+		from    int
+		headers []BlockHeader
+		err     string
+		tip     string // Hash of the tip afterwards
+	}{
+		{"extends the genesis", nil, 1, main, "", main[2].Hash},
+		{"extends the tip", main[:2], 3, main[2:], "", main[2].Hash},
+		{"no headers", nil, 1, nil, "", GenesisBlock().Hash},
+		{"height 0", nil, 0, main, "cannot connect headers at height 0", GenesisBlock().Hash},
+		{"past the tip", nil, 2, main[1:], "cannot connect headers at height 2", GenesisBlock().Hash},
+		{"broken link", nil, 1, []BlockHeader{main[0], main[2]}, "header 2 (" + main[2].Hash + ") does not link", GenesisBlock().Hash},
+		{"invalid proof-of-work", nil, 1, badWork, "header 2 (" + main[1].Hash + ") has an invalid hash", GenesisBlock().Hash},
+		{"longer fork replaces", main, 1, fork, "", fork[3].Hash},
+		{"fork of equal length", main, 1, fork[:3], "not longer than ours", main[2].Hash},
+		{"shorter fork", main, 1, fork[:1], "not longer than ours", main[2].Hash},
+		{"fork not linking", main, 2, fork[1:], "header 2 (" + fork[1].Hash + ") does not link", main[2].Hash},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := NewHeaderChain()
+			if err := chain.Connect(1, test.before); err != nil {
+				t.Fatal(err)
+			}
+			checkError(t, chain.Connect(test.from, test.headers), test.err)
+			if tip := chain.Tip(); tip.Hash != test.tip {
+				t.Fatalf("tip %s, want %s", tip.Hash, test.tip)
+			}
+			if _, height, ok := chain.HeaderByHash(test.tip); !ok || height != chain.Height() {
+				t.Fatalf("tip found at height %d, chain height %d", height, chain.Height())
+			}
+		})
+	}
+}
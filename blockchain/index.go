@@ -0,0 +1,209 @@
+package blockchain
+
+import "encoding/json"
+
+// ========================Explorer Indexes========================
+
+// The chain keeps secondary indexes of its transactions, updated as blocks are connected and
+// disconnected, so explorer queries such as "every result computed on dataset X" do not scan
+// every block. Entries are kept in chain order, oldest first.
+
+// Location of a transaction in the chain
+type TxRef struct {
+	ID       string // ID of the transaction
+	Height   int    // Height of its block
+	Block    string // Hash of its block
+	Position int    // Index of the transaction in its block
+}
+
+// A transaction with its location in the chain
+type IndexedTransaction struct {
+	TxRef
+	Transaction Transaction // Zero when Pruned
+	Pruned      bool        // Whether the transaction was discarded with the body of its block
+}
+
+// A block a miner computed transactions in
+type BlockRef struct {
+	Height int
+	Hash   string
+}
+
+// Window of a query's results
+type Page struct {
+	Offset int // Results to skip
+	Limit  int // Results to return, DefaultPageLimit when 0, at most MaxPageLimit
+}
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+type Index struct {
+	txs        map[string][]TxRef // Identical transactions share an ID, so an ID may be found in several blocks
+	datasets   map[string][]TxRef
+	algorithms map[string][]TxRef
+	outputs    map[string][]TxRef
+	miners     map[string][]BlockRef
+}
+
+func NewIndex() *Index {
+	return &Index{
+		txs:        map[string][]TxRef{},
+		datasets:   map[string][]TxRef{},
+		algorithms: map[string][]TxRef{},
+		outputs:    map[string][]TxRef{},
+		miners:     map[string][]BlockRef{},
+	}
+}
+
+// ========================Transaction ID========================
+// The SHA-256 of the transaction as encoded in its block
+func (tx *Transaction) ID() string {
+	data, _ := json.Marshal(tx)
+	return HashData(string(data))
+}
+
+// ========================Connects a block to the indexes========================
+// Blocks must be connected in chain order
+func (index *Index) Connect(block *Block, height int) {
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		ref := TxRef{ID: tx.ID(), Height: height, Block: block.Hash, Position: i}
+		index.txs[ref.ID] = append(index.txs[ref.ID], ref)
+		index.datasets[tx.DataHash] = append(index.datasets[tx.DataHash], ref)
+		index.algorithms[tx.AlgoHash] = append(index.algorithms[tx.AlgoHash], ref)
+		index.outputs[tx.Output] = append(index.outputs[tx.Output], ref)
+
+		// Miners are credited only with the transactions they signed
+		miner := tx.VerifiedMiner()
+		if miner == "" {
+			continue
+		}
+		blocks := index.miners[miner]
+		if len(blocks) == 0 || blocks[len(blocks)-1].Height != height {
+			index.miners[miner] = append(blocks, BlockRef{Height: height, Hash: block.Hash})
+		}
+	}
+}
+
+// ========================Disconnects a block from the indexes========================
+// Only the latest connected block may be disconnected
+func (index *Index) Disconnect(block *Block, height int) {
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		dropTxRefs(index.txs, tx.ID(), height)
+		dropTxRefs(index.datasets, tx.DataHash, height)
+		dropTxRefs(index.algorithms, tx.AlgoHash, height)
+		dropTxRefs(index.outputs, tx.Output, height)
+
+		miner := tx.VerifiedMiner()
+		if miner == "" {
+			continue
+		}
+		blocks := index.miners[miner]
+		if n := len(blocks); n > 0 && blocks[n-1].Height == height {
+			blocks = blocks[:n-1]
+		}
+		if len(blocks) == 0 {
+			delete(index.miners, miner)
+		} else {
+			index.miners[miner] = blocks
+		}
+	}
+}
+
+// Removes the entries of a key at a height, which are the last ones since blocks are
+// disconnected from the tip
+func dropTxRefs(entries map[string][]TxRef, key string, height int) {
+	refs := entries[key]
+	for len(refs) > 0 && refs[len(refs)-1].Height == height {
+		refs = refs[:len(refs)-1]
+	}
+	if len(refs) == 0 {
+		delete(entries, key)
+	} else {
+		entries[key] = refs
+	}
+}
+
+// ========================Pagination========================
+// Returns the results of the page and the total number of results
+func paginate[T any](results []T, page Page) ([]T, int) {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	total := len(results)
+	start := page.Offset
+	if start < 0 {
+		start = 0
+	}
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	return append([]T(nil), results[start:end]...), total
+}
+
+// ========================Chain queries by index========================
+
+// Returns the earliest transaction with the given ID
+func (chain *Blockchain) TransactionByID(id string) (IndexedTransaction, bool) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	refs := chain.index.txs[id]
+	if len(refs) == 0 {
+		return IndexedTransaction{}, false
+	}
+	return chain.resolve(refs[0]), true
+}
+
+// Returns the transactions computed on a dataset, by CID
+func (chain *Blockchain) TransactionsByDataset(cid string, page Page) ([]IndexedTransaction, int) {
+	return chain.transactions(func(index *Index) []TxRef { return index.datasets[cid] }, page)
+}
+
+// Returns the transactions that ran an algorithm, by CID
+func (chain *Blockchain) TransactionsByAlgorithm(cid string, page Page) ([]IndexedTransaction, int) {
+	return chain.transactions(func(index *Index) []TxRef { return index.algorithms[cid] }, page)
+}
+
+// Returns the transactions with the given output hash
+func (chain *Blockchain) TransactionsByOutput(hash string, page Page) ([]IndexedTransaction, int) {
+	return chain.transactions(func(index *Index) []TxRef { return index.outputs[hash] }, page)
+}
+
+// Returns the blocks holding transactions computed by a miner, by public key
+func (chain *Blockchain) BlocksByMiner(miner string, page Page) ([]BlockRef, int) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return paginate(chain.index.miners[miner], page)
+}
+
+func (chain *Blockchain) transactions(lookup func(*Index) []TxRef, page Page) ([]IndexedTransaction, int) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	refs, total := paginate(lookup(chain.index), page)
+	results := make([]IndexedTransaction, 0, len(refs))
+	for _, ref := range refs {
+		results = append(results, chain.resolve(ref))
+	}
+	return results, total
+}
+
+// Returns the transaction a reference points to; the chain must be locked
+func (chain *Blockchain) resolve(ref TxRef) IndexedTransaction {
+	block := chain.Blocks[ref.Height]
+	if block.Pruned {
+		return IndexedTransaction{TxRef: ref, Pruned: true}
+	}
+	return IndexedTransaction{TxRef: ref, Transaction: block.Transactions[ref.Position]}
+}
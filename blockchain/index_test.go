@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func signedTransaction(key ed25519.PrivateKey, output string) Transaction {
+	tx := Transaction{DataHash: "dataset", AlgoHash: "algorithm", Output: output}
+	tx.SignMiner(key)
+	return tx
+}
+
+func TestIndexDisconnect(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	chain, _ := InitBlockchain()
+	empty, _ := InitBlockchain()
+
+	if _, ok := chain.DisconnectTip(); ok {
+		t.Fatal("the genesis block was disconnected")
+	}
+
+	first, _ := chain.AddBlock([]Transaction{signedTransaction(key, "a"), {DataHash: "dataset", Output: "b"}})
+	second, _ := chain.AddBlock([]Transaction{signedTransaction(key, "a"), signedTransaction(key, "c")})
+	for _, want := range []*Block{second, first} {
+		block, ok := chain.DisconnectTip()
+		if !ok || block != want {
+			t.Fatalf("disconnected %v, want block %s", block, want.Hash)
+		}
+	}
+	if !reflect.DeepEqual(chain.index, empty.index) {
+		t.Fatalf("indexes not empty after disconnecting every block: %+v", chain.index)
+	}
+	if chain.Height() != 0 {
+		t.Fatalf("height %d after disconnecting every block", chain.Height())
+	}
+
+	chain.AddBlock(testTransactions(2))
+	chain.AddBlock(testTransactions(2))
+	if _, err := chain.Prune(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := chain.DisconnectTip(); !ok {
+		t.Fatal("the tip above the pruned blocks was not disconnected")
+	}
+	if _, ok := chain.DisconnectTip(); ok {
+		t.Fatal("a pruned block was disconnected")
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	results := make([]int, 2*MaxPageLimit)
+	for i := range results {
+		results[i] = i
+	}
+
+	tests := []struct {
+		name  string
+		page  Page
+		first int // First result of the page, -1 when the page is empty
+		count int
+	}{
+		{"default limit", Page{}, 0, DefaultPageLimit},
+		{"limit", Page{Offset: 10, Limit: 5}, 10, 5},
+		{"limit above the maximum", Page{Limit: MaxPageLimit + 1}, 0, MaxPageLimit},
+		{"negative limit", Page{Limit: -1}, 0, DefaultPageLimit},
+		{"negative offset", Page{Offset: -5, Limit: 3}, 0, 3},
+		{"last page", Page{Offset: len(results) - 2, Limit: 5}, len(results) - 2, 2},
+		{"offset at the end", Page{Offset: len(results)}, -1, 0},
+		{"offset past the end", Page{Offset: len(results) + 10}, -1, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, total := paginate(results, test.page)
+			if total != len(results) {
+				t.Fatalf("total %d, want %d", total, len(results))
+			}
+			if len(page) != test.count {
+				t.Fatalf("%d results, want %d", len(page), test.count)
+			}
+			if test.count > 0 && page[0] != test.first {
+				t.Fatalf("page starts at %d, want %d", page[0], test.first)
+			}
+		})
+	}
+}
+
+func TestIndexMinerCredit(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	miner := hex.EncodeToString(key.Public().(ed25519.PublicKey))
+
+	claimed := Transaction{DataHash: "dataset", Output: "claimed", Miner: miner}
+	forged := signedTransaction(otherKey, "forged")
+	forged.Miner = miner
+	modified := signedTransaction(key, "modified")
+	modified.Output = "changed"
+
+	tests := []struct {
+		name   string
+		blocks [][]Transaction
+		want   []int // Heights of the blocks credited to the miner
+	}{
+		{"signed", [][]Transaction{{signedTransaction(key, "a")}}, []int{1}},
+		{"one entry per block", [][]Transaction{{signedTransaction(key, "a"), signedTransaction(key, "b")}}, []int{1}},
+		{"several blocks", [][]Transaction{{signedTransaction(key, "a")}, {{Output: "b"}}, {signedTransaction(key, "c")}}, []int{1, 3}},
+		{"unsigned claim", [][]Transaction{{claimed}}, nil},
+		{"signed by another key", [][]Transaction{{forged}}, nil},
+		{"modified after signing", [][]Transaction{{modified}}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain, _ := InitBlockchain()
+			for _, txs := range test.blocks {
+				chain.AddBlock(txs)
+			}
+			blocks, total := chain.BlocksByMiner(miner, Page{})
+			if total != len(test.want) {
+				t.Fatalf("%d blocks credited, want %d", total, len(test.want))
+			}
+			for i, block := range blocks {
+				if want, _ := chain.BlockAt(test.want[i]); block.Height != test.want[i] || block.Hash != want.Hash {
+					t.Fatalf("block %d credited at height %d, want %d", i, block.Height, test.want[i])
+				}
+			}
+			if found, _ := chain.TransactionsByDataset("dataset", Page{}); len(found) != countTransactions(test.blocks, "dataset") {
+				t.Fatalf("%d transactions indexed by dataset", len(found))
+			}
+		})
+	}
+}
+
+func countTransactions(blocks [][]Transaction, dataset string) int {
+	n := 0
+	for _, txs := range blocks {
+		for _, tx := range txs {
+			if tx.DataHash == dataset {
+				n++
+			}
+		}
+	}
+	return n
+}
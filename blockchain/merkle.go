@@ -0,0 +1,148 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ========================Merkle Tree========================
+
+// The header of a block commits to its transactions through the root of a Merkle tree over
+// their IDs, so a transaction can be shown to be in a block with a proof of a few hashes
+// instead of the whole block. An inner node is SHA-256(0x01 || left || right); a node
+// without a sibling is carried up to the next level unchanged. Transaction IDs hash JSON,
+// which never starts with 0x01, so a leaf cannot pass for an inner node.
+
+// Root of a block without transactions
+var emptyMerkleRoot = HashData("")
+
+// The fields of a block its proof-of-work hash covers
+type BlockHeader struct {
+	Hash       string
+	PrevHash   string
+	MerkleRoot string
+	Nonce      int
+}
+
+// A sibling on the path from a transaction to the Merkle root
+type ProofStep struct {
+	Hash string // Hash of the sibling, hex encoded
+	Left bool   // Whether the sibling is the left node
+}
+
+// Proof that a transaction is a leaf of the tree with the given root
+type MerkleProof struct {
+	TxID  string
+	Root  string
+	Steps []ProofStep // From the leaf up
+}
+
+// ========================Computes the Merkle root of transactions========================
+func MerkleRoot(transactions []Transaction) string {
+	if len(transactions) == 0 {
+		return emptyMerkleRoot
+	}
+	level := merkleLeaves(transactions)
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// ========================Builds the inclusion proof of a transaction========================
+// position is the index of the transaction in the list
+func NewMerkleProof(transactions []Transaction, position int) (MerkleProof, error) {
+	if position < 0 || position >= len(transactions) {
+		return MerkleProof{}, fmt.Errorf("no transaction at position %d", position)
+	}
+	level := merkleLeaves(transactions)
+	proof := MerkleProof{TxID: hex.EncodeToString(level[position])}
+	for len(level) > 1 {
+		sibling := position ^ 1
+		if sibling < len(level) {
+			proof.Steps = append(proof.Steps, ProofStep{Hash: hex.EncodeToString(level[sibling]), Left: sibling < position})
+		}
+		level = merkleLevel(level)
+		position /= 2
+	}
+	proof.Root = hex.EncodeToString(level[0])
+	return proof, nil
+}
+
+// ========================Checks a proof leads from its transaction to its root========================
+func (proof MerkleProof) Verify() bool {
+	node, err := hex.DecodeString(proof.TxID)
+	if err != nil || len(node) != sha256.Size {
+		return false
+	}
+	for _, step := range proof.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return false
+		}
+		if step.Left {
+			node = merkleNode(sibling, node)
+		} else {
+			node = merkleNode(node, sibling)
+		}
+	}
+	return hex.EncodeToString(node) == proof.Root
+}
+
+// ========================Checks that a transaction is recorded in a block========================
+// Needs only the header of the block: the proof links the transaction to the Merkle root,
+// and the proof-of-work of the header commits to the root
+func VerifyInclusion(tx Transaction, header BlockHeader, proof MerkleProof) error {
+	if tx.ID() != proof.TxID {
+		return errors.New("the proof is not for this transaction")
+	}
+	if proof.Root != header.MerkleRoot {
+		return errors.New("the proof is not for this block")
+	}
+	if !proof.Verify() {
+		return errors.New("the proof does not lead to the Merkle root")
+	}
+	if !header.Validate() {
+		return errors.New("the block header has an invalid hash or proof-of-work")
+	}
+	return nil
+}
+
+// ========================Checks the proof-of-work of a header========================
+func (header BlockHeader) Validate() bool {
+	block := &Block{Hash: header.Hash, PrevHash: header.PrevHash, MerkleRoot: header.MerkleRoot, Nonce: header.Nonce}
+	return NewProof(block).Validate()
+}
+
+// ========================Returns the header of a block========================
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{Hash: b.Hash, PrevHash: b.PrevHash, MerkleRoot: b.MerkleRoot, Nonce: b.Nonce}
+}
+
+func merkleLeaves(transactions []Transaction) [][]byte {
+	leaves := make([][]byte, len(transactions))
+	for i := range transactions {
+		leaves[i], _ = hex.DecodeString(transactions[i].ID())
+	}
+	return leaves
+}
+
+// Hashes the nodes of a level in pairs, carrying an odd last node up
+func merkleLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNode(level[i], level[i+1]))
+	}
+	return next
+}
+
+func merkleNode(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{0x01}, left...), right...))
+	return hash[:]
+}
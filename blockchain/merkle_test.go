@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Fails unless err contains want, or is nil when want is empty
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("no error, want %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("error %q, want %q", err, want)
+	}
+}
+
+func testTransactions(n int) []Transaction {
+	txs := make([]Transaction, n)
+	for i := range txs {
+		txs[i] = Transaction{DataHash: "dataset-" + strconv.Itoa(i), AlgoHash: "algorithm", Output: "output-" + strconv.Itoa(i)}
+	}
+	return txs
+}
+
+func TestMerkleRoot(t *testing.T) {
+	leaf := func(txs []Transaction, i int) []byte {
+		id, _ := hex.DecodeString(txs[i].ID())
+		return id
+	}
+	tests := []struct {
+		name string
+		n    int
+		root func(txs []Transaction) []byte // Tree built by hand
+	}{
+		{"one leaf", 1, func(txs []Transaction) []byte { return leaf(txs, 0) }},
+		{"two leaves", 2, func(txs []Transaction) []byte { return merkleNode(leaf(txs, 0), leaf(txs, 1)) }},
+		{"three leaves", 3, func(txs []Transaction) []byte {
+			return merkleNode(merkleNode(leaf(txs, 0), leaf(txs, 1)), leaf(txs, 2))
+		}},
+		{"five leaves", 5, func(txs []Transaction) []byte {
+			left := merkleNode(merkleNode(leaf(txs, 0), leaf(txs, 1)), merkleNode(leaf(txs, 2), leaf(txs, 3)))
+			return merkleNode(left, leaf(txs, 4))
+		}},
+		{"six leaves", 6, func(txs []Transaction) []byte {
+			left := merkleNode(merkleNode(leaf(txs, 0), leaf(txs, 1)), merkleNode(leaf(txs, 2), leaf(txs, 3)))
+			return merkleNode(left, merkleNode(leaf(txs, 4), leaf(txs, 5)))
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			txs := testTransactions(test.n)
+			if got, want := MerkleRoot(txs), hex.EncodeToString(test.root(txs)); got != want {
+				t.Fatalf("root %s, want %s", got, want)
+			}
+		})
+	}
+
+	if MerkleRoot(nil) != HashData("") {
+		t.Error("unexpected root of a block without transactions")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 4, 5, 7, 8, 9} {
+		t.Run(strconv.Itoa(n)+" leaves", func(t *testing.T) {
+			txs := testTransactions(n)
+			root := MerkleRoot(txs)
+			for position := range txs {
+				proof, err := NewMerkleProof(txs, position)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if proof.Root != root || proof.TxID != txs[position].ID() {
+					t.Fatalf("proof of position %d is for %s under %s", position, proof.TxID, proof.Root)
+				}
+				if !proof.Verify() {
+					t.Fatalf("proof of position %d rejected", position)
+				}
+				for i := range proof.Steps {
+					tampered := proof
+					tampered.Steps = append([]ProofStep(nil), proof.Steps...)
+					tampered.Steps[i].Left = !tampered.Steps[i].Left
+					if tampered.Verify() {
+						t.Fatalf("proof of position %d accepted with step %d on the wrong side", position, i)
+					}
+				}
+			}
+			_, err := NewMerkleProof(txs, n)
+			checkError(t, err, "no transaction at position")
+		})
+	}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	chain, _ := InitBlockchain()
+	txs := testTransactions(3)
+	block, _ := chain.AddBlock(txs)
+	proof, err := NewMerkleProof(block.Transactions, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tx     Transaction
+		header BlockHeader
+		err    string
+	}{
+		{"included", txs[2], block.Header(), ""},
+		{"other transaction", txs[1], block.Header(), "not for this transaction"},
+		{"other block", txs[2], chain.Blocks[0].Header(), "not for this block"},
+		{"bad proof-of-work", txs[2], BlockHeader{Hash: block.Hash, PrevHash: block.PrevHash, MerkleRoot: block.MerkleRoot, Nonce: block.Nonce + 1}, "invalid hash or proof-of-work"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkError(t, VerifyInclusion(test.tx, test.header, proof), test.err)
+		})
+	}
+}
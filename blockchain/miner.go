@@ -0,0 +1,43 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+)
+
+// ========================Miner Signatures========================
+
+// A miner signs the transactions it computes with Ed25519: Miner holds its public key and
+// MinerSig the signature of the transaction's ID taken with MinerSig empty. Only a miner whose
+// signature checks out is credited with a transaction, so no one can claim another's work or
+// pin their own on someone else.
+
+// ========================Signs a transaction as its miner========================
+// Must be called once every other field is set, since they are all covered
+func (tx *Transaction) SignMiner(key ed25519.PrivateKey) {
+	tx.Miner = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	tx.MinerSig = hex.EncodeToString(ed25519.Sign(key, tx.minerMessage()))
+}
+
+// ========================Returns the verified miner of a transaction========================
+// The miner's public key, or "" when the transaction is unsigned or its signature is invalid
+func (tx *Transaction) VerifiedMiner() string {
+	if tx.Miner == "" {
+		return ""
+	}
+	public, err := hex.DecodeString(tx.Miner)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return ""
+	}
+	signature, err := hex.DecodeString(tx.MinerSig)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(public), tx.minerMessage(), signature) {
+		return ""
+	}
+	return tx.Miner
+}
+
+func (tx *Transaction) minerMessage() []byte {
+	unsigned := *tx
+	unsigned.MinerSig = ""
+	return []byte("transaction:" + unsigned.ID())
+}
@@ -0,0 +1,44 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ========================Pruning========================
+
+// A pruned chain discards the transactions of blocks buried deeper than a retention depth,
+// keeping their headers, so the chain can still be linked and its proof-of-work checked,
+// and the indexes, so queries still find where a transaction was recorded. Archive nodes
+// keep every block.
+
+// Returned when the transactions of a block were discarded
+var ErrPruned = errors.New("pruned")
+
+// ========================Discards the transactions of old blocks========================
+// Keeps the transactions of the latest depth blocks and returns the blocks whose
+// transactions were discarded, as they were before pruning
+func (chain *Blockchain) Prune(depth int) ([]*Block, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("invalid retention depth %d", depth)
+	}
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	var pruned []*Block
+	for height := chain.pruned + 1; height < len(chain.Blocks)-depth; height++ {
+		block := chain.Blocks[height]
+		pruned = append(pruned, block)
+		// Replace the block rather than clearing it, since readers may hold it
+		chain.Blocks[height] = &Block{Hash: block.Hash, PrevHash: block.PrevHash, MerkleRoot: block.MerkleRoot, Nonce: block.Nonce, Pruned: true}
+		chain.pruned = height
+	}
+	return pruned, nil
+}
+
+// Height of the latest block whose transactions were discarded, -1 if none
+func (chain *Blockchain) PrunedHeight() int {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return chain.pruned
+}
@@ -0,0 +1,169 @@
+package blockchain
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// Returns a chain of blocks up to the given height, each holding one transaction on the
+// dataset "dataset-<height>"
+func pruneTestChain(height int) *Blockchain {
+	chain, _ := InitBlockchain()
+	for i := 1; i <= height; i++ {
+		chain.AddBlock([]Transaction{{DataHash: "dataset-" + strconv.Itoa(i), AlgoHash: "algorithm", Output: "output-" + strconv.Itoa(i)}})
+	}
+	return chain
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name   string
+		depth  int
+		pruned int // Height of the latest pruned block
+		err    string
+	}{
+		{"latest block kept", 1, 4, ""},
+		{"latest blocks kept", 3, 2, ""},
+		{"every block kept", 6, -1, ""},
+		{"depth above the height", 10, -1, ""},
+		{"zero depth", 0, -1, "invalid retention depth 0"},
+		{"negative depth", -1, -1, "invalid retention depth -1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := pruneTestChain(5)
+			before := chain.Snapshot()
+
+			pruned, err := chain.Prune(test.depth)
+			checkError(t, err, test.err)
+			if chain.PrunedHeight() != test.pruned {
+				t.Fatalf("pruned up to height %d, want %d", chain.PrunedHeight(), test.pruned)
+			}
+			if len(pruned) != test.pruned+1 {
+				t.Fatalf("%d blocks pruned, want %d", len(pruned), test.pruned+1)
+			}
+			for height, block := range chain.Snapshot() {
+				if block.Hash != before[height].Hash {
+					t.Fatalf("block %d changed hash", height)
+				}
+				if height > test.pruned {
+					if block != before[height] {
+						t.Fatalf("block %d above the pruned height replaced", height)
+					}
+					continue
+				}
+				// The blocks returned are the blocks as they were
+				if pruned[height] != before[height] || len(pruned[height].Transactions) != len(before[height].Transactions) {
+					t.Fatalf("pruned block %d not returned as it was", height)
+				}
+				if !block.Pruned || len(block.Transactions) != 0 {
+					t.Fatalf("block %d kept its transactions", height)
+				}
+			}
+			if err := VerifyBlocks(chain.Snapshot()); err != nil {
+				t.Fatalf("pruned chain invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestPruneIdempotent(t *testing.T) {
+	chain := pruneTestChain(5)
+	if _, err := chain.Prune(2); err != nil {
+		t.Fatal(err)
+	}
+	before := chain.Snapshot()
+
+	pruned, err := chain.Prune(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 0 || chain.PrunedHeight() != 3 {
+		t.Fatalf("pruning again discarded %d blocks, pruned height %d", len(pruned), chain.PrunedHeight())
+	}
+	for height, block := range chain.Snapshot() {
+		if block != before[height] {
+			t.Fatalf("block %d replaced by pruning again", height)
+		}
+	}
+
+	// A new block buries one more block past the depth
+	chain.AddBlock(testTransactions(1))
+	pruned, err = chain.Prune(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0].Hash != before[4].Hash || chain.PrunedHeight() != 4 {
+		t.Fatalf("pruned %d blocks up to height %d after a new block, want block 4", len(pruned), chain.PrunedHeight())
+	}
+
+	// A larger depth does not restore discarded transactions
+	if pruned, _ := chain.Prune(5); len(pruned) != 0 || chain.PrunedHeight() != 4 {
+		t.Fatalf("larger depth pruned %d blocks, pruned height %d", len(pruned), chain.PrunedHeight())
+	}
+}
+
+func TestPrunedSnapshot(t *testing.T) {
+	chain := pruneTestChain(5)
+	if _, err := chain.SnapshotAt(5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.Prune(2); err != nil {
+		t.Fatal(err)
+	}
+	for _, height := range []int{0, 3, 5} {
+		if _, err := chain.SnapshotAt(height); !errors.Is(err, ErrPruned) {
+			t.Fatalf("snapshot at %d: error %v, want ErrPruned", height, err)
+		}
+	}
+	if _, _, err := chain.ExportRange(4, -1); err != nil {
+		t.Fatalf("export of retained blocks: %v", err)
+	}
+	if _, _, err := chain.ExportRange(3, -1); !errors.Is(err, ErrPruned) {
+		t.Fatalf("export of pruned blocks: error %v, want ErrPruned", err)
+	}
+}
+
+func TestPrunedIndex(t *testing.T) {
+	chain := pruneTestChain(5)
+	retained, _ := chain.BlockAt(5)
+	discarded, _ := chain.BlockAt(2)
+	if _, err := chain.Prune(2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dataset string
+		height  int
+		pruned  bool
+	}{
+		{"retained block", "dataset-5", 5, false},
+		{"pruned block", "dataset-2", 2, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, total := chain.TransactionsByDataset(test.dataset, Page{})
+			if total != 1 || len(found) != 1 {
+				t.Fatalf("%d transactions found, want 1", total)
+			}
+			if found[0].Height != test.height || found[0].Pruned != test.pruned {
+				t.Fatalf("found %+v, want height %d, pruned %v", found[0], test.height, test.pruned)
+			}
+			if test.pruned && found[0].Transaction.DataHash != "" {
+				t.Fatalf("pruned entry holds transaction %+v", found[0].Transaction)
+			}
+			if !test.pruned && found[0].Transaction.DataHash != test.dataset {
+				t.Fatalf("retained entry holds transaction %+v", found[0].Transaction)
+			}
+		})
+	}
+
+	if found, ok := chain.TransactionByID(discarded.Transactions[0].ID()); !ok || !found.Pruned || found.Height != 2 {
+		t.Fatalf("pruned transaction looked up by ID: %+v, %v", found, ok)
+	}
+	if found, ok := chain.TransactionByID(retained.Transactions[0].ID()); !ok || found.Pruned || found.Transaction.ID() != retained.Transactions[0].ID() {
+		t.Fatalf("retained transaction looked up by ID: %+v, %v", found, ok)
+	}
+}
@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ========================Snapshots========================
+
+// A snapshot holds the chain up to a height, identified by the hash of its content, so a
+// new node can load it and sync only the blocks after it. Loading checks the hashes,
+// proof-of-work, Merkle roots and links of the blocks and their agreement with the
+// checkpoints; the jobs of blocks above the highest checkpoint must still be re-executed.
+
+// Version of the snapshot format
+const SnapshotVersion = 1
+
+type Snapshot struct {
+	Version     int
+	Height      int    // Height of the latest block
+	Hash        string // Hash of the latest block
+	ContentHash string // SHA-256 of the JSON of Blocks, identifies the snapshot
+	Blocks      []*Block
+}
+
+// ========================Takes a snapshot of the chain at a height========================
+func (chain *Blockchain) SnapshotAt(height int) (*Snapshot, error) {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	if height < 0 || height >= len(chain.Blocks) {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	if chain.pruned >= 0 {
+		return nil, fmt.Errorf("%w: the transactions of blocks up to height %d were discarded", ErrPruned, chain.pruned)
+	}
+	blocks := append([]*Block(nil), chain.Blocks[:height+1]...)
+	contentHash, err := snapshotContentHash(blocks)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Version:     SnapshotVersion,
+		Height:      height,
+		Hash:        blocks[height].Hash,
+		ContentHash: contentHash,
+		Blocks:      blocks,
+	}, nil
+}
+
+func snapshotContentHash(blocks []*Block) (string, error) {
+	data, err := json.Marshal(blocks)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// ========================Checks a snapshot========================
+// Checks its content hash, that it starts at the genesis block, that its blocks are valid
+// and linked, and that none contradicts a checkpoint
+func (s *Snapshot) Verify(checkpoints *Checkpoints) error {
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	if s.Height < 0 || len(s.Blocks) == 0 {
+		return fmt.Errorf("snapshot holds no blocks")
+	}
+	for height, block := range s.Blocks {
+		if block == nil {
+			return fmt.Errorf("snapshot block %d is missing", height)
+		}
+	}
+	if len(s.Blocks) != s.Height+1 || s.Blocks[s.Height].Hash != s.Hash {
+		return fmt.Errorf("snapshot does not end at block %s, height %d", s.Hash, s.Height)
+	}
+	contentHash, err := snapshotContentHash(s.Blocks)
+	if err != nil {
+		return err
+	}
+	if contentHash != s.ContentHash {
+		return fmt.Errorf("snapshot content hash is %s, expected %s", contentHash, s.ContentHash)
+	}
+	if s.Blocks[0].Hash != GenesisBlock().Hash {
+		return fmt.Errorf("snapshot starts at block %s, not the genesis block", s.Blocks[0].Hash)
+	}
+	for height, block := range s.Blocks {
+		if block.Pruned {
+			return fmt.Errorf("snapshot block %d (%s) is pruned", height, block.Hash)
+		}
+	}
+	if err := VerifyBlocks(s.Blocks); err != nil {
+		return err
+	}
+	for height, block := range s.Blocks {
+		if err := checkpoints.Check(height, block.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ========================Writes a snapshot========================
+func (s *Snapshot) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+func (s *Snapshot) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.Write(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return file.Close()
+}
+
+// ========================Reads a snapshot========================
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return &s, nil
+}
+
+func LoadSnapshot(path string) (*Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadSnapshot(file)
+}
+
+// ========================Replaces the blocks of the chain========================
+// The blocks must be verified; the indexes are rebuilt
+func (chain *Blockchain) Reset(blocks []*Block) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	chain.Blocks = append([]*Block(nil), blocks...)
+	chain.index = NewIndex()
+	chain.pruned = -1
+	for height, block := range chain.Blocks {
+		chain.index.Connect(block, height)
+	}
+}
@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnapshotVerifyMalformed(t *testing.T) {
+	chain, _ := InitBlockchain()
+	chain.AddBlock([]Transaction{{DataHash: "d", Output: "o"}})
+	valid, err := chain.SnapshotAt(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints, err := NewCheckpoints(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := valid.Verify(checkpoints); err != nil {
+		t.Fatalf("valid snapshot rejected: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		snapshot Snapshot
+		err      string
+	}{
+		{"negative height", Snapshot{Version: SnapshotVersion, Height: -1, Blocks: valid.Blocks}, "snapshot holds no blocks"},
+		{"no blocks", Snapshot{Version: SnapshotVersion, Height: 0}, "snapshot holds no blocks"},
+		{"null blocks", Snapshot{Version: SnapshotVersion, Height: 1, Hash: valid.Hash, Blocks: []*Block{nil, nil}}, "snapshot block 0 is missing"},
+		{"null genesis", Snapshot{Version: SnapshotVersion, Height: 1, Hash: valid.Hash, Blocks: []*Block{nil, valid.Blocks[1]}}, "snapshot block 0 is missing"},
+		{"height past blocks", Snapshot{Version: SnapshotVersion, Height: 5, Hash: valid.Hash, Blocks: valid.Blocks}, "does not end at block"},
+		{"wrong tip", Snapshot{Version: SnapshotVersion, Height: 1, Hash: "00", ContentHash: valid.ContentHash, Blocks: valid.Blocks}, "does not end at block 00"},
+		{"wrong content hash", Snapshot{Version: SnapshotVersion, Height: 1, Hash: valid.Hash, ContentHash: "00", Blocks: valid.Blocks}, "expected 00"},
+		{"wrong version", Snapshot{Version: 0, Height: 1, Hash: valid.Hash, ContentHash: valid.ContentHash, Blocks: valid.Blocks}, "unsupported snapshot version 0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkError(t, test.snapshot.Verify(checkpoints), test.err)
+		})
+	}
+}
+
+func TestReadSnapshotMalformed(t *testing.T) {
+	checkpoints, _ := NewCheckpoints(nil, nil)
+	for _, data := range []string{`{"Version":1,"Height":-1}`, `{"Version":1,"Height":0,"Blocks":null}`, `{"Version":1,"Height":0,"Blocks":[null]}`} {
+		snapshot, err := ReadSnapshot(strings.NewReader(data))
+		if err != nil {
+			continue
+		}
+		if err := snapshot.Verify(checkpoints); err == nil {
+			t.Errorf("snapshot %s accepted", data)
+		}
+	}
+}
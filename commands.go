@@ -0,0 +1,923 @@
+package main
+
+import (
+	"BlockchainProject/blockchain"
+	"BlockchainProject/ipfs"
+	"BlockchainProject/p2p"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ========================Command Line========================
+
+// Subcommands operate a node: "node run" starts one, "keys" manages its identity file,
+// "dataset" publishes job files to IPFS, and the others talk to a running node through its
+// API at NODE_API (http://localhost:8090 by default) or the address given with --api.
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+// Subcommands of each command group
+var commands = map[string]map[string]command{
+	"node": {
+		"run":  {"run --role miner|generator|light [--light] [--sources URLs] [--snapshot file] [--import file] [--sync] [--prune n|--archive] [flags] [peer...]", runNode},
+		"info": {"info", nodeInfo},
+	},
+	"chain": {
+		"show":       {"show", showChain},
+		"get":        {"get <height|hash>", getBlock},
+		"export":     {"export [--from height] [--to height] [--format jsonl|binary] [-o file]", exportChain},
+		"import":     {"import <file>", importChain},
+		"verify":     {"verify", verifyChain},
+		"prove":      {"prove <transaction ID>", proveTransaction},
+		"snapshot":   {"snapshot [--height n] -o file", snapshotChain},
+		"checkpoint": {"checkpoint [--height n]", signCheckpoint},
+		"find":       {"find --tx ID | --dataset CID | --algorithm CID | --output hash | --miner key [--offset n] [--limit n]", findInChain},
+	},
+	"mempool": {
+		"list": {"list", listMempool},
+	},
+	"job": {
+		"submit": {"submit <manifest file|CID> | --dataset CID --algorithm CID [--requirements CID] [--copies n] [--wait]", submitJob},
+		"status": {"status <job ID> [--follow]", jobStatus},
+		"result": {"result <job ID> [-o dir]", jobResult},
+	},
+	"dataset": {
+		"publish": {"publish <dataset> <algorithm> <requirements>", publishJobFiles},
+		"encrypt": {"encrypt <dataset>...", encryptDatasets},
+	},
+	"peers": {
+		"list": {"list", listPeers},
+		"add":  {"add <address>...", addPeers},
+		"ban":  {"ban <address>...", banPeers},
+	},
+	"keys": {
+		"generate": {"generate [--force]", generateKeys},
+		"show":     {"show [--miner]", showKeys},
+	},
+}
+
+var commandGroups = []string{"node", "chain", "mempool", "job", "dataset", "peers", "keys"}
+
+// Runs a subcommand, returning false if args do not name one
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	group, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+	if len(args) < 2 || group[args[1]].run == nil {
+		printGroupUsage(args[0])
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := group[args[1]].run(ctx, args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		return true
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func printUsage() {
+	fmt.Println("Usage:")
+	for _, name := range commandGroups {
+		for _, sub := range sortedKeys(commands[name]) {
+			fmt.Println("  ", os.Args[0], name, commands[name][sub].usage)
+		}
+	}
+}
+
+func printGroupUsage(name string) {
+	fmt.Println("Usage:")
+	for _, sub := range sortedKeys(commands[name]) {
+		fmt.Println("  ", os.Args[0], name, commands[name][sub].usage)
+	}
+}
+
+func sortedKeys(m map[string]command) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns the flags of a subcommand, with --api for those talking to a node
+func newFlags(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	api := flags.String("api", p2p.NodeAPI, "API of the node")
+	return flags, api
+}
+
+// Parses the flags of a subcommand, which may follow its positional arguments
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// ========================Node========================
+
+// Runs a miner, generator or light node in the foreground until it fails or is interrupted
+func runNode(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("node run", flag.ContinueOnError)
+	role := flags.String("role", "", "role of the node: miner, generator or light")
+	light := flags.Bool("light", false, "follow the chain by its headers only, as light nodes do")
+	sources := flags.String("sources", "", "APIs of the full nodes to follow, comma separated; the peers' by default")
+	snapshot := flags.String("snapshot", "", "snapshot to load before syncing the later blocks")
+	importFile := flags.String("import", "", "chain export to import before syncing the later blocks")
+	sync := flags.Bool("sync", false, "sync the blocks after the ledger's tip before starting")
+	prune := flags.Int("prune", p2p.PruneDepth, "keep the transactions of the latest n blocks only, discarding older ones; 0 keeps every block")
+	archive := flags.Bool("archive", false, "keep every block, as archive nodes do (same as --prune 0)")
+	workloadFlags := newWorkloadFlags(flags)
+	peers, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if *archive {
+		*prune = 0
+	}
+	if err := p2p.CheckPruneDepth(*prune); err != nil {
+		return err
+	}
+	p2p.PruneDepth = *prune
+	for _, peer := range peers {
+		p2p.AddPeer(peer)
+	}
+	var urls []string
+	if *sources != "" {
+		urls = strings.Split(*sources, ",")
+	}
+	if *role == "light" || *light {
+		if *role == "miner" {
+			return errors.New("miners keep the full chain and cannot run as light nodes")
+		}
+		p2p.StartLightClient(ctx, urls)
+	} else {
+		if *snapshot != "" {
+			if err := p2p.Bootstrap(ctx, *snapshot); err != nil {
+				return err
+			}
+		}
+		if *importFile != "" {
+			if err := importChainFile(ctx, *importFile); err != nil {
+				return err
+			}
+		}
+		if *snapshot != "" || *sync {
+			if err := p2p.SyncLedger(ctx, p2p.APISources(urls)); err != nil {
+				fmt.Println("Error syncing ledger:", err)
+			}
+		}
+	}
+
+	switch *role {
+	case "miner":
+		go serveAPI("miner")
+		p2p.Miner()
+		return errors.New("miner stopped")
+	case "generator":
+		return runWorkload(ctx, flags, workloadFlags)
+	case "light":
+		go serveAPI("light")
+		<-ctx.Done()
+		return nil
+	default:
+		return fmt.Errorf("unknown role %q, use --role miner, generator or light", *role)
+	}
+}
+
+// Imports a chain export into the ledger of this node
+func importChainFile(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	imported, err := p2p.ImportChain(ctx, file)
+	if err != nil {
+		return fmt.Errorf("failed to import %s after %d blocks: %w", path, imported.Imported, err)
+	}
+	fmt.Printf("Imported %d blocks from %s; tip at height %d (%s)\n", imported.Imported, path, imported.Height, imported.Tip)
+	return nil
+}
+
+func nodeInfo(ctx context.Context, args []string) error {
+	flags, api := newFlags("node info")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	info, err := p2p.NewAPIClient(*api).Node(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(info)
+}
+
+// ========================Chain========================
+
+func showChain(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain show")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	client := p2p.NewAPIClient(*api)
+	tip, err := client.Tip(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Latest blocks first
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HEIGHT\tHASH\tTRANSACTIONS")
+	for height := tip.Height; height >= 0 && height > tip.Height-10; height-- {
+		block := tip
+		if height != tip.Height {
+			if block, err = client.BlockAt(ctx, height); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\n", block.Height, block.Hash, len(block.Transactions))
+	}
+	return w.Flush()
+}
+
+func getBlock(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain get")
+	refs, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(refs) != 1 {
+		return errors.New("usage: chain get <height|hash>")
+	}
+	block, err := p2p.NewAPIClient(*api).Block(ctx, refs[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(block)
+}
+
+// Writes blocks as JSON lines, one block per line, or in the compact binary format
+func exportChain(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain export")
+	from := flags.Int("from", 0, "first height to export")
+	to := flags.Int("to", -1, "last height to export, the tip by default")
+	format := flags.String("format", blockchain.FormatJSONL, "format of the export: jsonl or binary")
+	output := flags.String("o", "", "file to write, standard output by default")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := p2p.NewAPIClient(*api).Export(ctx, *from, *to, *format, out); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Printf("Exported blocks from height %d to %s\n", *from, *output)
+	}
+	return nil
+}
+
+// Adds the blocks of an export to the node's ledger; run it again to resume an interrupted import
+func importChain(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain import")
+	files, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return errors.New("usage: chain import <file>")
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	imported, err := p2p.NewAPIClient(*api).Import(ctx, file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d blocks, skipped %d already in the chain; tip at height %d (%s)\n", imported.Imported, imported.Skipped, imported.Height, imported.Tip)
+	return nil
+}
+
+// Checks the proof-of-work of every block of the node's chain and the links between them
+func verifyChain(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain verify")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	var blocks []*blockchain.Block
+	_, err := fetchBlocks(ctx, p2p.NewAPIClient(*api), 0, -1, func(block p2p.BlockResponse) error {
+		blocks = append(blocks, &blockchain.Block{
+			Hash:         block.Hash,
+			PrevHash:     block.PrevHash,
+			MerkleRoot:   block.MerkleRoot,
+			Nonce:        block.Nonce,
+			Transactions: block.Transactions,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := blockchain.VerifyBlocks(blocks); err != nil {
+		return err
+	}
+	fmt.Printf("Chain verified: %d blocks, tip %s\n", len(blocks), blocks[len(blocks)-1].Hash)
+	return nil
+}
+
+// Saves a snapshot of the node's chain, checked against the checkpoints of this machine
+func snapshotChain(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain snapshot")
+	height := flags.Int("height", -1, "height of the latest block of the snapshot, the tip by default")
+	output := flags.String("o", "", "file to write")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return errors.New("usage: chain snapshot [--height n] -o file")
+	}
+
+	snapshot, err := p2p.NewAPIClient(*api).Snapshot(ctx, *height)
+	if err != nil {
+		return err
+	}
+	checkpoints, err := p2p.NodeCheckpoints()
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Verify(checkpoints); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if err := snapshot.Save(*output); err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot %s of %d blocks up to %s written to %s\n", snapshot.ContentHash, snapshot.Height+1, snapshot.Hash, *output)
+	return nil
+}
+
+// Signs the block at a height of the node's chain as a checkpoint and adds it to CHECKPOINTS
+func signCheckpoint(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain checkpoint")
+	height := flags.Int("height", -1, "height of the block, the tip by default")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	client := p2p.NewAPIClient(*api)
+	block, err := client.Tip(ctx)
+	if err == nil && *height >= 0 {
+		block, err = client.BlockAt(ctx, *height)
+	}
+	if err != nil {
+		return err
+	}
+	key, err := p2p.LoadCheckpointKey(p2p.CheckpointKeyFile)
+	if err != nil {
+		return err
+	}
+	checkpoint := blockchain.SignCheckpoint(key, block.Height, block.Hash)
+
+	var signed []blockchain.Checkpoint
+	if data, err := os.ReadFile(p2p.CheckpointFile); err == nil {
+		if err := json.Unmarshal(data, &signed); err != nil {
+			return fmt.Errorf("invalid checkpoints in %s: %w", p2p.CheckpointFile, err)
+		}
+	}
+	signed = append(signed, checkpoint)
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p2p.CheckpointFile, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Checkpoint at height %d (%s) added to %s\n", checkpoint.Height, checkpoint.Hash, p2p.CheckpointFile)
+	fmt.Println("Nodes enforce it once CHECKPOINT_SIGNERS lists", checkpoint.Signer)
+	return nil
+}
+
+// Looks transactions up in the explorer indexes of the node
+func findInChain(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain find")
+	txID := flags.String("tx", "", "ID of a transaction")
+	dataset := flags.String("dataset", "", "CID of a dataset: transactions computed on it")
+	algorithm := flags.String("algorithm", "", "CID of an algorithm: transactions that ran it")
+	output := flags.String("output", "", "output hash: transactions with this result")
+	miner := flags.String("miner", "", "signing key of a miner (keys show --miner): blocks holding its transactions")
+	offset := flags.Int("offset", 0, "results to skip")
+	limit := flags.Int("limit", blockchain.DefaultPageLimit, "results to show")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NFlag() == 0 {
+		return errors.New("usage: chain find --tx ID | --dataset CID | --algorithm CID | --output hash | --miner key")
+	}
+
+	client := p2p.NewAPIClient(*api)
+	page := blockchain.Page{Offset: *offset, Limit: *limit}
+	switch {
+	case *txID != "":
+		tx, err := client.Transaction(ctx, *txID)
+		if err != nil {
+			return err
+		}
+		return printJSON(tx)
+	case *miner != "":
+		blocks, err := client.MinerBlocks(ctx, *miner, page)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "HEIGHT\tHASH")
+		for _, block := range blocks.Blocks {
+			fmt.Fprintf(w, "%d\t%s\n", block.Height, block.Hash)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		printPageFooter(blocks.Offset, len(blocks.Blocks), blocks.Total, "blocks")
+		return nil
+	}
+
+	index, key := "datasets", *dataset
+	switch {
+	case *algorithm != "":
+		index, key = "algorithms", *algorithm
+	case *output != "":
+		index, key = "outputs", *output
+	}
+	txs, err := client.Transactions(ctx, index, key, page)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HEIGHT\tTRANSACTION\tDATASET\tALGORITHM\tOUTPUT")
+	for _, tx := range txs.Transactions {
+		if tx.Pruned {
+			fmt.Fprintf(w, "%d\t%s\t(pruned)\t\t\n", tx.Height, tx.ID)
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", tx.Height, tx.ID, tx.Transaction.DataHash, tx.Transaction.AlgoHash, tx.Transaction.Output)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	printPageFooter(txs.Offset, len(txs.Transactions), txs.Total, "transactions")
+	return nil
+}
+
+// Fetches the inclusion proof of a transaction and checks it against the block header
+func proveTransaction(ctx context.Context, args []string) error {
+	flags, api := newFlags("chain prove")
+	ids, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return errors.New("usage: chain prove <transaction ID>")
+	}
+	proof, err := p2p.NewAPIClient(*api).Proof(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	if err := proof.Verify(); err != nil {
+		return fmt.Errorf("invalid proof: %w", err)
+	}
+	if err := printJSON(proof); err != nil {
+		return err
+	}
+	fmt.Printf("Transaction %s is recorded in block %s (height %d)\n", proof.Proof.TxID, proof.Header.Hash, proof.Header.Height)
+	return nil
+}
+
+func printPageFooter(offset, count, total int, what string) {
+	if count == 0 {
+		fmt.Println("No", what, "found")
+		return
+	}
+	fmt.Printf("Showing %s %d-%d of %d\n", what, offset+1, offset+count, total)
+}
+
+// Fetches the blocks from height from to height to (the tip when negative) in order
+func fetchBlocks(ctx context.Context, client *p2p.APIClient, from, to int, handle func(p2p.BlockResponse) error) (int, error) {
+	if to < 0 {
+		tip, err := client.Tip(ctx)
+		if err != nil {
+			return 0, err
+		}
+		to = tip.Height
+	}
+	count := 0
+	for height := from; height <= to; height++ {
+		block, err := client.BlockAt(ctx, height)
+		if err != nil {
+			return count, fmt.Errorf("block %d: %w", height, err)
+		}
+		if err := handle(block); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ========================Mempool========================
+
+func listMempool(ctx context.Context, args []string) error {
+	flags, api := newFlags("mempool list")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	pending, err := p2p.NewAPIClient(*api).Mempool(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tMANIFEST\tDATASET\tALGORITHM\tOUTPUT")
+	for _, tx := range pending.Transactions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", orDash(tx.JobID), orDash(tx.Manifest), tx.DataHash, tx.AlgoHash, tx.Output)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println(pending.Count, "pending transactions")
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// ========================Jobs========================
+
+// Submits a manifest, published first when given as a file, or a legacy dataset and algorithm job
+func submitJob(ctx context.Context, args []string) error {
+	flags, api := newFlags("job submit")
+	dataset := flags.String("dataset", "", "CID of the dataset of a legacy job")
+	algorithm := flags.String("algorithm", "", "CID of the algorithm of a legacy job")
+	requirements := flags.String("requirements", "", "CID of the requirements of a legacy job")
+	copies := flags.Int("copies", 0, "miners to send a legacy job to")
+	wait := flags.Bool("wait", false, "follow the job until it is confirmed or failed")
+	manifests, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	request := p2p.SubmitJobRequest{Dataset: *dataset, Algorithm: *algorithm, Requirements: *requirements, Copies: *copies}
+	switch {
+	case len(manifests) > 1:
+		return errors.New("usage: job submit <manifest file|CID>")
+	case len(manifests) == 1:
+		request.Manifest, err = publishManifest(ctx, manifests[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	client := p2p.NewAPIClient(*api)
+	jobID, err := client.SubmitJob(ctx, request)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Job:", jobID)
+	if !*wait {
+		return nil
+	}
+	return followJob(ctx, client, jobID)
+}
+
+// Publishes a manifest file after validating it; a path naming no file is taken to be a CID
+func publishManifest(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return path, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if _, err := ipfs.ParseManifest(data); err != nil {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	cid, err := ipfs.Put(ctx, filepath.Base(path), data)
+	if err != nil {
+		return "", fmt.Errorf("error publishing %s: %w", path, err)
+	}
+	fmt.Println("Manifest:", cid)
+	return cid, nil
+}
+
+func jobStatus(ctx context.Context, args []string) error {
+	flags, api := newFlags("job status")
+	follow := flags.Bool("follow", false, "follow the job until it is confirmed or failed")
+	ids, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return errors.New("usage: job status <job ID> [--follow]")
+	}
+
+	client := p2p.NewAPIClient(*api)
+	if *follow {
+		return followJob(ctx, client, ids[0])
+	}
+	status, err := client.JobStatus(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	for _, update := range status.Updates {
+		printJobUpdate(update)
+	}
+	return nil
+}
+
+// Prints the updates of a job as they arrive until it is confirmed or failed
+func followJob(ctx context.Context, client *p2p.APIClient, jobID string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Subscribe before reading the history, so no update falls between the two
+	updates := make(chan p2p.JobUpdate, 64)
+	streamErr := make(chan error, 1)
+	go func() {
+		query := url.Values{"topics": {p2p.TopicJob}, "job": {jobID}}
+		streamErr <- client.Events(ctx, query, func(event p2p.ReceivedEvent) error {
+			var update p2p.JobUpdate
+			if err := json.Unmarshal(event.Data, &update); err != nil {
+				return err
+			}
+			select {
+			case updates <- update:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	status, err := client.JobStatus(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	var last p2p.JobUpdate
+	for _, update := range status.Updates {
+		printJobUpdate(update)
+		last = update
+	}
+	for !status.Final {
+		select {
+		case update := <-updates:
+			if !update.Time.After(last.Time) {
+				continue // Already in the history
+			}
+			printJobUpdate(update)
+			last = update
+			if update.Status.Final() {
+				// A failure from one miner is final only when every miner failed
+				if status, err = client.JobStatus(ctx, jobID); err != nil {
+					return err
+				}
+			}
+		case err := <-streamErr:
+			if err == nil {
+				err = errors.New("event stream closed")
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func printJobUpdate(update p2p.JobUpdate) {
+	line := fmt.Sprintf("%s  %-9s", update.Time.Format("15:04:05"), update.Status)
+	if update.Peer != "" {
+		line += "  peer " + update.Peer
+	}
+	if update.Block != "" {
+		line += fmt.Sprintf("  block %s (height %d, %d confirmations)", update.Block, update.Height, update.Confirmations)
+	}
+	if update.OutputCID != "" {
+		line += "  output " + update.OutputCID
+	}
+	if update.Error != "" {
+		line += "  error: " + update.Error
+	}
+	fmt.Println(line)
+}
+
+// Downloads the output of a job, found by the node, checked against its transaction
+func jobResult(ctx context.Context, args []string) error {
+	flags, api := newFlags("job result")
+	dir := flags.String("o", "result", "directory to write the output to")
+	ids, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return errors.New("usage: job result <job ID> [-o dir]")
+	}
+
+	result, err := p2p.NewAPIClient(*api).JobResult(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	artifact, err := p2p.FetchTransactionOutput(ctx, result.Transaction, *dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Result %s written to %s (%d output files)\n", artifact.Hash, *dir, len(artifact.Files))
+	return nil
+}
+
+// ========================Datasets========================
+
+// Uploads and pins the files of a job and prints their CIDs
+func publishJobFiles(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dataset publish", flag.ContinueOnError)
+	paths, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(paths) != 3 {
+		return errors.New("usage: dataset publish <dataset> <algorithm> <requirements>")
+	}
+
+	names := []string{"Dataset", "Algorithm", "Requirements"}
+	for i, path := range paths {
+		cid, err := ipfs.AddFile(ctx, path)
+		if err != nil {
+			return fmt.Errorf("error publishing %s: %w", path, err)
+		}
+		fmt.Printf("%-13s %s (%s)\n", names[i]+":", cid, path)
+	}
+	return nil
+}
+
+// Encrypts datasets under new keys, publishes them and keeps the keys for release to miners
+func encryptDatasets(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dataset encrypt", flag.ContinueOnError)
+	paths, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return errors.New("usage: dataset encrypt <dataset>...")
+	}
+
+	for _, path := range paths {
+		cid, err := ipfs.PublishEncryptedDataset(ctx, path)
+		if err != nil {
+			return fmt.Errorf("error publishing %s: %w", path, err)
+		}
+		fmt.Printf("Encrypted dataset: %s (%s), key stored in %s\n", cid, path, ipfs.DatasetKeys.Path)
+	}
+	return nil
+}
+
+// ========================Peers========================
+
+func listPeers(ctx context.Context, args []string) error {
+	flags, api := newFlags("peers list")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	peers, err := p2p.NewAPIClient(*api).Peers(ctx)
+	if err != nil {
+		return err
+	}
+	printPeers(peers)
+	return nil
+}
+
+func addPeers(ctx context.Context, args []string) error {
+	return changePeers(ctx, "peers add", args, (*p2p.APIClient).AddPeer)
+}
+
+func banPeers(ctx context.Context, args []string) error {
+	return changePeers(ctx, "peers ban", args, (*p2p.APIClient).BanPeer)
+}
+
+func changePeers(ctx context.Context, name string, args []string, change func(*p2p.APIClient, context.Context, string) (p2p.PeersResponse, error)) error {
+	flags, api := newFlags(name)
+	addresses, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return errors.New("usage: " + name + " <address>...")
+	}
+
+	client := p2p.NewAPIClient(*api)
+	var peers p2p.PeersResponse
+	for _, address := range addresses {
+		if peers, err = change(client, ctx, address); err != nil {
+			return fmt.Errorf("%s: %w", address, err)
+		}
+	}
+	printPeers(peers)
+	return nil
+}
+
+func printPeers(peers p2p.PeersResponse) {
+	for _, peer := range peers.Peers {
+		fmt.Println(peer)
+	}
+	for _, peer := range peers.Banned {
+		fmt.Println(peer, "(banned)")
+	}
+}
+
+// ========================Keys========================
+
+// Creates the identity of the node in NODE_KEY_FILE, refusing to replace one without --force
+func generateKeys(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace the existing key")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(p2p.NodeKeyFile); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use --force to replace it", p2p.NodeKeyFile)
+	}
+	id, err := p2p.GenerateIdentity(p2p.NodeKeyFile)
+	if err != nil {
+		return err
+	}
+	fmt.Println(id.PublicKey())
+	return nil
+}
+
+func showKeys(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("keys show", flag.ContinueOnError)
+	miner := flags.Bool("miner", false, "show the public key mined transactions are signed with instead")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	id, err := p2p.NodeIdentity()
+	if err != nil {
+		return err
+	}
+	if *miner {
+		fmt.Println(id.MinerID())
+		return nil
+	}
+	fmt.Println(id.PublicKey())
+	return nil
+}
@@ -1,3 +1,7 @@
 module BlockchainProject
 
 go 1.23.4
+
+require github.com/tetratelabs/wazero v1.10.1
+
+require gopkg.in/yaml.v3 v3.0.1
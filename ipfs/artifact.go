@@ -0,0 +1,198 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ========================Output Artifacts========================
+
+// The published output of a job: the full result, its commitment hash and the CIDs of
+// the output files. Jobs with encrypted inputs get encrypted artifacts, readable only
+// with the dataset keys, since their output may reveal the private data.
+type OutputArtifact struct {
+	Result AlgorithmResult   `json:"result"`          // Result exactly as committed to by Hash
+	Hash   string            `json:"hash"`            // Commitment hash recorded in the transaction
+	Files  map[string]string `json:"files,omitempty"` // CID of each output file, keyed by name
+}
+
+// Publishes the result and the output files of a job run in ws and returns the artifact's CID
+func PublishOutput(ctx context.Context, ws *Workspace, result AlgorithmResult) (string, error) {
+	committed, err := MarshalCanonical(result)
+	if err != nil {
+		return "", err
+	}
+	artifact := OutputArtifact{Result: result, Hash: CommitmentHash(committed)}
+	key, encrypted, err := outputKey(ws.Job)
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.Files) > 0 {
+		artifact.Files = make(map[string]string, len(result.Files))
+		names := make([]string, 0, len(result.Files))
+		for name := range result.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			path := filepath.Join(ws.Dir, name)
+			if encrypted {
+				sealed := path + ".enc"
+				if err := EncryptFile(path, sealed, key); err != nil {
+					return "", fmt.Errorf("error encrypting output file %s: %w", name, err)
+				}
+				path = sealed
+			}
+			cid, err := AddFile(ctx, path)
+			if err != nil {
+				return "", fmt.Errorf("error publishing output file %s: %w", name, err)
+			}
+			artifact.Files[name] = cid
+		}
+	}
+
+	data, err := MarshalCanonical(artifact)
+	if err != nil {
+		return "", err
+	}
+	if encrypted {
+		var sealed bytes.Buffer
+		if err := EncryptStream(&sealed, bytes.NewReader(data), key); err != nil {
+			return "", err
+		}
+		data = sealed.Bytes()
+	}
+	cid, err := Put(ctx, "output.json", data)
+	if err != nil {
+		return "", fmt.Errorf("error publishing output: %w", err)
+	}
+	fmt.Println("Output published with CID:", cid)
+	return cid, nil
+}
+
+// Downloads the output artifact of a job, which must carry exactly the output files the job
+// declares. If dir is not empty the result is written to dir/result.json and the output
+// files next to it, each checked against its recorded hash.
+func FetchOutput(ctx context.Context, cid string, job Job, dir string) (OutputArtifact, error) {
+	key, encrypted, err := outputKey(job)
+	if err != nil {
+		return OutputArtifact{}, err
+	}
+
+	data, err := DownloadFile(ctx, cid)
+	if err != nil {
+		return OutputArtifact{}, fmt.Errorf("error downloading output: %w", err)
+	}
+	if encrypted {
+		var plain bytes.Buffer
+		if err := DecryptStream(&plain, bytes.NewReader(data), key); err != nil {
+			return OutputArtifact{}, err
+		}
+		data = plain.Bytes()
+	}
+
+	var artifact OutputArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return OutputArtifact{}, fmt.Errorf("error parsing output: %w", err)
+	}
+	committed, err := MarshalCanonical(artifact.Result)
+	if err != nil {
+		return OutputArtifact{}, err
+	}
+	if CommitmentHash(committed) != artifact.Hash {
+		return OutputArtifact{}, fmt.Errorf("output %s does not match its commitment hash", cid)
+	}
+	if err := checkOutputFiles(artifact, job.Spec.output()); err != nil {
+		return OutputArtifact{}, fmt.Errorf("output %s: %w", cid, err)
+	}
+	if dir == "" {
+		return artifact, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return OutputArtifact{}, err
+	}
+	result, err := json.MarshalIndent(artifact.Result, "", "  ")
+	if err != nil {
+		return OutputArtifact{}, err
+	}
+	if err := WriteFile(filepath.Join(dir, "result.json"), result); err != nil {
+		return OutputArtifact{}, err
+	}
+	for name, fileCID := range artifact.Files {
+		path := filepath.Join(dir, filepath.Clean("/" + name)[1:])
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return OutputArtifact{}, err
+		}
+		if encrypted {
+			if err := Download(ctx, fileCID, path+".enc"); err != nil {
+				return OutputArtifact{}, fmt.Errorf("error downloading output file %s: %w", name, err)
+			}
+			err = DecryptFile(path+".enc", path, key)
+			os.Remove(path + ".enc")
+		} else {
+			err = Download(ctx, fileCID, path)
+		}
+		if err != nil {
+			return OutputArtifact{}, fmt.Errorf("error downloading output file %s: %w", name, err)
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			return OutputArtifact{}, err
+		}
+		if hash != artifact.Result.Files[name] {
+			return OutputArtifact{}, fmt.Errorf("output file %s does not match its recorded hash", name)
+		}
+	}
+	return artifact, nil
+}
+
+// Checks that an artifact publishes and commits to every output file the job declares, and no other
+func checkOutputFiles(artifact OutputArtifact, spec OutputSpec) error {
+	if len(artifact.Files) != len(spec.Files) || len(artifact.Result.Files) != len(spec.Files) {
+		return fmt.Errorf("expected %d output files, got %d published and %d committed", len(spec.Files), len(artifact.Files), len(artifact.Result.Files))
+	}
+	for _, name := range spec.Files {
+		if _, ok := artifact.Files[name]; !ok {
+			return fmt.Errorf("declared output file %s is not published", name)
+		}
+		if _, ok := artifact.Result.Files[name]; !ok {
+			return fmt.Errorf("declared output file %s is not committed to", name)
+		}
+	}
+	return nil
+}
+
+// Returns the key protecting the output of a job with encrypted inputs, derived from
+// the dataset keys so that whoever can read the inputs can read the output
+func outputKey(job Job) ([]byte, bool, error) {
+	var cids []string
+	for _, input := range job.inputs() {
+		if input.Encrypted {
+			cids = append(cids, input.CID)
+		}
+	}
+	if len(cids) == 0 {
+		return nil, false, nil
+	}
+	sort.Strings(cids)
+
+	h := sha256.New()
+	h.Write([]byte("job-output"))
+	for _, cid := range cids {
+		key, ok := DatasetKeys.Get(cid)
+		if !ok {
+			return nil, true, fmt.Errorf("%w: %s", ErrNoDatasetKey, cid)
+		}
+		h.Write(key)
+	}
+	return h.Sum(nil), true, nil
+}
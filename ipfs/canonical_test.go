@@ -0,0 +1,88 @@
+package ipfs
+
+import (
+	"strings"
+	"testing"
+)
+
+// Fails unless err contains want, or is nil when want is empty
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("no error, want %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("error %q, want %q", err, want)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"sorted keys", `{"b":1,"a":2,"c":{"z":1,"y":2}}`, `{"a":2,"b":1,"c":{"y":2,"z":1}}`},
+		{"whitespace", "{ \"a\" : [ 1 , 2 ] ,\n\t\"b\" : null }", `{"a":[1,2],"b":null}`},
+		{"array order kept", `[3,1,2]`, `[3,1,2]`},
+		{"integer forms", `[1.0,1e2,-0,0.0,100000000000000000000000]`, `[1,100,0,0,100000000000000000000000]`},
+		{"fractions", `[0.5,1.25e-3,-2.50]`, `[0.5,0.00125,-2.5]`},
+		{"exponents", `[1e-7,1.5e300]`, `[1e-07,1.5e+300]`},
+		{"no HTML escaping", `"<a&b>"`, `"<a&b>"`},
+		{"unicode escapes", `"é\n"`, `"é\n"`},
+		{"scalars", `true`, `true`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := CanonicalJSON([]byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+			again, err := CanonicalJSON(got)
+			if err != nil || string(again) != string(got) {
+				t.Fatalf("canonical form %s is not stable: %s", got, again)
+			}
+		})
+	}
+}
+
+func TestCanonicalJSONInvalid(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{``, "invalid JSON: EOF"},
+		{`{`, "invalid JSON: unexpected EOF"},
+		{`{"a":1} {"b":2}`, "trailing data after document"},
+		{`[1e999]`, `invalid number "1e999"`},
+		{`{'a':1}`, "invalid character"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, err := CanonicalJSON([]byte(test.input))
+			checkError(t, err, test.err)
+		})
+	}
+}
+
+func TestMarshalCanonicalKeyOrder(t *testing.T) {
+	a, err := MarshalCanonical(map[string]interface{}{"x": 1.0, "a": []int{2, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MarshalCanonical(struct {
+		A []int   `json:"a"`
+		X float64 `json:"x"`
+	}{[]int{2, 1}, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) || string(a) != `{"a":[2,1],"x":1}` {
+		t.Fatalf("equal values encoded as %s and %s", a, b)
+	}
+}
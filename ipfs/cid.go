@@ -0,0 +1,269 @@
+package ipfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ========================Local CID Computation========================
+
+// Content identifiers are computed the way `ipfs add --cid-version=1` does:
+// files are split into 256 KiB raw leaves assembled into a balanced UnixFS
+// DAG of at most 174 links per node; a file that fits in one chunk is a raw block.
+
+const (
+	chunkSize    = 256 * 1024
+	maxLinks     = 174
+	codecRaw     = 0x55
+	codecDagPB   = 0x70
+	hashSHA2_256 = 0x12
+)
+
+// UnixFS node types
+const (
+	unixfsDirectory = 1
+	unixfsFile      = 2
+)
+
+var cidEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// A node of a UnixFS DAG: its CID and the sizes needed by its parent
+type dagNode struct {
+	cid      []byte // Binary CID
+	fileSize uint64 // Bytes of file content below the node
+	tsize    uint64 // Bytes of all blocks below and including the node
+}
+
+// Computes the CID of a byte slice
+func ComputeCID(data []byte) (string, error) {
+	return ComputeReaderCID(bytes.NewReader(data))
+}
+
+// Computes the CID of a file on disk
+func ComputeFileCID(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return ComputeReaderCID(file)
+}
+
+// Computes the CID of a stream of file content
+func ComputeReaderCID(r io.Reader) (string, error) {
+	node, err := fileNode(r)
+	if err != nil {
+		return "", err
+	}
+	return encodeCID(node.cid), nil
+}
+
+// Computes the CID of a directory tree; entries are files and sub-directories
+func ComputeDirectoryCID(dir string) (string, error) {
+	node, err := directoryNode(dir)
+	if err != nil {
+		return "", err
+	}
+	return encodeCID(node.cid), nil
+}
+
+// Builds the DAG of a file and returns its root
+func fileNode(r io.Reader) (dagNode, error) {
+	h := NewFileHasher()
+	if _, err := io.Copy(h, r); err != nil {
+		return dagNode{}, fmt.Errorf("failed to read content: %w", err)
+	}
+	return h.root(), nil
+}
+
+// Computes the CID of file content written to it, so content can be checked while it streams
+type FileHasher struct {
+	chunk  []byte    // Content not yet assigned to a leaf
+	leaves []dagNode // Completed leaves, in order
+}
+
+func NewFileHasher() *FileHasher {
+	return &FileHasher{chunk: make([]byte, 0, chunkSize)}
+}
+
+func (h *FileHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := chunkSize - len(h.chunk)
+		if n > len(p) {
+			n = len(p)
+		}
+		h.chunk = append(h.chunk, p[:n]...)
+		p = p[n:]
+		if len(h.chunk) == chunkSize {
+			h.flush()
+		}
+	}
+	return written, nil
+}
+
+// Turns the buffered content into a leaf
+func (h *FileHasher) flush() {
+	h.leaves = append(h.leaves, dagNode{
+		cid:      makeCID(codecRaw, h.chunk),
+		fileSize: uint64(len(h.chunk)),
+		tsize:    uint64(len(h.chunk)),
+	})
+	h.chunk = h.chunk[:0]
+}
+
+// Returns the CID of the content written so far
+func (h *FileHasher) CID() string {
+	return encodeCID(h.root().cid)
+}
+
+// Groups the leaves into parents until a single root remains
+func (h *FileHasher) root() dagNode {
+	layer := append([]dagNode(nil), h.leaves...)
+	if len(h.chunk) > 0 || len(layer) == 0 {
+		layer = append(layer, dagNode{
+			cid:      makeCID(codecRaw, h.chunk),
+			fileSize: uint64(len(h.chunk)),
+			tsize:    uint64(len(h.chunk)),
+		})
+	}
+
+	for len(layer) > 1 {
+		var parents []dagNode
+		for start := 0; start < len(layer); start += maxLinks {
+			end := start + maxLinks
+			if end > len(layer) {
+				end = len(layer)
+			}
+			parents = append(parents, fileParent(layer[start:end]))
+		}
+		layer = parents
+	}
+	return layer[0]
+}
+
+// Builds an intermediate file node linking to its children
+func fileParent(children []dagNode) dagNode {
+	var data bytes.Buffer
+	writeVarintField(&data, 1, unixfsFile)
+	var fileSize, tsize uint64
+	for _, child := range children {
+		fileSize += child.fileSize
+	}
+	writeVarintField(&data, 3, fileSize)
+	for _, child := range children {
+		writeVarintField(&data, 4, child.fileSize)
+	}
+
+	links := make([]pbLink, len(children))
+	for i, child := range children {
+		links[i] = pbLink{cid: child.cid, tsize: child.tsize}
+		tsize += child.tsize
+	}
+
+	block := encodePBNode(links, data.Bytes())
+	return dagNode{
+		cid:      makeCID(codecDagPB, block),
+		fileSize: fileSize,
+		tsize:    tsize + uint64(len(block)),
+	}
+}
+
+// Builds the DAG of a directory and returns its root
+func directoryNode(dir string) (dagNode, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return dagNode{}, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var links []pbLink
+	var tsize uint64
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		var child dagNode
+		if entry.IsDir() {
+			child, err = directoryNode(path)
+		} else {
+			var file *os.File
+			file, err = os.Open(path)
+			if err == nil {
+				child, err = fileNode(file)
+				file.Close()
+			}
+		}
+		if err != nil {
+			return dagNode{}, err
+		}
+		links = append(links, pbLink{cid: child.cid, name: entry.Name(), tsize: child.tsize})
+		tsize += child.tsize
+	}
+
+	var data bytes.Buffer
+	writeVarintField(&data, 1, unixfsDirectory)
+	block := encodePBNode(links, data.Bytes())
+	return dagNode{cid: makeCID(codecDagPB, block), tsize: tsize + uint64(len(block))}, nil
+}
+
+// A dag-pb link
+type pbLink struct {
+	cid   []byte
+	name  string // Entry name in directories, empty for file chunks
+	tsize uint64
+}
+
+// Encodes a dag-pb node: links first, then the data, as canonical dag-pb requires
+func encodePBNode(links []pbLink, data []byte) []byte {
+	var node bytes.Buffer
+	for _, link := range links {
+		var encoded bytes.Buffer
+		writeBytesField(&encoded, 1, link.cid)
+		writeBytesField(&encoded, 2, []byte(link.name))
+		writeVarintField(&encoded, 3, link.tsize)
+		writeBytesField(&node, 2, encoded.Bytes())
+	}
+	writeBytesField(&node, 1, data)
+	return node.Bytes()
+}
+
+// Builds a binary CIDv1 with a SHA-256 multihash
+func makeCID(codec uint64, block []byte) []byte {
+	digest := sha256.Sum256(block)
+	cid := binary.AppendUvarint(nil, 1)
+	cid = binary.AppendUvarint(cid, codec)
+	cid = binary.AppendUvarint(cid, hashSHA2_256)
+	cid = binary.AppendUvarint(cid, uint64(len(digest)))
+	return append(cid, digest[:]...)
+}
+
+// Encodes a binary CID as a base32 multibase string ("bafy...", "bafk...")
+func encodeCID(cid []byte) string {
+	return "b" + strings.ToLower(cidEncoding.EncodeToString(cid))
+}
+
+// Decodes a base32 CIDv1 string into its binary form
+func decodeCID(cid string) ([]byte, error) {
+	if len(cid) < 2 || cid[0] != 'b' {
+		return nil, fmt.Errorf("unsupported CID %q: only base32 CIDv1 is supported", cid)
+	}
+	return cidEncoding.DecodeString(strings.ToUpper(cid[1:]))
+}
+
+func writeVarintField(buf *bytes.Buffer, field int, value uint64) {
+	buf.Write(binary.AppendUvarint(nil, uint64(field<<3)))
+	buf.Write(binary.AppendUvarint(nil, value))
+}
+
+func writeBytesField(buf *bytes.Buffer, field int, value []byte) {
+	buf.Write(binary.AppendUvarint(nil, uint64(field<<3|2)))
+	buf.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	buf.Write(value)
+}
@@ -0,0 +1,78 @@
+package ipfs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Vectors published for `ipfs add --cid-version=1`
+func TestComputeCIDKnownVectors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		cid  string
+	}{
+		{"empty file", "", "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"},
+		{"hello world", "hello world", "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cid, err := ComputeCID([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cid != test.cid {
+				t.Fatalf("CID %s, want %s", cid, test.cid)
+			}
+		})
+	}
+
+	cid, err := ComputeDirectoryCID(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354"; cid != want {
+		t.Fatalf("empty directory CID %s, want %s", cid, want)
+	}
+}
+
+func TestComputeCIDChunking(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		prefix string // "bafk" for a single raw block, "bafy" for a UnixFS DAG
+	}{
+		{"one chunk", chunkSize, "bafk"},
+		{"two chunks", chunkSize + 1, "bafy"},
+		{"many chunks", 3*chunkSize + 7, "bafy"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte("0123456789"), test.size/10+1)[:test.size]
+			cid, err := ComputeCID(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(cid, test.prefix) {
+				t.Fatalf("CID %s does not start with %s", cid, test.prefix)
+			}
+
+			// Content written in pieces of any size hashes the same
+			h := NewFileHasher()
+			for rest := data; len(rest) > 0; {
+				n := min(len(rest), 1000)
+				h.Write(rest[:n])
+				rest = rest[n:]
+			}
+			if h.CID() != cid {
+				t.Fatalf("streamed CID %s, want %s", h.CID(), cid)
+			}
+
+			raw, err := decodeCID(cid)
+			if err != nil || encodeCID(raw) != cid {
+				t.Fatalf("CID %s does not decode: %v", cid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package ipfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ========================Result Verification========================
+
+// How a verifier decides that its re-computed result matches the committed one
+const (
+	VerifyExact     = "exact"     // Hashes must be identical (default)
+	VerifyTolerance = "tolerance" // Results are compared field by field with numeric tolerances
+)
+
+// Declares how results of a job are verified
+type VerifyPolicy struct {
+	Mode       string               `json:"mode,omitempty"`       // VerifyExact or VerifyTolerance
+	Tolerance  Tolerance            `json:"tolerance,omitempty"`  // Default tolerance for every number
+	Fields     map[string]Tolerance `json:"fields,omitempty"`     // Tolerances for specific paths, e.g. "result.inertia"
+	Unordered  []string             `json:"unordered,omitempty"`  // Arrays compared regardless of order, e.g. "result.centroids"
+	Comparator string               `json:"comparator,omitempty"` // Name of a registered comparator replacing the structural comparison
+}
+
+// Allowed difference between two numbers: |a-b| <= Abs + Rel*max(|a|,|b|)
+type Tolerance struct {
+	Abs float64 `json:"abs,omitempty"`
+	Rel float64 `json:"rel,omitempty"`
+}
+
+// Compares the committed result with the re-computed one and returns an error describing the first mismatch
+type Comparator func(policy VerifyPolicy, expected, actual json.RawMessage) error
+
+var comparators = map[string]Comparator{}
+var comparatorsMu sync.RWMutex
+
+// Makes a comparator available to jobs under the given name
+func RegisterComparator(name string, comparator Comparator) {
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+	comparators[name] = comparator
+}
+
+func lookupComparator(name string) (Comparator, bool) {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+	comparator, ok := comparators[name]
+	return comparator, ok
+}
+
+// Checks that the verification policy is usable
+func (p VerifyPolicy) Validate() error {
+	switch p.Mode {
+	case "", VerifyExact:
+		if p.Comparator != "" || len(p.Fields) > 0 || len(p.Unordered) > 0 || p.Tolerance != (Tolerance{}) {
+			return fmt.Errorf("tolerances and comparators require %s mode", VerifyTolerance)
+		}
+	case VerifyTolerance:
+		if p.Comparator != "" {
+			if _, ok := lookupComparator(p.Comparator); !ok {
+				return fmt.Errorf("unknown comparator %q", p.Comparator)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown verification mode %q", p.Mode)
+	}
+
+	tolerances := []Tolerance{p.Tolerance}
+	for _, t := range p.Fields {
+		tolerances = append(tolerances, t)
+	}
+	for _, t := range tolerances {
+		if t.Abs < 0 || t.Rel < 0 {
+			return fmt.Errorf("tolerances must not be negative")
+		}
+	}
+	return nil
+}
+
+// Reports whether results are compared structurally instead of by hash
+func (p VerifyPolicy) Structured() bool {
+	return p.Mode == VerifyTolerance
+}
+
+// Compares two results under the policy. Output files and CIDs must always match exactly.
+func CompareResults(policy VerifyPolicy, expected, actual AlgorithmResult) error {
+	if expected.Dataset != actual.Dataset || expected.Algorithm != actual.Algorithm || expected.Manifest != actual.Manifest {
+		return fmt.Errorf("result was computed from different inputs")
+	}
+	if len(expected.Files) != len(actual.Files) {
+		return fmt.Errorf("expected %d output files, got %d", len(expected.Files), len(actual.Files))
+	}
+	for name, hash := range expected.Files {
+		if actual.Files[name] != hash {
+			return fmt.Errorf("output file %s differs", name)
+		}
+	}
+
+	if policy.Comparator != "" {
+		comparator, ok := lookupComparator(policy.Comparator)
+		if !ok {
+			return fmt.Errorf("unknown comparator %q", policy.Comparator)
+		}
+		return comparator(policy, expected.Result, actual.Result)
+	}
+	return compareJSON(policy, expected.Result, actual.Result)
+}
+
+// Structural comparison honouring tolerances and unordered arrays
+func compareJSON(policy VerifyPolicy, expected, actual json.RawMessage) error {
+	left, err := decodeJSON(expected)
+	if err != nil {
+		return fmt.Errorf("committed result: %w", err)
+	}
+	right, err := decodeJSON(actual)
+	if err != nil {
+		return fmt.Errorf("computed result: %w", err)
+	}
+
+	unordered := make(map[string]bool, len(policy.Unordered))
+	for _, path := range policy.Unordered {
+		unordered[path] = true
+	}
+	c := comparison{policy: policy, unordered: unordered}
+	return c.compare(left, right, "")
+}
+
+type comparison struct {
+	policy    VerifyPolicy
+	unordered map[string]bool
+}
+
+func (c comparison) compare(expected, actual interface{}, path string) error {
+	switch e := expected.(type) {
+	case json.Number:
+		a, ok := actual.(json.Number)
+		if !ok {
+			return mismatch(path, "expected a number")
+		}
+		ef, _ := e.Float64()
+		af, _ := a.Float64()
+		if !c.tolerance(path).Allows(ef, af) {
+			return mismatch(path, fmt.Sprintf("%v and %v differ by more than the tolerance", e, a))
+		}
+		return nil
+
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			return mismatch(path, "expected an array")
+		}
+		if len(e) != len(a) {
+			return mismatch(path, fmt.Sprintf("expected %d items, got %d", len(e), len(a)))
+		}
+		if c.unordered[path] {
+			return c.compareUnordered(e, a, path)
+		}
+		for i := range e {
+			if err := c.compare(e[i], a[i], path+"[]"); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return mismatch(path, "expected an object")
+		}
+		if len(e) != len(a) {
+			return mismatch(path, "objects have different keys")
+		}
+		keys := make([]string, 0, len(e))
+		for key := range e {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, present := a[key]
+			if !present {
+				return mismatch(path, fmt.Sprintf("missing key %q", key))
+			}
+			if err := c.compare(e[key], value, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		if !sameJSON(expected, actual) {
+			return mismatch(path, "values differ")
+		}
+		return nil
+	}
+}
+
+// Matches the items of two arrays one to one, in any order, using augmenting paths
+func (c comparison) compareUnordered(expected, actual []interface{}, path string) error {
+	n := len(expected)
+	matches := make([][]bool, n)
+	for i := range expected {
+		matches[i] = make([]bool, n)
+		for j := range actual {
+			matches[i][j] = c.compare(expected[i], actual[j], path+"[]") == nil
+		}
+	}
+
+	owner := make([]int, n) // owner[j] is the expected item matched with actual item j
+	for j := range owner {
+		owner[j] = -1
+	}
+	var assign func(i int, seen []bool) bool
+	assign = func(i int, seen []bool) bool {
+		for j := 0; j < n; j++ {
+			if !matches[i][j] || seen[j] {
+				continue
+			}
+			seen[j] = true
+			if owner[j] == -1 || assign(owner[j], seen) {
+				owner[j] = i
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := 0; i < n; i++ {
+		if !assign(i, make([]bool, n)) {
+			return mismatch(path, fmt.Sprintf("item %d has no matching counterpart", i))
+		}
+	}
+	return nil
+}
+
+// Returns the tolerance of the closest configured ancestor of path, or the default
+func (c comparison) tolerance(path string) Tolerance {
+	for p := path; p != ""; p = parentPath(p) {
+		if t, ok := c.policy.Fields[p]; ok {
+			return t
+		}
+	}
+	return c.policy.Tolerance
+}
+
+// Reports whether two numbers are equal within the tolerance
+func (t Tolerance) Allows(a, b float64) bool {
+	if a == b {
+		return true
+	}
+	return math.Abs(a-b) <= t.Abs+t.Rel*math.Max(math.Abs(a), math.Abs(b))
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Strips the last key or array marker from a path
+func parentPath(path string) string {
+	if strings.HasSuffix(path, "[]") {
+		return strings.TrimSuffix(path, "[]")
+	}
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func mismatch(path, reason string) error {
+	if path == "" {
+		path = "result"
+	}
+	return fmt.Errorf("%s: %s", path, reason)
+}
@@ -0,0 +1,86 @@
+package ipfs
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCompareResults(t *testing.T) {
+	tolerant := VerifyPolicy{
+		Mode:      VerifyTolerance,
+		Tolerance: Tolerance{Abs: 0.01},
+		Fields:    map[string]Tolerance{"inertia": {Rel: 0.1}},
+		Unordered: []string{"centroids"},
+	}
+	tests := []struct {
+		name     string
+		policy   VerifyPolicy
+		expected string
+		actual   string
+		err      string
+	}{
+		{"identical", tolerant, `{"inertia":10,"labels":[0,1]}`, `{"inertia":10,"labels":[0,1]}`, ""},
+		{"within default tolerance", tolerant, `{"score":1.000}`, `{"score":1.005}`, ""},
+		{"beyond default tolerance", tolerant, `{"score":1.00}`, `{"score":1.05}`, "score: 1.00 and 1.05 differ by more than the tolerance"},
+		{"within field tolerance", tolerant, `{"inertia":100}`, `{"inertia":109}`, ""},
+		{"beyond field tolerance", tolerant, `{"inertia":100}`, `{"inertia":120}`, "inertia: 100 and 120 differ"},
+		{"unordered array reordered", tolerant, `{"centroids":[[0,0],[5,5]]}`, `{"centroids":[[5,5],[0,0.001]]}`, ""},
+		{"ordered array reordered", tolerant, `{"labels":[0,1]}`, `{"labels":[1,0]}`, "labels[]: 0 and 1 differ"},
+		{"unordered array with a duplicate", tolerant, `{"centroids":[[0,0],[5,5]]}`, `{"centroids":[[0,0],[0,0]]}`, "centroids: item 1 has no matching counterpart"},
+		{"missing key", tolerant, `{"a":1,"b":2}`, `{"a":1,"c":2}`, `result: missing key "b"`},
+		{"extra key", tolerant, `{"a":1}`, `{"a":1,"b":2}`, "result: objects have different keys"},
+		{"different types", tolerant, `{"a":1}`, `{"a":"1"}`, "a: expected a number"},
+		{"different strings", tolerant, `{"a":"x"}`, `{"a":"y"}`, "a: values differ"},
+		{"array lengths", tolerant, `[1,2]`, `[1,2,3]`, "result: expected 2 items, got 3"},
+		{"no tolerance in exact mode", VerifyPolicy{Mode: VerifyExact}, `{"a":1.0}`, `{"a":1.001}`, "a: 1.0 and 1.001 differ"},
+		{"invalid computed result", tolerant, `{"a":1}`, `{"a":`, "computed result: invalid JSON"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := AlgorithmResult{Result: json.RawMessage(test.expected)}
+			actual := AlgorithmResult{Result: json.RawMessage(test.actual)}
+			checkError(t, CompareResults(test.policy, expected, actual), test.err)
+		})
+	}
+}
+
+func TestCompareResultsFilesAndInputs(t *testing.T) {
+	policy := VerifyPolicy{Mode: VerifyTolerance, Tolerance: Tolerance{Rel: 1}}
+	base := AlgorithmResult{Dataset: "d", Algorithm: "a", Result: json.RawMessage(`1`), Files: map[string]string{"model.bin": "h1"}}
+
+	tests := []struct {
+		name   string
+		modify func(r *AlgorithmResult)
+		err    string
+	}{
+		{"same", func(r *AlgorithmResult) {}, ""},
+		{"different file hash", func(r *AlgorithmResult) { r.Files = map[string]string{"model.bin": "h2"} }, "output file model.bin differs"},
+		{"missing file", func(r *AlgorithmResult) { r.Files = nil }, "expected 1 output files, got 0"},
+		{"renamed file", func(r *AlgorithmResult) { r.Files = map[string]string{"other.bin": "h1"} }, "output file model.bin differs"},
+		{"different dataset", func(r *AlgorithmResult) { r.Dataset = "other" }, "computed from different inputs"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := base
+			test.modify(&actual)
+			checkError(t, CompareResults(policy, base, actual), test.err)
+		})
+	}
+}
+
+func TestCompareResultsComparator(t *testing.T) {
+	errRejected := errors.New("rejected")
+	RegisterComparator("test-reject", func(policy VerifyPolicy, expected, actual json.RawMessage) error {
+		return errRejected
+	})
+	policy := VerifyPolicy{Mode: VerifyTolerance, Comparator: "test-reject"}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	result := AlgorithmResult{Result: json.RawMessage(`1`)}
+	if err := CompareResults(policy, result, result); !errors.Is(err, errRejected) {
+		t.Fatalf("comparator not used: %v", err)
+	}
+	checkError(t, VerifyPolicy{Mode: VerifyTolerance, Comparator: "missing"}.Validate(), `unknown comparator "missing"`)
+}
@@ -0,0 +1,125 @@
+package ipfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// ========================Reproducible Execution========================
+
+// Wraps the algorithm so the standard RNGs are seeded before it starts.
+// Invoked as: python -c <bootstrap> <script> <args...>
+const pythonBootstrap = `import os, random, runpy, sys
+seed = int(os.environ["JOB_SEED"])
+random.seed(seed)
+try:
+    import numpy
+    numpy.random.seed(seed % 2**32)
+except ImportError:
+    pass
+sys.argv = sys.argv[1:]
+sys.path.insert(0, os.path.dirname(os.path.abspath(sys.argv[0])))
+runpy.run_path(sys.argv[0], run_name="__main__")
+`
+
+// Describes the environment a result was computed in, recorded in the transaction
+type Fingerprint struct {
+	Runtime  string            `json:"runtime"`            // Runtime that executed the job, e.g. "python"
+	Version  string            `json:"version"`            // Interpreter version
+	Packages map[string]string `json:"packages,omitempty"` // Installed packages and their versions
+	Platform string            `json:"platform"`           // Operating system and architecture of the miner
+	Seed     int64             `json:"seed"`               // Seed the RNGs were initialised with
+}
+
+// Returns the RNG seed of a job: the declared one, or one derived from the job's CIDs
+// so that every node re-executing the transaction uses the same value
+func JobSeed(job Job) int64 {
+	if job.Spec.Seed != nil {
+		return *job.Spec.Seed
+	}
+	key := job.DatasetCID + "|" + job.AlgorithmCID + "|" + job.RequirementsCID
+	if job.ManifestCID != "" {
+		key = job.ManifestCID
+	}
+	sum := sha256.Sum256([]byte(key))
+	return int64(binary.BigEndian.Uint64(sum[:8]) >> 1)
+}
+
+// Builds the controlled environment an algorithm runs with. Nothing is inherited from
+// the miner except PATH, so locale, timezone, hash seeds and thread counts are fixed.
+func deterministicEnv(workDir string, seed int64) []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + workDir,
+		"TMPDIR=" + workDir,
+		"JOB_SEED=" + strconv.FormatInt(seed, 10),
+		"PYTHONHASHSEED=0",
+		"PYTHONDONTWRITEBYTECODE=1",
+		"PYTHONUTF8=1",
+		"TZ=UTC",
+		"LANG=C.UTF-8",
+		"LC_ALL=C.UTF-8",
+		"SOURCE_DATE_EPOCH=0",
+		// Single-threaded numeric libraries keep floating-point reductions in a fixed order
+		"OMP_NUM_THREADS=1",
+		"OPENBLAS_NUM_THREADS=1",
+		"MKL_NUM_THREADS=1",
+		"MKL_CBWR=COMPATIBLE",
+		"BLIS_NUM_THREADS=1",
+		"VECLIB_MAXIMUM_THREADS=1",
+		"NUMEXPR_NUM_THREADS=1",
+	}
+}
+
+// Returns the fingerprint of a Python environment, computed once and cached next to it
+func EnvironmentFingerprint(ctx context.Context, env *Environment) (Fingerprint, error) {
+	cacheFile := filepath.Join(env.Dir, "fingerprint.json")
+	if data, err := os.ReadFile(cacheFile); err == nil {
+		var fingerprint Fingerprint
+		if json.Unmarshal(data, &fingerprint) == nil {
+			return fingerprint, nil
+		}
+	}
+
+	version, err := PythonVersion()
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	cmd := exec.CommandContext(ctx, env.Interpreter, "-m", "pip", "list", "--format=json", "--disable-pip-version-check")
+	killProcessTreeOnCancel(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return Fingerprint{}, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+	var installed []struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(out, &installed); err != nil {
+		return Fingerprint{}, fmt.Errorf("failed to parse installed packages: %w", err)
+	}
+
+	fingerprint := Fingerprint{
+		Runtime:  RuntimePython,
+		Version:  version,
+		Packages: make(map[string]string, len(installed)),
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	for _, pkg := range installed {
+		fingerprint.Packages[pkg.Name] = pkg.Version
+	}
+
+	if data, err := json.Marshal(fingerprint); err == nil {
+		os.WriteFile(cacheFile, data, 0644)
+	}
+	return fingerprint, nil
+}
@@ -0,0 +1,500 @@
+package ipfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ========================Streaming Downloads========================
+
+// Download settings, configurable through the environment
+var (
+	MaxDownloadSize     = int64(uintEnv("MAX_DOWNLOAD_SIZE", 0))        // Largest file accepted in bytes, 0 for no limit
+	DownloadChunkSize   = int64(uintEnv("DOWNLOAD_CHUNK_SIZE", 16<<20)) // Size of the ranges fetched in parallel
+	DownloadParallelism = int(uintEnv("DOWNLOAD_PARALLELISM", 4))       // Ranges fetched at once, 1 to stream sequentially
+	DownloadRetries     = int(uintEnv("DOWNLOAD_RETRIES", 3))           // Resumed attempts after an interrupted transfer
+	progressInterval    = DurationEnv("DOWNLOAD_PROGRESS_INTERVAL", 5*time.Second)
+)
+
+// Directory partial downloads are kept in, one per CID, so a run interrupted mid-transfer
+// resumes where it stopped the next time the CID is downloaded
+var PartialDir = getEnv("PARTIAL_DOWNLOAD_DIR", filepath.Join(os.TempDir(), "partial-downloads"))
+
+// Returned when content exceeds MaxDownloadSize
+var ErrTooLarge = errors.New("content exceeds the maximum download size")
+
+// Returned when content does not hash to the CID it was requested under
+var ErrCIDMismatch = errors.New("content does not match its CID")
+
+// Returned by range requests the backend answered with the whole file
+var ErrRangeUnsupported = errors.New("backend does not support range requests")
+
+// Implemented by backends that can serve part of a file, enabling resume and parallel downloads
+type RangeFetcher interface {
+	// Returns the size of the file in bytes
+	Size(ctx context.Context, cid string) (int64, error)
+	// Fetches length bytes starting at offset; a negative length reads to the end
+	FetchRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error)
+}
+
+// State of a transfer, passed to ReportProgress
+type Progress struct {
+	CID   string
+	Done  int64 // Bytes received
+	Total int64 // Size of the file, -1 when unknown
+}
+
+// Called periodically while content downloads and once when it completes
+var ReportProgress = func(p Progress) {
+	if p.Total > 0 {
+		fmt.Printf("Downloading %s: %d/%d bytes (%.1f%%)\n", p.CID, p.Done, p.Total, float64(p.Done)*100/float64(p.Total))
+	} else {
+		fmt.Printf("Downloading %s: %d bytes\n", p.CID, p.Done)
+	}
+}
+
+// Downloads the content with the given CID straight to dest, verifying it against the CID.
+// Content is written to a partial file under PartialDir first; a partial file left by an
+// interrupted transfer, in this run or an earlier one, is resumed with range requests when
+// the backend supports them.
+func (s *Store) Download(ctx context.Context, cid, dest string) error {
+	lock := cidLock(cid)
+	lock.Lock()
+	defer lock.Unlock()
+	defer s.release(cid)
+	for {
+		err := s.download(ctx, cid, dest)
+		if s.retry(cid, err) {
+			continue
+		}
+		return err
+	}
+}
+
+func (s *Store) download(ctx context.Context, cid, dest string) error {
+	if err := os.MkdirAll(PartialDir, 0755); err != nil {
+		return fmt.Errorf("failed to create partial download directory: %w", err)
+	}
+	part := partialPath(cid)
+	ranges, _ := s.Backend.(RangeFetcher)
+
+	size := int64(-1)
+	if ranges != nil {
+		if n, err := ranges.Size(ctx, cid); err == nil {
+			size = n
+		}
+	}
+	if MaxDownloadSize > 0 && size > MaxDownloadSize {
+		return fmt.Errorf("%s is %d bytes: %w", cid, size, ErrTooLarge)
+	}
+
+	progress := newProgressTracker(cid, size)
+	var computed string
+	var err error
+	parallel := ranges != nil && size > DownloadChunkSize && DownloadParallelism > 1
+	if parallel {
+		computed, err = s.downloadChunks(ctx, ranges, cid, part, size, progress)
+		if errors.Is(err, ErrRangeUnsupported) {
+			fmt.Printf("%s cannot serve ranges, streaming %s instead\n", s.Backend.Name(), cid)
+			os.Remove(part)
+			os.Remove(part + ".chunks")
+			progress = newProgressTracker(cid, size)
+			parallel, ranges = false, nil
+		}
+	}
+	if !parallel {
+		computed, err = s.downloadStream(ctx, ranges, cid, part, size, progress)
+	}
+	if err != nil {
+		return err
+	}
+	progress.finish()
+
+	if err := checkContent(cid, computed); err != nil {
+		os.Remove(part)
+		return err
+	}
+	if err := moveFile(part, dest); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	os.Remove(part + ".chunks")
+	return nil
+}
+
+// Returns the partial file of a CID; CIDs are hashed since they come from peers
+func partialPath(cid string) string {
+	hash := sha256.Sum256([]byte(cid))
+	return filepath.Join(PartialDir, hex.EncodeToString(hash[:16])+".part")
+}
+
+// Renames a file, copying it when dest is on another filesystem
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Removes partial downloads that have not been resumed for longer than maxIdle
+func CollectPartialDownloads(maxIdle time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(PartialDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partial downloads: %w", err)
+	}
+	var removed []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) <= maxIdle {
+			continue
+		}
+		if err := os.Remove(filepath.Join(PartialDir, entry.Name())); err == nil {
+			removed = append(removed, entry.Name())
+		}
+	}
+	return removed, nil
+}
+
+// Downloads a file through the default store
+func Download(ctx context.Context, cid, dest string) error {
+	return DefaultStore.Download(ctx, cid, dest)
+}
+
+// Compares the CID computed from downloaded content with the requested one.
+// Only base32 CIDv1 can be recomputed locally; other CIDs are accepted unchecked.
+func checkContent(cid, computed string) error {
+	if _, err := decodeCID(cid); err != nil {
+		fmt.Printf("Cannot verify content of %s locally: %v\n", cid, err)
+		return nil
+	}
+	if computed != cid {
+		return fmt.Errorf("%w: requested %s, received %s", ErrCIDMismatch, cid, computed)
+	}
+	return nil
+}
+
+// Streams the file sequentially, hashing it as it arrives, and resumes after interruptions
+func (s *Store) downloadStream(ctx context.Context, ranges RangeFetcher, cid, part string, size int64, progress *progressTracker) (string, error) {
+	file, err := os.OpenFile(part, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+
+	// Bytes left by an earlier attempt are hashed again rather than downloaded again
+	hasher := NewFileHasher()
+	var offset int64
+	if ranges != nil {
+		offset, err = io.Copy(hasher, file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read partial download: %w", err)
+		}
+		if size >= 0 && offset > size {
+			offset = 0 // Left over from different content
+		} else if offset > 0 {
+			fmt.Printf("Resuming %s at %d bytes\n", cid, offset)
+		}
+	}
+	if offset == 0 {
+		if err := file.Truncate(0); err != nil {
+			return "", err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		hasher = NewFileHasher()
+	}
+	progress.add(offset)
+
+	for attempt := 0; ; attempt++ {
+		if size >= 0 && offset == size {
+			break
+		}
+		err = s.streamFrom(ctx, ranges, cid, file, hasher, &offset, progress)
+		if errors.Is(err, ErrRangeUnsupported) {
+			ranges = nil
+		}
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrTooLarge) || attempt >= DownloadRetries {
+			return "", err
+		}
+
+		// Without ranges the transfer starts over
+		if ranges == nil {
+			if err := file.Truncate(0); err != nil {
+				return "", err
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return "", err
+			}
+			hasher = NewFileHasher()
+			progress.add(-offset)
+			offset = 0
+		}
+		fmt.Printf("Download of %s interrupted (%v), retrying from byte %d\n", cid, err, offset)
+	}
+	return hasher.CID(), nil
+}
+
+// Appends the content from offset onwards to file and hasher
+func (s *Store) streamFrom(ctx context.Context, ranges RangeFetcher, cid string, file *os.File, hasher *FileHasher, offset *int64, progress *progressTracker) error {
+	var body io.ReadCloser
+	var err error
+	if *offset > 0 && ranges != nil {
+		body, err = ranges.FetchRange(ctx, cid, *offset, -1)
+	} else {
+		body, err = s.Backend.Fetch(ctx, cid)
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// Read one byte past the limit to detect oversized content
+	var r io.Reader = body
+	if MaxDownloadSize > 0 {
+		r = io.LimitReader(body, MaxDownloadSize-*offset+1)
+	}
+	counter := &countingWriter{count: offset, progress: progress}
+	_, err = io.Copy(io.MultiWriter(file, hasher, counter), r)
+	if MaxDownloadSize > 0 && *offset > MaxDownloadSize {
+		return fmt.Errorf("%s: %w", cid, ErrTooLarge)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file data: %w", err)
+	}
+	return nil
+}
+
+// Fetches ranges of the file in parallel into a preallocated file, then hashes it.
+// Completed ranges are recorded in part+".chunks" so an interrupted download resumes.
+func (s *Store) downloadChunks(ctx context.Context, ranges RangeFetcher, cid, part string, size int64, progress *progressTracker) (string, error) {
+	file, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		return "", fmt.Errorf("failed to allocate file: %w", err)
+	}
+
+	stateFile := part + ".chunks"
+	state, err := openChunkState(stateFile, size, DownloadChunkSize)
+	if err != nil {
+		return "", err
+	}
+	defer state.close()
+
+	chunks := int((size + DownloadChunkSize - 1) / DownloadChunkSize)
+	pending := make(chan int, chunks)
+	for i := 0; i < chunks; i++ {
+		if state.done[i] {
+			progress.add(chunkLength(i, size))
+			continue
+		}
+		pending <- i
+	}
+	close(pending)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+	for w := 0; w < DownloadParallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				if err := fetchChunk(ctx, ranges, cid, file, i, size, progress); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+				if err := state.markDone(i); err != nil {
+					fmt.Println("Failed to record downloaded chunk:", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return "", firstErr
+	}
+
+	state.close()
+	os.Remove(stateFile)
+	if err := file.Sync(); err != nil {
+		return "", err
+	}
+	return ComputeFileCID(part)
+}
+
+// Fetches one range, retrying interrupted transfers from where they stopped
+func fetchChunk(ctx context.Context, ranges RangeFetcher, cid string, file *os.File, index int, size int64, progress *progressTracker) error {
+	start := int64(index) * DownloadChunkSize
+	length := chunkLength(index, size)
+	var received int64
+
+	for attempt := 0; ; attempt++ {
+		err := func() error {
+			body, err := ranges.FetchRange(ctx, cid, start+received, length-received)
+			if err != nil {
+				return err
+			}
+			defer body.Close()
+
+			writer := io.NewOffsetWriter(file, start+received)
+			counter := &countingWriter{count: &received, progress: progress}
+			if _, err := io.Copy(io.MultiWriter(writer, counter), io.LimitReader(body, length-received)); err != nil {
+				return err
+			}
+			if received < length {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrRangeUnsupported) || attempt >= DownloadRetries {
+			return fmt.Errorf("failed to download bytes %d-%d of %s: %w", start, start+length-1, cid, err)
+		}
+	}
+}
+
+// Returns the length of a chunk, the last one being shorter
+func chunkLength(index int, size int64) int64 {
+	start := int64(index) * DownloadChunkSize
+	if size-start < DownloadChunkSize {
+		return size - start
+	}
+	return DownloadChunkSize
+}
+
+// Records which chunks of a parallel download are complete
+type chunkState struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[int]bool
+}
+
+// Opens the state of a download, discarding it if it was made for a different size or chunk size
+func openChunkState(path string, size, chunkSize int64) (*chunkState, error) {
+	header := fmt.Sprintf("%d %d", size, chunkSize)
+	state := &chunkState{done: map[int]bool{}}
+
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		if scanner.Scan() && scanner.Text() == header {
+			for scanner.Scan() {
+				if i, err := strconv.Atoi(strings.TrimSpace(scanner.Text())); err == nil {
+					state.done[i] = true
+				}
+			}
+		}
+		existing.Close()
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record download state: %w", err)
+	}
+	fmt.Fprintln(file, header)
+	for i := range state.done {
+		fmt.Fprintln(file, i)
+	}
+	state.file = file
+	return state, nil
+}
+
+func (c *chunkState) markDone(index int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[index] = true
+	_, err := fmt.Fprintln(c.file, index)
+	return err
+}
+
+func (c *chunkState) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+}
+
+// Adds the bytes written through it to a counter and the progress of the transfer
+type countingWriter struct {
+	count    *int64
+	progress *progressTracker
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.count, int64(len(p)))
+	c.progress.add(int64(len(p)))
+	return len(p), nil
+}
+
+// Reports the progress of one transfer at most once per progressInterval
+type progressTracker struct {
+	cid    string
+	total  int64
+	done   int64
+	last   int64 // Unix nanoseconds of the last report
+	closed int32
+}
+
+func newProgressTracker(cid string, total int64) *progressTracker {
+	return &progressTracker{cid: cid, total: total, last: time.Now().UnixNano()}
+}
+
+func (p *progressTracker) add(n int64) {
+	done := atomic.AddInt64(&p.done, n)
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&p.last)
+	if now-last >= int64(progressInterval) && atomic.CompareAndSwapInt64(&p.last, last, now) {
+		ReportProgress(Progress{CID: p.cid, Done: done, Total: p.total})
+	}
+}
+
+func (p *progressTracker) finish() {
+	if atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		ReportProgress(Progress{CID: p.cid, Done: atomic.LoadInt64(&p.done), Total: p.total})
+	}
+}
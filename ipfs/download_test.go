@@ -0,0 +1,264 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serves content over HTTP like a gateway, optionally without range support or cutting
+// transfers short
+type rangeServer struct {
+	content   []byte
+	noRanges  bool  // Answer range requests with the whole file
+	noSize    bool  // Fail HEAD requests, so the size is unknown
+	interrupt int   // Transfers to cut short
+	cutAfter  int64 // Bytes sent before a transfer is cut
+
+	mu     sync.Mutex
+	ranges []string // Range headers of the GET requests received
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead && s.noSize {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	cut := false
+	if r.Method == http.MethodGet {
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		if s.interrupt > 0 {
+			s.interrupt--
+			cut = true
+		}
+	}
+	s.mu.Unlock()
+
+	if s.noRanges {
+		r.Header.Del("Range")
+	}
+
+	if cut {
+		w = &cutWriter{ResponseWriter: w, left: s.cutAfter}
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.content))
+}
+
+func (s *rangeServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+// Aborts the response once left bytes are written
+type cutWriter struct {
+	http.ResponseWriter
+	left int64
+}
+
+func (c *cutWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > c.left {
+		c.ResponseWriter.Write(p[:c.left])
+		c.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	c.left -= int64(len(p))
+	return c.ResponseWriter.Write(p)
+}
+
+// Sets the download settings for a test, restoring them afterwards
+func setDownloadSettings(t *testing.T, chunkSize int64, parallelism int, maxSize int64) {
+	t.Helper()
+	dir, chunk, parallel, max, report := PartialDir, DownloadChunkSize, DownloadParallelism, MaxDownloadSize, ReportProgress
+	t.Cleanup(func() {
+		PartialDir, DownloadChunkSize, DownloadParallelism, MaxDownloadSize, ReportProgress = dir, chunk, parallel, max, report
+	})
+	PartialDir = t.TempDir()
+	DownloadChunkSize, DownloadParallelism, MaxDownloadSize = chunkSize, parallelism, maxSize
+	ReportProgress = func(Progress) {}
+}
+
+func randomContent(t *testing.T, size int) ([]byte, string) {
+	t.Helper()
+	content := make([]byte, size)
+	rand.Read(content)
+	cid, err := ComputeCID(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content, cid
+}
+
+// Downloads cid from server into a temporary directory, returning the destination
+func download(t *testing.T, server *rangeServer, cid string) (string, error) {
+	t.Helper()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	store := &Store{Backend: &GatewayBackend{URL: ts.URL + "/ipfs/"}}
+	dest := filepath.Join(t.TempDir(), "downloaded")
+	return dest, store.Download(context.Background(), cid, dest)
+}
+
+func checkDownloaded(t *testing.T, dest string, content []byte) {
+	t.Helper()
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatalf("downloaded %d bytes differing from the %d served", len(data), len(content))
+	}
+	if entries, _ := os.ReadDir(PartialDir); len(entries) != 0 {
+		t.Fatalf("%d partial files left after the download", len(entries))
+	}
+}
+
+func TestDownloadStream(t *testing.T) {
+	content, cid := randomContent(t, 100_000)
+
+	tests := []struct {
+		name     string
+		server   *rangeServer
+		requests []string // Range headers expected, in order
+	}{
+		{"whole file", &rangeServer{}, []string{""}},
+		{"resumed after an interruption", &rangeServer{interrupt: 1, cutAfter: 30_000}, []string{"", "bytes=30000-"}},
+		{"resumed twice", &rangeServer{interrupt: 2, cutAfter: 30_000}, []string{"", "bytes=30000-", "bytes=60000-"}},
+		{"restarted without ranges", &rangeServer{noRanges: true, interrupt: 1, cutAfter: 30_000}, []string{"", "bytes=30000-", ""}},
+		{"unknown size", &rangeServer{noSize: true}, []string{""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setDownloadSettings(t, 1<<20, 1, 0)
+			test.server.content = content
+			dest, err := download(t, test.server, cid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDownloaded(t, dest, content)
+			if requests := test.server.requests(); strings.Join(requests, ",") != strings.Join(test.requests, ",") {
+				t.Fatalf("requests %q, want %q", requests, test.requests)
+			}
+		})
+	}
+}
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+	setDownloadSettings(t, 1<<20, 1, 0)
+	content, cid := randomContent(t, 50_000)
+
+	// A partial file left by an earlier run is resumed from its end
+	if err := os.MkdirAll(PartialDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partialPath(cid), content[:20_000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := &rangeServer{content: content}
+	dest, err := download(t, server, cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, dest, content)
+	if requests := server.requests(); len(requests) != 1 || requests[0] != "bytes=20000-" {
+		t.Fatalf("requests %q, want a range from the end of the partial file", requests)
+	}
+}
+
+func TestDownloadChunks(t *testing.T) {
+	content, cid := randomContent(t, 100_000)
+
+	tests := []struct {
+		name   string
+		server *rangeServer
+		ranges int // Range requests expected, -1 for any number and a whole file request
+	}{
+		{"parallel ranges", &rangeServer{}, 7},
+		{"interrupted range retried", &rangeServer{interrupt: 1, cutAfter: 5_000}, 8},
+		{"ranges unsupported", &rangeServer{noRanges: true}, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setDownloadSettings(t, 16_000, 4, 0)
+			test.server.content = content
+			dest, err := download(t, test.server, cid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDownloaded(t, dest, content)
+
+			// Ranges cancelled by the fallback may reach the server after the whole file request
+			ranges, whole := 0, false
+			for _, header := range test.server.requests() {
+				if header == "" {
+					whole = true
+				} else {
+					ranges++
+				}
+			}
+			if test.ranges < 0 {
+				if !whole {
+					t.Fatal("whole file not requested")
+				}
+				return
+			}
+			if whole || ranges != test.ranges {
+				t.Fatalf("%d range requests, whole file requested %v; want %d ranges only", ranges, whole, test.ranges)
+			}
+		})
+	}
+}
+
+func TestDownloadRejects(t *testing.T) {
+	content, cid := randomContent(t, 100_000)
+	other, _ := randomContent(t, 100_000)
+
+	tests := []struct {
+		name        string
+		server      *rangeServer
+		chunkSize   int64
+		parallelism int
+		maxSize     int64
+		err         error
+	}{
+		{"over the maximum size", &rangeServer{content: content}, 1 << 20, 1, 50_000, ErrTooLarge},
+		{"over the maximum size, size unknown", &rangeServer{content: content, noSize: true}, 1 << 20, 1, 50_000, ErrTooLarge},
+		{"at the maximum size", &rangeServer{content: content, noSize: true}, 1 << 20, 1, 100_000, nil},
+		{"streamed content not matching", &rangeServer{content: other}, 1 << 20, 1, 0, ErrCIDMismatch},
+		{"chunked content not matching", &rangeServer{content: other}, 16_000, 4, 0, ErrCIDMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setDownloadSettings(t, test.chunkSize, test.parallelism, test.maxSize)
+			dest, err := download(t, test.server, cid)
+			if test.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkDownloaded(t, dest, content)
+				return
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if _, err := os.Stat(dest); !os.IsNotExist(err) {
+				t.Fatalf("rejected content written to the destination: %v", err)
+			}
+			if test.err == ErrCIDMismatch {
+				if entries, _ := os.ReadDir(PartialDir); len(entries) != 0 {
+					t.Fatalf("%d partial files of rejected content kept", len(entries))
+				}
+			}
+		})
+	}
+}
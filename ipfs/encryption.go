@@ -0,0 +1,306 @@
+package ipfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ========================Encrypted Datasets========================
+
+// Private datasets are stored on IPFS encrypted with AES-256-GCM under a key of their own.
+// The ciphertext is a header followed by sealed chunks, so files of any size are
+// encrypted and decrypted as streams:
+//
+//	"DSENC1\x00\x00" | chunk size (uint32) | nonce prefix (8 bytes) | chunks...
+//
+// Chunk i is sealed with the nonce prefix followed by i and authenticates whether it is
+// the last chunk, so chunks cannot be reordered, dropped or truncated unnoticed.
+
+const (
+	encryptionMagic     = "DSENC1\x00\x00"
+	encryptionChunkSize = 64 * 1024
+	DatasetKeySize      = 32
+)
+
+// Returned when an encrypted input has no key released to this node
+var ErrNoDatasetKey = errors.New("no key released for encrypted dataset")
+
+// Generates a random dataset key
+func NewDatasetKey() ([]byte, error) {
+	key := make([]byte, DatasetKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// Encrypts the content of r into w
+func EncryptStream(w io.Writer, r io.Reader, key []byte) error {
+	aead, err := newDatasetCipher(key)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, len(encryptionMagic)+12)
+	header = append(header, encryptionMagic...)
+	header = binary.BigEndian.AppendUint32(header, encryptionChunkSize)
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// Reading one byte ahead tells whether the current chunk is the last one
+	reader := bufio.NewReaderSize(r, encryptionChunkSize+1)
+	buf := make([]byte, encryptionChunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read dataset: %w", err)
+		}
+		_, peekErr := reader.Peek(1)
+		last := peekErr == io.EOF
+		if peekErr != nil && peekErr != io.EOF {
+			return fmt.Errorf("failed to read dataset: %w", peekErr)
+		}
+
+		sealed := aead.Seal(nil, chunkNonce(prefix, counter), buf[:n], chunkFlag(last))
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == ^uint32(0) {
+			return errors.New("dataset is too large to encrypt")
+		}
+	}
+}
+
+// Decrypts content produced by EncryptStream from r into w
+func DecryptStream(w io.Writer, r io.Reader, key []byte) error {
+	aead, err := newDatasetCipher(key)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, len(encryptionMagic)+12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return errors.New("dataset is not encrypted in a known format")
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[len(encryptionMagic):]))
+	if chunkSize <= 0 || chunkSize > 16<<20 {
+		return fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	prefix := header[len(encryptionMagic)+4:]
+
+	reader := bufio.NewReaderSize(r, chunkSize+aead.Overhead()+1)
+	buf := make([]byte, chunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read dataset: %w", err)
+		}
+		_, peekErr := reader.Peek(1)
+		last := peekErr == io.EOF
+
+		plain, err := aead.Open(buf[:0], chunkNonce(prefix, counter), buf[:n], chunkFlag(last))
+		if err != nil {
+			return errors.New("failed to decrypt dataset: wrong key or corrupted content")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Encrypts the file at src into dst
+func EncryptFile(src, dst string, key []byte) error {
+	return transformFile(src, dst, func(w io.Writer, r io.Reader) error { return EncryptStream(w, r, key) })
+}
+
+// Decrypts the file at src into dst
+func DecryptFile(src, dst string, key []byte) error {
+	return transformFile(src, dst, func(w io.Writer, r io.Reader) error { return DecryptStream(w, r, key) })
+}
+
+func transformFile(src, dst string, transform func(w io.Writer, r io.Reader) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(out)
+	if err := transform(writer, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func newDatasetCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != DatasetKeySize {
+		return nil, fmt.Errorf("dataset keys are %d bytes, got %d", DatasetKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), prefix...), counter)
+}
+
+func chunkFlag(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// ========================Dataset Keys========================
+
+// Keys of encrypted datasets by CID: the keys a generator created, or the keys released
+// to this node. Keys are kept in a file readable by the node's user only.
+type KeyStore struct {
+	Path string // File the keys are persisted in, memory only when empty
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// Key store of the node, persisted in DATASET_KEYS_FILE
+var DatasetKeys = &KeyStore{Path: getEnv("DATASET_KEYS_FILE", "dataset-keys.json")}
+
+// Returns the key of a dataset
+func (k *KeyStore) Get(cid string) ([]byte, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.load(); err != nil {
+		fmt.Println("Error loading dataset keys:", err)
+	}
+	key, ok := k.keys[cid]
+	return key, ok
+}
+
+// Stores the key of a dataset
+func (k *KeyStore) Put(cid string, key []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.load(); err != nil {
+		return err
+	}
+	k.keys[cid] = append([]byte(nil), key...)
+	return k.save()
+}
+
+func (k *KeyStore) load() error {
+	if k.keys != nil {
+		return nil
+	}
+	k.keys = map[string][]byte{}
+	if k.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(k.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read key store: %w", err)
+	}
+	var encoded map[string]string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("failed to parse key store %s: %w", k.Path, err)
+	}
+	for cid, value := range encoded {
+		key, err := hex.DecodeString(value)
+		if err != nil || len(key) != DatasetKeySize {
+			return fmt.Errorf("invalid key for %s in %s", cid, k.Path)
+		}
+		k.keys[cid] = key
+	}
+	return nil
+}
+
+func (k *KeyStore) save() error {
+	if k.Path == "" {
+		return nil
+	}
+	encoded := make(map[string]string, len(k.keys))
+	for cid, key := range k.keys {
+		encoded[cid] = hex.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(encoded, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := k.Path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(k.Path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key store: %w", err)
+	}
+	return os.Rename(tmp, k.Path)
+}
+
+// Encrypts a dataset under a new key, publishes the ciphertext and keeps the key.
+// Returns the CID to reference as an encrypted input of a job manifest.
+func PublishEncryptedDataset(ctx context.Context, path string) (string, error) {
+	key, err := NewDatasetKey()
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "encrypt-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	encrypted := filepath.Join(dir, filepath.Base(path)+".enc")
+	if err := EncryptFile(path, encrypted, key); err != nil {
+		return "", fmt.Errorf("failed to encrypt %s: %w", path, err)
+	}
+
+	cid, err := AddFile(ctx, encrypted)
+	if err != nil {
+		return "", err
+	}
+	if err := DatasetKeys.Put(cid, key); err != nil {
+		return "", fmt.Errorf("dataset published as %s but its key could not be stored: %w", cid, err)
+	}
+	return cid, nil
+}
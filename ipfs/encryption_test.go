@@ -0,0 +1,82 @@
+package ipfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func encrypt(t *testing.T, plain, key []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	if err := EncryptStream(&sealed, bytes.NewReader(plain), key); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"under a chunk", encryptionChunkSize - 1},
+		{"one chunk", encryptionChunkSize},
+		{"over a chunk", encryptionChunkSize + 1},
+		{"several chunks", 3*encryptionChunkSize + 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := NewDatasetKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain := make([]byte, test.size)
+			rand.Read(plain)
+
+			var decrypted bytes.Buffer
+			if err := DecryptStream(&decrypted, bytes.NewReader(encrypt(t, plain, key)), key); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), plain) {
+				t.Fatal("decrypted content differs from the original")
+			}
+		})
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	key, _ := NewDatasetKey()
+	otherKey, _ := NewDatasetKey()
+	plain := make([]byte, 2*encryptionChunkSize+10)
+	rand.Read(plain)
+	sealed := encrypt(t, plain, key)
+	header := len(encryptionMagic) + 12
+	firstChunk := header + encryptionChunkSize + 16
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[header+5] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		key    []byte
+		err    string
+	}{
+		{"wrong key", sealed, otherKey, "wrong key or corrupted content"},
+		{"short key", sealed, key[:16], "dataset keys are 32 bytes, got 16"},
+		{"modified chunk", flipped, key, "wrong key or corrupted content"},
+		{"dropped chunks", sealed[:firstChunk], key, "wrong key or corrupted content"},
+		{"truncated chunk", sealed[:len(sealed)-1], key, "wrong key or corrupted content"},
+		{"truncated header", sealed[:header-1], key, "failed to read encryption header"},
+		{"not encrypted", plain, key, "not encrypted in a known format"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var decrypted bytes.Buffer
+			checkError(t, DecryptStream(&decrypted, bytes.NewReader(test.sealed), test.key), test.err)
+		})
+	}
+}
@@ -2,56 +2,30 @@ package ipfs
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
 )
 
-// IPFS Gateway for accessing files stored on IPFS
-const ipfsGateway = "https://fuchsia-official-porcupine-775.mypinata.cloud/ipfs/"
-
-// Struct to parse the JSON output from the Python script
+// Result of running an algorithm, see OutputSpec for how it is produced
 type AlgorithmResult struct {
-	Result struct {
-		Centroids [][]float64 `json:"centroids"` // Centroids from clustering algorithm
-		Inertia   float64     `json:"inertia"`   // Inertia metric (sum of squared distances)
-	} `json:"result"`
-	Dataset   string `json:"dataset"`   // CID of the dataset used
-	Algorithm string `json:"algorithm"` // CID of the algorithm used
+	Result    json.RawMessage   `json:"result"`             // Canonical JSON result (base64 string in raw mode, null in none mode)
+	Files     map[string]string `json:"files,omitempty"`    // SHA-256 of each declared output file, keyed by name
+	Dataset   string            `json:"dataset"`            // CID of the dataset used
+	Algorithm string            `json:"algorithm"`          // CID of the algorithm used
+	Manifest  string            `json:"manifest,omitempty"` // CID of the job manifest, for manifest jobs
 }
 
-// Function to download a file from IPFS using its CID
-func DownloadFile(cid string) ([]byte, error) {
-
-	url := ipfsGateway + cid
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GET request: %w", err)
-	}
-
-	// Add authorization header with API key
-	apiKey := "Bearer a0bde220f72a645ce158"
-	req.Header.Add("Authorization", apiKey)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send GET request: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to retrieve file, status code: %d", resp.StatusCode)
-	}
-	fileData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file data: %w", err)
-	}
-	return fileData, nil
+// Function to download a small file from IPFS into memory using its CID
+func DownloadFile(ctx context.Context, cid string) ([]byte, error) {
+	return DefaultStore.Get(ctx, cid)
 }
 
 // Writes downloaded data to a file on disk
@@ -63,11 +37,35 @@ func WriteFile(fileName string, data []byte) error {
 	return nil
 }
 
-// Executes a Python script and captures its output
-func RunPythonAlgorithm(scriptName string, dataset string) (string, error) {
-	println(scriptName, " ", dataset, "\n")
-	//cmdArgs := []string{scriptName, dataset}           // Pass script name and dataset as arguments
-	cmd := exec.Command("python", scriptName, dataset) // Run the Python script
+// Downloads a file from IPFS and streams it to disk
+func downloadToFile(ctx context.Context, cid string, fileName string) error {
+	return Download(ctx, cid, fileName)
+}
+
+// Downloads an encrypted dataset and decrypts it in place with the key released to this node.
+// The ciphertext is deleted once decrypted; the clear text never leaves the workspace.
+func downloadEncrypted(ctx context.Context, input Input, path string) error {
+	key, ok := DatasetKeys.Get(input.CID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoDatasetKey, input.CID)
+	}
+	encrypted := path + ".enc"
+	if err := downloadToFile(ctx, input.CID, encrypted); err != nil {
+		return err
+	}
+	defer os.Remove(encrypted)
+	return DecryptFile(encrypted, path, key)
+}
+
+// Executes a Python script with the given interpreter and captures its output.
+// The script runs in a clean, reproducible environment (see deterministicEnv) with its RNGs seeded.
+// Cancelling the context kills the script together with any process it started.
+func RunPythonAlgorithm(ctx context.Context, python string, scriptName string, args []string, seed int64) (string, error) {
+	cmdArgs := append([]string{"-c", pythonBootstrap, scriptName}, args...) // Pass script name and its arguments
+	cmd := exec.CommandContext(ctx, python, cmdArgs...)                     // Run the Python script
+	cmd.Dir = filepath.Dir(scriptName)                                      // Relative inputs and outputs resolve in the workspace
+	cmd.Env = deterministicEnv(cmd.Dir, seed)
+	killProcessTreeOnCancel(cmd)
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &out
@@ -82,9 +80,15 @@ func RunPythonAlgorithm(scriptName string, dataset string) (string, error) {
 	return out.String(), nil
 }
 
-// Installs Python requirements using pip
-func InstallRequirements(requirementsFile string) error {
-	cmd := exec.Command("pip", "install", "-r", requirementsFile)
+// Installs Python requirements using the pip of the given interpreter
+func InstallRequirements(ctx context.Context, python string, requirementsFile string) error {
+	args := []string{"-m", "pip", "install", "-r", requirementsFile}
+	if Wheelhouse != "" {
+		// Install offline from the local wheelhouse
+		args = append(args, "--no-index", "--find-links", Wheelhouse)
+	}
+	cmd := exec.CommandContext(ctx, python, args...)
+	killProcessTreeOnCancel(cmd)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
@@ -97,154 +101,191 @@ func InstallRequirements(requirementsFile string) error {
 func HashOutput(output AlgorithmResult) (string, error) {
 
 	// Convert the output to canonical JSON format
-	outputBytes, err := json.Marshal(output)
+	outputBytes, err := MarshalCanonical(output)
 	if err != nil {
 		return "", fmt.Errorf("error marshaling output for hashing: %w", err)
 	}
-	fmt.Printf("Hashing Output (Canonical JSON): %s\n", string(outputBytes))
-	hash := sha256.Sum256(outputBytes)
 
-	return hex.EncodeToString(hash[:]), nil
+	return CommitmentHash(outputBytes), nil
 }
 
-// Downloads a random dataset, algorithm, and requirements from IPFS and processes them
-func InitializeAndProcess(datasetCID string, algorithmCID string, requirementsCID string) (AlgorithmResult, error) {
+// Hashes an already canonical result; HashOutput(r) == CommitmentHash(MarshalCanonical(r))
+func CommitmentHash(canonical []byte) string {
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:])
+}
 
-	// Download the dataset
-	fmt.Printf("Downloading dataset with CID: %s\n", datasetCID)
-	datasetData, err := DownloadFile(datasetCID)
-	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error downloading dataset: %w", err)
-	}
-	datasetFileName := fmt.Sprintf("%s.csv", "datset")
-	err = WriteFile(datasetFileName, datasetData)
-	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error saving dataset: %w", err)
-	}
-	fmt.Printf("Dataset saved as '%s'\n", datasetFileName)
+// Downloads the job's dataset, algorithm, and requirements from IPFS and processes them.
+// Also returns the fingerprint of the environment the result was computed in.
+func InitializeAndProcess(ctx context.Context, job Job) (AlgorithmResult, Fingerprint, error) {
+	return executeJob(ctx, job, nil)
+}
 
-	// Download and save the algorithm
-	fmt.Printf("Downloading algorithm file (CID: %s)\n", algorithmCID)
-	algorithmData, err := DownloadFile(algorithmCID)
-	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error downloading algorithm file: %w", err)
+// Processes a job like InitializeAndProcess and publishes its output artifact,
+// the result and any output files, returning the artifact's CID
+func ProcessAndPublish(ctx context.Context, job Job) (AlgorithmResult, Fingerprint, string, error) {
+	var outputCID string
+	result, fingerprint, err := executeJob(ctx, job, func(ctx context.Context, ws *Workspace, result AlgorithmResult) error {
+		var err error
+		outputCID, err = PublishOutput(ctx, ws, result)
+		return err
+	})
+	return result, fingerprint, outputCID, err
+}
+
+// Runs every stage of a job in a private workspace, enforcing the job's deadlines.
+// finish, if set, runs as the publish stage while the workspace still exists.
+func executeJob(ctx context.Context, job Job, finish func(ctx context.Context, ws *Workspace, result AlgorithmResult) error) (AlgorithmResult, Fingerprint, error) {
+	limits := job.Spec.limits()
+	total := time.Duration(limits.Total)
+	if total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, total)
+		defer cancel()
 	}
-	err = WriteFile("algorithm.py", algorithmData)
+
+	workDir, err := os.MkdirTemp("", "job-")
 	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error saving algorithm file: %w", err)
+		return AlgorithmResult{}, Fingerprint{}, fmt.Errorf("error creating workspace: %w", err)
 	}
+	defer os.RemoveAll(workDir)
 
-	// Download and save the requirements file
-	fmt.Printf("Downloading requirements file (CID: %s)\n", requirementsCID)
-	requirementsData, err := DownloadFile(requirementsCID)
-	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error downloading requirements file: %w", err)
+	rt, ok := LookupRuntime(job.Spec.runtime())
+	if !ok {
+		return AlgorithmResult{}, Fingerprint{}, fmt.Errorf("unknown runtime %q", job.Spec.Runtime)
+	}
+	ws := &Workspace{
+		Dir:          workDir,
+		Algorithm:    filepath.Join(workDir, rt.AlgorithmFile()),
+		Args:         job.args(),
+		Job:          job,
+		Dependencies: job.dependencies(rt),
+		Seed:         JobSeed(job),
+		Limits:       limits,
 	}
-	err = WriteFile("requirements.txt", requirementsData)
+	defer ws.Close()
+
+	// Download the datasets and the algorithm
+	err = runStage(ctx, StageDownload, time.Duration(limits.Download), total, func(ctx context.Context) error {
+		for _, input := range job.inputs() {
+			fmt.Printf("Downloading dataset with CID: %s\n", input.CID)
+			path := filepath.Join(workDir, input.Path)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("error creating input directory: %w", err)
+			}
+			if input.Encrypted {
+				if err := downloadEncrypted(ctx, input, path); err != nil {
+					return fmt.Errorf("error downloading dataset %s: %w", input.Name, err)
+				}
+			} else if err := downloadToFile(ctx, input.CID, path); err != nil {
+				return fmt.Errorf("error downloading dataset %s: %w", input.Name, err)
+			}
+			fmt.Printf("Dataset saved as '%s'\n", path)
+		}
+
+		fmt.Printf("Downloading algorithm file (CID: %s)\n", job.AlgorithmCID)
+		if err := downloadToFile(ctx, job.AlgorithmCID, ws.Algorithm); err != nil {
+			return fmt.Errorf("error downloading algorithm file: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error saving requirements file: %w", err)
+		return AlgorithmResult{}, Fingerprint{}, err
 	}
 
-	// Install Python requirements
-	fmt.Println("Installing Python requirements...")
-	err = InstallRequirements("requirements.txt")
+	// Install the dependencies and record what the result is computed with
+	var fingerprint Fingerprint
+	err = runStage(ctx, StageInstall, time.Duration(limits.Install), total, func(ctx context.Context) error {
+		if err := rt.Prepare(ctx, ws); err != nil {
+			return fmt.Errorf("error preparing %s runtime: %w", job.Spec.runtime(), err)
+		}
+		fingerprint, err = rt.Fingerprint(ctx, ws)
+		return err
+	})
 	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error installing requirements: %w", err)
+		return AlgorithmResult{}, Fingerprint{}, err
 	}
-	fmt.Println("Python requirements installed successfully.")
+	fingerprint.Seed = ws.Seed
 
 	// Run the algorithm with the dataset
-	fmt.Println("Running algorithm...")
-	output, err := RunPythonAlgorithm("algorithm.py", datasetFileName)
-
+	var output string
+	err = runStage(ctx, StageRun, time.Duration(limits.Run), total, func(ctx context.Context) error {
+		fmt.Println("Running algorithm...")
+		output, err = rt.Run(ctx, ws)
+		if err != nil {
+			return fmt.Errorf("error running %s algorithm: %w", job.Spec.runtime(), err)
+		}
+		return nil
+	})
 	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error running Python algorithm: %w", err)
+		return AlgorithmResult{}, Fingerprint{}, err
 	}
 
 	// Parse the output into the AlgorithmResult struct
-	var result AlgorithmResult
-	err = json.Unmarshal([]byte(output), &result)
+	result, err := ParseOutput(job.Spec.output(), []byte(output), workDir)
 	if err != nil {
-		return AlgorithmResult{}, fmt.Errorf("error parsing JSON output: %w", err)
+		return AlgorithmResult{}, Fingerprint{}, &StageError{Stage: StageOutput, Err: err}
 	}
 
-	// Add dataset and algorithm CIDs to the result
-	result.Dataset = datasetCID
-	result.Algorithm = algorithmCID
+	// Add dataset, algorithm and manifest CIDs to the result
+	result.Dataset = job.DatasetCID
+	result.Algorithm = job.AlgorithmCID
+	result.Manifest = job.ManifestCID
+
+	// Publish the output before the workspace is removed
+	if finish != nil {
+		err = runStage(ctx, StagePublish, 0, total, func(ctx context.Context) error {
+			return finish(ctx, ws, result)
+		})
+		if err != nil {
+			return AlgorithmResult{}, Fingerprint{}, err
+		}
+	}
 
-	return result, nil
+	return result, fingerprint, nil
 }
 
-// Verifies the output by re-executing the algorithm and comparing results
-func VerifyTransaction(hash string, datasetCID, algorithmCID, requirementsCID string) (bool, error) {
+// Verifies the output by re-executing the algorithm and comparing results.
+// claimed is the canonical result committed to by hash; it is only needed when the
+// job is verified with tolerances, exact verification compares the hashes alone.
+// Cancelling the context aborts the verification, e.g. when a competing block wins.
+func VerifyTransaction(ctx context.Context, hash string, claimed []byte, job Job) (bool, error) {
 	fmt.Println("Verifying transaction...")
 
-	// Download the dataset
-	fmt.Printf("Downloading dataset (CID: %s)...\n", datasetCID)
-	datasetData, err := DownloadFile(datasetCID)
-	if err != nil {
-		return false, fmt.Errorf("error downloading dataset: %w", err)
-	}
-	err = WriteFile("dataset.csv", datasetData)
-	if err != nil {
-		return false, fmt.Errorf("error saving dataset: %w", err)
-	}
-
-	// Download the algorithm
-	fmt.Printf("Downloading algorithm (CID: %s)...\n", algorithmCID)
-	algorithmData, err := DownloadFile(algorithmCID)
-	if err != nil {
-		return false, fmt.Errorf("error downloading algorithm: %w", err)
-	}
-	err = WriteFile("algorithm.py", algorithmData)
-	if err != nil {
-		return false, fmt.Errorf("error saving algorithm: %w", err)
-	}
-
-	// Download the requirements
-	fmt.Printf("Downloading requirements (CID: %s)...\n", requirementsCID)
-	requirementsData, err := DownloadFile(requirementsCID)
-	if err != nil {
-		return false, fmt.Errorf("error downloading requirements: %w", err)
-	}
-	err = WriteFile("requirements.txt", requirementsData)
-	if err != nil {
-		return false, fmt.Errorf("error saving requirements: %w", err)
+	policy := job.Spec.VerifyPolicy()
+	var expected AlgorithmResult
+	if policy.Structured() {
+		// The claimed result must be the one the transaction committed to
+		if CommitmentHash(claimed) != hash {
+			fmt.Println("Transaction verification failed: result does not match its hash.")
+			return false, nil
+		}
+		if err := json.Unmarshal(claimed, &expected); err != nil {
+			return false, fmt.Errorf("error parsing committed result: %w", err)
+		}
 	}
 
-	// Install requirements
-	fmt.Println("Installing Python requirements...")
-	err = InstallRequirements("requirements.txt")
+	// Re-run the job
+	newOutput, _, err := executeJob(ctx, job, nil)
 	if err != nil {
-		return false, fmt.Errorf("error installing requirements: %w", err)
+		return false, err
 	}
 
-	// Run the algorithm and compare results
-	fmt.Println("Running algorithm...")
-	outputString, err := RunPythonAlgorithm("algorithm.py", "dataset.csv")
-	if err != nil {
-		return false, fmt.Errorf("error running Python algorithm: %w", err)
-	}
-
-	// Parse the new output
-	var newOutput AlgorithmResult
-	err = json.Unmarshal([]byte(outputString), &newOutput)
-	if err != nil {
-		return false, fmt.Errorf("error parsing JSON output: %w", err)
+	if policy.Structured() {
+		if err := CompareResults(policy, expected, newOutput); err != nil {
+			fmt.Println("Transaction verification failed:", err)
+			return false, nil
+		}
+		fmt.Println("Transaction verified successfully within tolerance.")
+		return true, nil
 	}
 
-	// Add dataset and algorithm CIDs to the new result
-	newOutput.Dataset = datasetCID
-	newOutput.Algorithm = algorithmCID
-
 	// Hash the new output
 	hashedNewOutput, err := HashOutput(newOutput)
 	if err != nil {
 		return false, fmt.Errorf("error hashing new output: %w", err)
 	}
 
-	fmt.Printf("=========COMPARE | %s == %s |============", hash, hashedNewOutput)
-
 	// Compare the original hash with the newly generated hash
 	if hashedNewOutput == hash {
 		fmt.Println("Transaction verified successfully.")
@@ -0,0 +1,306 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A unit of work: the dataset, algorithm and requirements stored on IPFS plus the job options
+type Job struct {
+	DatasetCID      string   // CID of the dataset (the first input of manifest jobs)
+	AlgorithmCID    string   // CID of the algorithm (script, binary or WASM module)
+	RequirementsCID string   // CID of the dependency manifest, e.g. a requirements file
+	Spec            JobSpec  // Options declared by the generator
+	ManifestCID     string   // CID of the job manifest the job was loaded from, if any
+	Inputs          []Input  // Datasets of manifest jobs; DatasetCID alone otherwise
+	Args            []string // Arguments of manifest jobs; the input paths otherwise
+}
+
+// A dataset placed in the workspace before the algorithm runs
+type Input struct {
+	Name      string `json:"name"`                // Identifier of the input within the job
+	CID       string `json:"cid"`                 // CID of the dataset
+	Path      string `json:"path,omitempty"`      // Location relative to the workspace, the name by default
+	Encrypted bool   `json:"encrypted,omitempty"` // Stored encrypted; decrypted in the workspace with a released key
+}
+
+// Returns the datasets of the job
+func (j Job) inputs() []Input {
+	if len(j.Inputs) > 0 {
+		return j.Inputs
+	}
+	return []Input{{Name: "dataset", CID: j.DatasetCID, Path: "dataset.csv"}}
+}
+
+// Returns the arguments the algorithm is started with
+func (j Job) args() []string {
+	if j.Args != nil {
+		return j.Args
+	}
+	var args []string
+	for _, input := range j.inputs() {
+		args = append(args, input.Path)
+	}
+	return args
+}
+
+// Options a generator can declare for a job; every field is optional
+type JobSpec struct {
+	Runtime      string        `json:"runtime,omitempty"`      // Registered runtime name, RuntimePython by default
+	Dependencies string        `json:"dependencies,omitempty"` // Dependency manifest kind, the runtime's default when unset
+	Limits       *Limits       `json:"limits,omitempty"`       // Deadlines overriding the node defaults
+	Output       *OutputSpec   `json:"output,omitempty"`       // Shape of the result, JSON on stdout by default
+	Verify       *VerifyPolicy `json:"verify,omitempty"`       // How verifiers compare results, exact hashes by default
+	Seed         *int64        `json:"seed,omitempty"`         // RNG seed, derived from the job's CIDs when unset
+}
+
+// Built-in runtimes an algorithm can be executed with
+const (
+	RuntimePython = "python" // Python script, pip requirements
+	RuntimeR      = "r"      // R script run with Rscript, CRAN packages
+	RuntimeShell  = "shell"  // POSIX shell script
+	RuntimeNative = "native" // Executable for the miner's platform
+	RuntimeWASM   = "wasm"   // WASI module run by the embedded runtime
+)
+
+// Checks the options declared by the generator
+func (s JobSpec) Validate() error {
+	rt, ok := LookupRuntime(s.runtime())
+	if !ok {
+		return fmt.Errorf("unknown runtime %q", s.Runtime)
+	}
+	if s.Dependencies != "" {
+		supported := false
+		for _, kind := range rt.Dependencies() {
+			supported = supported || kind == s.Dependencies
+		}
+		if !supported {
+			return fmt.Errorf("runtime %s does not support %q dependencies", s.runtime(), s.Dependencies)
+		}
+	}
+	if s.Limits != nil {
+		if err := s.Limits.Validate(); err != nil {
+			return err
+		}
+	}
+	if s.Output != nil {
+		if err := s.Output.Validate(); err != nil {
+			return err
+		}
+	}
+	if s.Verify != nil {
+		if err := s.Verify.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the job deadlines with the node defaults filled in
+func (s JobSpec) limits() Limits {
+	if s.Limits == nil {
+		return DefaultLimits
+	}
+	return s.Limits.WithDefaults(DefaultLimits)
+}
+
+// Returns the declared runtime, Python when none was declared
+func (s JobSpec) runtime() string {
+	if s.Runtime == "" {
+		return RuntimePython
+	}
+	return s.Runtime
+}
+
+// Returns the dependency manifest kind of a job: the declared one, none when the
+// job has no manifest, and the runtime's default otherwise
+func (j Job) dependencies(rt Runtime) string {
+	if j.Spec.Dependencies != "" {
+		return j.Spec.Dependencies
+	}
+	if j.RequirementsCID == "" {
+		return DependenciesNone
+	}
+	return rt.Dependencies()[0]
+}
+
+// Returns the declared output, JSON on stdout when none was declared
+func (s JobSpec) output() OutputSpec {
+	if s.Output == nil {
+		return OutputSpec{Mode: OutputJSON}
+	}
+	return *s.Output
+}
+
+// Returns the declared verification policy, exact hash comparison when none was declared
+func (s JobSpec) VerifyPolicy() VerifyPolicy {
+	if s.Verify == nil {
+		return VerifyPolicy{Mode: VerifyExact}
+	}
+	return *s.Verify
+}
+
+// Per-stage and total deadlines of a job, zero means the node default
+type Limits struct {
+	Download Duration `json:"download,omitempty"` // Fetching the dataset and algorithm
+	Install  Duration `json:"install,omitempty"`  // Creating the environment and installing requirements
+	Run      Duration `json:"run,omitempty"`      // Executing the algorithm
+	Total    Duration `json:"total,omitempty"`    // Whole job, all stages included
+	Memory   uint32   `json:"memory,omitempty"`   // Memory available to WASM modules, in MiB, at most MaxWasmMemory
+	Fuel     uint64   `json:"fuel,omitempty"`     // Function calls a WASM module may make; loops without calls are bounded by Run only
+}
+
+// Largest memory a WASM module can address: 65536 pages of 64 KiB
+const MaxWasmMemory = 4096
+
+// Deadlines applied when the job spec does not declare its own
+var DefaultLimits = Limits{
+	Download: Duration(DurationEnv("DOWNLOAD_TIMEOUT", 10*time.Minute)),
+	Install:  Duration(DurationEnv("INSTALL_TIMEOUT", 20*time.Minute)),
+	Run:      Duration(DurationEnv("RUN_TIMEOUT", 30*time.Minute)),
+	Total:    Duration(DurationEnv("JOB_TIMEOUT", time.Hour)),
+	Memory:   uint32(uintEnv("WASM_MEMORY_LIMIT", 1024)),
+	Fuel:     uintEnv("WASM_FUEL_LIMIT", 0),
+}
+
+// Checks limits that would overflow the WASM runtime
+func (l Limits) Validate() error {
+	if l.Memory > MaxWasmMemory {
+		return fmt.Errorf("memory limit %d MiB exceeds the %d MiB a wasm module can address", l.Memory, MaxWasmMemory)
+	}
+	if l.Fuel > math.MaxInt64 {
+		return fmt.Errorf("fuel limit %d exceeds %d", l.Fuel, int64(math.MaxInt64))
+	}
+	return nil
+}
+
+// Returns the limits with every unset field taken from the defaults
+func (l Limits) WithDefaults(defaults Limits) Limits {
+	if l.Download == 0 {
+		l.Download = defaults.Download
+	}
+	if l.Install == 0 {
+		l.Install = defaults.Install
+	}
+	if l.Run == 0 {
+		l.Run = defaults.Run
+	}
+	if l.Total == 0 {
+		l.Total = defaults.Total
+	}
+	if l.Memory == 0 {
+		l.Memory = defaults.Memory
+	}
+	if l.Fuel == 0 {
+		l.Fuel = defaults.Fuel
+	}
+	return l
+}
+
+// A time.Duration encoded in JSON as a string such as "90s" or "10m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Accepts either a duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", text, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ========================Stages========================
+
+// A step of the job pipeline
+type Stage string
+
+const (
+	StageDownload Stage = "download"
+	StageInstall  Stage = "install"
+	StageRun      Stage = "run"
+	StageOutput   Stage = "output"
+	StagePublish  Stage = "publish"
+)
+
+// Reports the pipeline stage in which a job failed
+type StageError struct {
+	Stage    Stage
+	TimedOut bool          // The stage or the whole job ran out of time
+	Limit    time.Duration // Deadline that expired, when TimedOut
+	Err      error
+}
+
+func (e *StageError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("%s stage timed out after %s: %v", e.Stage, e.Limit, e.Err)
+	}
+	return fmt.Sprintf("%s stage failed: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Runs fn under the stage deadline and wraps any failure in a StageError.
+// total is the deadline of the whole job, used to report which limit expired.
+func runStage(ctx context.Context, stage Stage, limit, total time.Duration, fn func(ctx context.Context) error) error {
+	stageCtx, cancel := ctx, context.CancelFunc(func() {})
+	if limit > 0 {
+		stageCtx, cancel = context.WithTimeout(ctx, limit)
+	}
+	defer cancel()
+
+	err := fn(stageCtx)
+	if err == nil {
+		return nil
+	}
+
+	stageErr := &StageError{Stage: stage, Err: err}
+	if errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+		stageErr.TimedOut = true
+		stageErr.Limit = limit
+		if ctx.Err() != nil {
+			stageErr.Limit = total // The job deadline expired first
+		}
+	}
+	return stageErr
+}
+
+// Reads a duration from an environment variable, falling back when unset or invalid
+func DurationEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Reads an unsigned integer from an environment variable, falling back when unset or invalid
+func uintEnv(name string, fallback uint64) uint64 {
+	value, err := strconv.ParseUint(os.Getenv(name), 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
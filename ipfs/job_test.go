@@ -0,0 +1,92 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Stage function that runs until its context is done
+func blockingStage(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRunStage(t *testing.T) {
+	errInstall := errors.New("pip failed")
+
+	tests := []struct {
+		name     string
+		stages   []time.Duration // Limit of each stage; every stage before the last succeeds
+		total    time.Duration   // Deadline of the whole job, none when 0
+		fn       func(ctx context.Context) error
+		stage    Stage // Stage the failure is attributed to
+		timedOut bool
+		limit    time.Duration
+		err      error
+	}{
+		{"succeeds", []time.Duration{time.Minute}, time.Minute, func(ctx context.Context) error { return nil }, "", false, 0, nil},
+		{"fails", []time.Duration{time.Minute, time.Minute}, time.Minute, func(ctx context.Context) error { return errInstall }, StageInstall, false, 0, errInstall},
+		{"stage times out", []time.Duration{time.Minute, 50 * time.Millisecond}, time.Minute, blockingStage, StageInstall, true, 50 * time.Millisecond, context.DeadlineExceeded},
+		{"later stage times out", []time.Duration{time.Minute, time.Minute, 50 * time.Millisecond}, 0, blockingStage, StageRun, true, 50 * time.Millisecond, context.DeadlineExceeded},
+		{"total deadline before the stage's", []time.Duration{time.Minute, time.Minute}, 50 * time.Millisecond, blockingStage, StageInstall, true, 50 * time.Millisecond, context.DeadlineExceeded},
+		{"total deadline without a stage limit", []time.Duration{0}, 50 * time.Millisecond, blockingStage, StageDownload, true, 50 * time.Millisecond, context.DeadlineExceeded},
+	}
+	stages := []Stage{StageDownload, StageInstall, StageRun}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.total > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.total)
+				defer cancel()
+			}
+
+			// Stages run in order, like the job pipeline, until one fails
+			var err error
+			for i, limit := range test.stages {
+				fn := func(ctx context.Context) error { return nil }
+				if i == len(test.stages)-1 {
+					fn = test.fn
+				}
+				if err = runStage(ctx, stages[i], limit, test.total, fn); err != nil {
+					break
+				}
+			}
+
+			if test.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var stageErr *StageError
+			if !errors.As(err, &stageErr) {
+				t.Fatalf("error %v, want a StageError", err)
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if stageErr.Stage != test.stage || stageErr.TimedOut != test.timedOut || stageErr.Limit != test.limit {
+				t.Fatalf("%s stage, timed out %v after %s; want %s stage, timed out %v after %s",
+					stageErr.Stage, stageErr.TimedOut, stageErr.Limit, test.stage, test.timedOut, test.limit)
+			}
+		})
+	}
+}
+
+func TestRunStageCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	// A cancelled job is not reported as timed out
+	err := runStage(ctx, StageRun, time.Minute, time.Hour, blockingStage)
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want a cancelled StageError", err)
+	}
+	if stageErr.Stage != StageRun || stageErr.TimedOut {
+		t.Fatalf("%s stage, timed out %v; want run stage, not timed out", stageErr.Stage, stageErr.TimedOut)
+	}
+}
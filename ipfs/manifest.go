@@ -0,0 +1,227 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ========================Job Manifests========================
+
+// Current version of the manifest format
+const ManifestVersion = 1
+
+// A self-contained job description stored on IPFS as JSON or YAML.
+// Transactions reference the manifest by its CID, so one identifier pins down
+// every input, the code, its environment and how the result is checked.
+type Manifest struct {
+	Version      int               `json:"version"`                // Format version, ManifestVersion
+	Name         string            `json:"name,omitempty"`         // Human readable label
+	Runtime      string            `json:"runtime,omitempty"`      // Registered runtime name, RuntimePython by default
+	Entrypoint   string            `json:"entrypoint"`             // CID of the algorithm
+	Dependencies *ManifestDeps     `json:"dependencies,omitempty"` // Dependency manifest, none when unset
+	Inputs       []Input           `json:"inputs"`                 // Datasets placed in the workspace
+	Args         []string          `json:"args,omitempty"`         // Arguments of the algorithm, the input paths by default
+	Limits       *Limits           `json:"limits,omitempty"`       // Deadlines and resource limits
+	Output       *OutputSpec       `json:"output,omitempty"`       // Shape of the result
+	Verify       *VerifyPolicy     `json:"verify,omitempty"`       // How verifiers compare results
+	Replication  int               `json:"replication,omitempty"`  // Number of miners the job is sent to, 1 by default
+	Seed         *int64            `json:"seed,omitempty"`         // RNG seed, derived from the manifest CID when unset
+	Metadata     map[string]string `json:"metadata,omitempty"`     // Free-form annotations, ignored by nodes
+}
+
+// The dependency manifest of a job
+type ManifestDeps struct {
+	Kind string `json:"kind,omitempty"` // DependenciesPip, DependenciesCRAN...; the runtime's default when unset
+	CID  string `json:"cid"`            // CID of the dependency manifest, e.g. a requirements file
+}
+
+// Decodes a manifest from JSON or YAML and validates it
+func ParseManifest(data []byte) (Manifest, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return Manifest{}, fmt.Errorf("manifest is empty")
+	}
+
+	// YAML is converted to JSON so both formats share the JSON decoders of the job options
+	trimmed, err := YAMLToJSON(trimmed)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse YAML manifest: %w", err)
+	}
+
+	var manifest Manifest
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&manifest); err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if err := manifest.Validate(); err != nil {
+		return Manifest{}, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// Downloads a manifest and parses it
+func LoadManifest(ctx context.Context, cid string) (Manifest, error) {
+	data, err := DefaultStore.Get(ctx, cid)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("error downloading manifest %s: %w", cid, err)
+	}
+	return ParseManifest(data)
+}
+
+// Checks that the manifest is complete and consistent
+func (m Manifest) Validate() error {
+	if m.Version != ManifestVersion {
+		return fmt.Errorf("unsupported manifest version %d, expected %d", m.Version, ManifestVersion)
+	}
+	if m.Entrypoint == "" {
+		return fmt.Errorf("entrypoint is required")
+	}
+	if _, err := decodeCID(m.Entrypoint); err != nil {
+		return fmt.Errorf("entrypoint: %w", err)
+	}
+	if m.Dependencies != nil {
+		if _, err := decodeCID(m.Dependencies.CID); err != nil {
+			return fmt.Errorf("dependencies: %w", err)
+		}
+	}
+	if len(m.Inputs) == 0 {
+		return fmt.Errorf("at least one input is required")
+	}
+
+	names := map[string]bool{}
+	paths := map[string]bool{}
+	for i, input := range m.inputs() {
+		if input.Name == "" {
+			return fmt.Errorf("input %d has no name", i)
+		}
+		if names[input.Name] {
+			return fmt.Errorf("duplicate input name %q", input.Name)
+		}
+		names[input.Name] = true
+		if _, err := decodeCID(input.CID); err != nil {
+			return fmt.Errorf("input %s: %w", input.Name, err)
+		}
+
+		// Inputs must stay inside the workspace and not overwrite one another
+		clean := path.Clean(input.Path)
+		if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("input %s: path %q must be relative to the workspace", input.Name, input.Path)
+		}
+		if paths[clean] {
+			return fmt.Errorf("input %s: path %q is used twice", input.Name, input.Path)
+		}
+		paths[clean] = true
+	}
+
+	if m.Replication < 0 {
+		return fmt.Errorf("replication must be positive")
+	}
+	return m.spec().Validate()
+}
+
+// Returns the inputs with their paths defaulted to their names
+func (m Manifest) inputs() []Input {
+	inputs := make([]Input, len(m.Inputs))
+	for i, input := range m.Inputs {
+		if input.Path == "" {
+			input.Path = input.Name
+		}
+		inputs[i] = input
+	}
+	return inputs
+}
+
+// Returns the CIDs of the inputs stored encrypted
+func (m Manifest) EncryptedInputs() []string {
+	var cids []string
+	for _, input := range m.Inputs {
+		if input.Encrypted {
+			cids = append(cids, input.CID)
+		}
+	}
+	return cids
+}
+
+// Returns the number of miners the job is sent to
+func (m Manifest) Copies() int {
+	if m.Replication < 1 {
+		return 1
+	}
+	return m.Replication
+}
+
+// Returns the job options declared by the manifest
+func (m Manifest) spec() JobSpec {
+	spec := JobSpec{
+		Runtime: m.Runtime,
+		Limits:  m.Limits,
+		Output:  m.Output,
+		Verify:  m.Verify,
+		Seed:    m.Seed,
+	}
+	if m.Dependencies != nil {
+		spec.Dependencies = m.Dependencies.Kind
+	}
+	return spec
+}
+
+// Builds the job described by the manifest stored under cid
+func (m Manifest) Job(cid string) Job {
+	inputs := m.inputs()
+	job := Job{
+		DatasetCID:   inputs[0].CID,
+		AlgorithmCID: m.Entrypoint,
+		Spec:         m.spec(),
+		ManifestCID:  cid,
+		Inputs:       inputs,
+		Args:         m.Args,
+	}
+	if m.Dependencies != nil {
+		job.RequirementsCID = m.Dependencies.CID
+	}
+	return job
+}
+
+// Converts a YAML document to JSON; documents that are already JSON objects are returned as is
+func YAMLToJSON(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return trimmed, nil
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(trimmed, &document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(yamlToJSON(document))
+}
+
+// Converts decoded YAML into values encoding/json can marshal
+func yamlToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = yamlToJSON(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = yamlToJSON(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = yamlToJSON(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,391 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================Multiple Backends========================
+
+// Fetches content from several backends. Backends are tried in order of health;
+// the first Race of them can be queried at once, the losers being cancelled.
+// A backend failing FailureThreshold times in a row is skipped for Cooldown,
+// after which it is tried again and skipped anew on its next failure.
+// Content is verified against its CID by the Store, so untrusted gateways are safe:
+// a backend serving wrong content is rejected and another one is asked.
+type MultiBackend struct {
+	Backends         []Backend
+	Race             int           // Backends queried at once, 1 for ordered fallback
+	FailureThreshold int           // Consecutive failures that open a backend's circuit
+	Cooldown         time.Duration // Time an open circuit waits before a trial request
+
+	once    sync.Once
+	health  []*backendHealth
+	mu      sync.Mutex
+	served  map[string][]int        // Backends that served each CID during the current attempt of its fetch
+	exclude map[string]map[int]bool // Backends that served content failing verification, per CID, until its fetch is over
+}
+
+// Health of a backend as tracked by a MultiBackend
+type BackendHealth struct {
+	Name      string        `json:"name"`
+	Available bool          `json:"available"` // False while the circuit is open
+	Failures  int           `json:"failures"`  // Consecutive failures
+	Requests  int64         `json:"requests"`
+	Errors    int64         `json:"errors"`
+	Latency   time.Duration `json:"latency"` // Moving average time to first byte
+}
+
+type backendHealth struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	requests int64
+	errors   int64
+	latency  time.Duration
+}
+
+func (m *MultiBackend) init() {
+	m.once.Do(func() {
+		m.health = make([]*backendHealth, len(m.Backends))
+		for i := range m.health {
+			m.health[i] = &backendHealth{}
+		}
+		m.served = map[string][]int{}
+		m.exclude = map[string]map[int]bool{}
+	})
+}
+
+func (m *MultiBackend) Name() string {
+	names := make([]string, len(m.Backends))
+	for i, backend := range m.Backends {
+		names[i] = backend.Name()
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// Returns the health of every backend
+func (m *MultiBackend) Health() []BackendHealth {
+	m.init()
+	report := make([]BackendHealth, len(m.Backends))
+	for i, backend := range m.Backends {
+		h := m.health[i]
+		h.mu.Lock()
+		report[i] = BackendHealth{
+			Name:      backend.Name(),
+			Available: !m.open(h),
+			Failures:  h.failures,
+			Requests:  h.requests,
+			Errors:    h.errors,
+			Latency:   h.latency,
+		}
+		h.mu.Unlock()
+	}
+	return report
+}
+
+func (m *MultiBackend) Fetch(ctx context.Context, cid string) (io.ReadCloser, error) {
+	return m.fetch(ctx, cid, func(ctx context.Context, backend Backend) (io.ReadCloser, error) {
+		return backend.Fetch(ctx, cid)
+	})
+}
+
+func (m *MultiBackend) Size(ctx context.Context, cid string) (int64, error) {
+	m.init()
+	var errs []error
+	for _, i := range m.order(cid) {
+		ranges, ok := m.Backends[i].(RangeFetcher)
+		if !ok {
+			continue
+		}
+		start := time.Now()
+		size, err := ranges.Size(ctx, cid)
+		m.record(i, err, time.Since(start))
+		if err == nil {
+			return size, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.Backends[i].Name(), err))
+	}
+	if len(errs) == 0 {
+		return 0, ErrRangeUnsupported
+	}
+	return 0, errors.Join(errs...)
+}
+
+func (m *MultiBackend) FetchRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	return m.fetch(ctx, cid, func(ctx context.Context, backend Backend) (io.ReadCloser, error) {
+		ranges, ok := backend.(RangeFetcher)
+		if !ok {
+			return nil, ErrRangeUnsupported
+		}
+		return ranges.FetchRange(ctx, cid, offset, length)
+	})
+}
+
+// Uploads to the first healthy backend that can publish
+func (m *MultiBackend) UploadFile(ctx context.Context, name string, r io.Reader) (string, error) {
+	seeker, _ := r.(io.Seeker)
+	return m.upload(func(backend Backend) (string, error) {
+		if seeker != nil {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return "", err
+			}
+		}
+		return backend.UploadFile(ctx, name, r)
+	}, seeker != nil)
+}
+
+func (m *MultiBackend) UploadDirectory(ctx context.Context, dir string) (string, error) {
+	return m.upload(func(backend Backend) (string, error) {
+		return backend.UploadDirectory(ctx, dir)
+	}, true)
+}
+
+// Tries the writable backends in order; a reader that cannot be rewound gets a single attempt
+func (m *MultiBackend) upload(fn func(backend Backend) (string, error), retry bool) (string, error) {
+	m.init()
+	var errs []error
+	for _, i := range m.order("") {
+		start := time.Now()
+		cid, err := fn(m.Backends[i])
+		if errors.Is(err, ErrReadOnly) {
+			continue
+		}
+		m.record(i, err, time.Since(start))
+		if err == nil {
+			return cid, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.Backends[i].Name(), err))
+		if !retry {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return "", ErrReadOnly
+	}
+	return "", errors.Join(errs...)
+}
+
+// Excludes the backends that served cid from further requests for it and counts a failure
+// against them. Reports whether another backend is left to try.
+func (m *MultiBackend) Reject(cid string) bool {
+	m.init()
+	m.mu.Lock()
+	sources := m.served[cid]
+	delete(m.served, cid)
+	if m.exclude[cid] == nil {
+		m.exclude[cid] = map[int]bool{}
+	}
+	for _, i := range sources {
+		m.exclude[cid][i] = true
+	}
+	m.mu.Unlock()
+
+	for _, i := range sources {
+		fmt.Printf("%s served content not matching %s\n", m.Backends[i].Name(), cid)
+		m.record(i, ErrCIDMismatch, 0)
+	}
+	return len(m.order(cid)) > 0
+}
+
+// Forgets which backends served cid once the Store is done fetching it, successfully or
+// not, so neither map grows with every CID ever fetched
+func (m *MultiBackend) Release(cid string) {
+	m.init()
+	m.mu.Lock()
+	delete(m.served, cid)
+	delete(m.exclude, cid)
+	m.mu.Unlock()
+}
+
+// Runs fn against the available backends, Race at a time, until one succeeds
+func (m *MultiBackend) fetch(ctx context.Context, cid string, fn func(ctx context.Context, backend Backend) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	m.init()
+	order := m.order(cid)
+	if len(order) == 0 {
+		return nil, fmt.Errorf("no backend available for %s", cid)
+	}
+
+	race := m.Race
+	if race < 1 {
+		race = 1
+	}
+	var errs []error
+	for len(order) > 0 && ctx.Err() == nil {
+		n := race
+		if n > len(order) {
+			n = len(order)
+		}
+		body, winner, err := m.race(ctx, order[:n], fn)
+		if err == nil {
+			m.mu.Lock()
+			if !containsIndex(m.served[cid], winner) {
+				m.served[cid] = append(m.served[cid], winner)
+			}
+			m.mu.Unlock()
+			return body, nil
+		}
+		errs = append(errs, err)
+		order = order[n:]
+	}
+	if ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Queries the backends at once and returns the first successful response; the others are cancelled
+func (m *MultiBackend) race(ctx context.Context, backends []int, fn func(ctx context.Context, backend Backend) (io.ReadCloser, error)) (io.ReadCloser, int, error) {
+	type response struct {
+		index  int
+		body   io.ReadCloser
+		err    error
+		cancel context.CancelFunc
+	}
+	responses := make(chan response, len(backends))
+	cancels := make(map[int]context.CancelFunc, len(backends))
+	for _, i := range backends {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		go func(i int, cancel context.CancelFunc) {
+			start := time.Now()
+			body, err := fn(attemptCtx, m.Backends[i])
+			if attemptCtx.Err() == nil || err == nil {
+				m.record(i, err, time.Since(start)) // Cancelled losers are not failures
+			}
+			responses <- response{index: i, body: body, err: err, cancel: cancel}
+		}(i, cancel)
+	}
+
+	var errs []error
+	for received := 0; received < len(backends); received++ {
+		r := <-responses
+		if r.err != nil {
+			r.cancel()
+			errs = append(errs, fmt.Errorf("%s: %w", m.Backends[r.index].Name(), r.err))
+			continue
+		}
+
+		// Cancel the losers and close any body they still return
+		for i, cancel := range cancels {
+			if i != r.index {
+				cancel()
+			}
+		}
+		go func(remaining int) {
+			for ; remaining > 0; remaining-- {
+				if loser := <-responses; loser.body != nil {
+					loser.body.Close()
+				}
+			}
+		}(len(backends) - received - 1)
+		return &cancelOnClose{ReadCloser: r.body, cancel: r.cancel}, r.index, nil
+	}
+	return nil, -1, errors.Join(errs...)
+}
+
+// Returns the backends that may be used for cid, healthiest first
+func (m *MultiBackend) order(cid string) []int {
+	m.mu.Lock()
+	excluded := m.exclude[cid]
+	var order []int
+	for i := range m.Backends {
+		if !excluded[i] && m.allow(i) {
+			order = append(order, i)
+		}
+	}
+	m.mu.Unlock()
+
+	// Backends with fewer recent failures come first; the configured order breaks ties
+	sort.SliceStable(order, func(a, b int) bool {
+		return m.failures(order[a]) < m.failures(order[b])
+	})
+	return order
+}
+
+func (m *MultiBackend) failures(i int) int {
+	h := m.health[i]
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failures
+}
+
+// Reports whether a request may be sent to a backend
+func (m *MultiBackend) allow(i int) bool {
+	h := m.health[i]
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !m.open(h)
+}
+
+// Reports whether the circuit of a backend is open; h.mu must be held
+func (m *MultiBackend) open(h *backendHealth) bool {
+	return h.failures >= m.threshold() && time.Since(h.openedAt) < m.Cooldown
+}
+
+func (m *MultiBackend) threshold() int {
+	if m.FailureThreshold < 1 {
+		return 1
+	}
+	return m.FailureThreshold
+}
+
+// Updates the health of a backend after a request
+func (m *MultiBackend) record(i int, err error, latency time.Duration) {
+	h := m.health[i]
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.requests++
+	if err != nil && !errors.Is(err, ErrRangeUnsupported) {
+		h.errors++
+		h.failures++
+		if errors.Is(err, ErrCIDMismatch) && h.failures < m.threshold() {
+			h.failures = m.threshold() // Serving wrong content is not a transient failure
+		}
+		if h.failures >= m.threshold() {
+			if h.failures == m.threshold() {
+				fmt.Printf("Backend %s failed %d times in a row, pausing it for %s\n", m.Backends[i].Name(), h.failures, m.Cooldown)
+			}
+			h.openedAt = time.Now()
+		}
+		return
+	}
+	if err == nil {
+		h.failures = 0
+		if latency > 0 {
+			if h.latency == 0 {
+				h.latency = latency
+			} else {
+				h.latency = (h.latency*4 + latency) / 5
+			}
+		}
+	}
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, i := range indexes {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Releases the request context of a response once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,215 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serves fixed content, or fails, after an optional delay
+type fakeBackend struct {
+	name    string
+	content []byte
+	err     error
+	delay   time.Duration
+	calls   int32
+	open    int32 // Bodies not closed yet
+	maxOpen int32
+}
+
+func (f *fakeBackend) Name() string { return f.name }
+
+func (f *fakeBackend) Fetch(ctx context.Context, cid string) (io.ReadCloser, error) {
+	atomic.AddInt32(&f.calls, 1)
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	open := atomic.AddInt32(&f.open, 1)
+	for max := atomic.LoadInt32(&f.maxOpen); open > max && !atomic.CompareAndSwapInt32(&f.maxOpen, max, open); {
+		max = atomic.LoadInt32(&f.maxOpen)
+	}
+	return &fakeBody{Reader: bytes.NewReader(f.content), backend: f}, nil
+}
+
+type fakeBody struct {
+	io.Reader
+	backend *fakeBackend
+	closed  bool
+}
+
+func (b *fakeBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		atomic.AddInt32(&b.backend.open, -1)
+	}
+	return nil
+}
+
+func (f *fakeBackend) UploadFile(ctx context.Context, name string, r io.Reader) (string, error) {
+	return "", ErrReadOnly
+}
+
+func (f *fakeBackend) UploadDirectory(ctx context.Context, dir string) (string, error) {
+	return "", ErrReadOnly
+}
+
+var errUnavailable = errors.New("unavailable")
+
+func TestMultiBackendGet(t *testing.T) {
+	content := []byte("multi backend content")
+	cid, err := ComputeCID(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := func() *fakeBackend { return &fakeBackend{name: "good", content: content} }
+	failing := func() *fakeBackend { return &fakeBackend{name: "failing", err: errUnavailable} }
+	bad := func() *fakeBackend { return &fakeBackend{name: "bad", content: []byte("other content")} }
+	slow := func() *fakeBackend { return &fakeBackend{name: "slow", content: content, delay: time.Minute} }
+	// Answers after the failing backends, so racing does not cancel them before they fail
+	late := func(b *fakeBackend) *fakeBackend {
+		b.delay = 50 * time.Millisecond
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		backends []*fakeBackend
+		race     int
+		err      error
+		failures []int // Consecutive failures of each backend afterwards
+	}{
+		{"first serves", []*fakeBackend{good(), failing()}, 1, nil, []int{0, 0}},
+		{"falls back after a failure", []*fakeBackend{failing(), good()}, 1, nil, []int{1, 0}},
+		{"falls back after bad content", []*fakeBackend{bad(), good()}, 1, nil, []int{3, 0}},
+		{"only bad content", []*fakeBackend{bad()}, 1, ErrCIDMismatch, []int{3}},
+		{"all failing", []*fakeBackend{failing(), failing()}, 1, errUnavailable, []int{1, 1}},
+		{"race won by the fast backend", []*fakeBackend{slow(), good()}, 2, nil, []int{0, 0}},
+		{"race with a failing backend", []*fakeBackend{failing(), late(good())}, 2, nil, []int{1, 0}},
+		{"race then fallback", []*fakeBackend{failing(), late(bad()), late(good())}, 2, nil, []int{2, 3, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			multi := &MultiBackend{Race: test.race, FailureThreshold: 3, Cooldown: time.Minute}
+			for _, backend := range test.backends {
+				multi.Backends = append(multi.Backends, backend)
+			}
+			store := &Store{Backend: multi}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			data, err := store.Get(ctx, cid)
+			switch {
+			case test.err != nil && !errors.Is(err, test.err):
+				t.Fatalf("error %v, want %v", err, test.err)
+			case test.err == nil && err != nil:
+				t.Fatal(err)
+			case test.err == nil && !bytes.Equal(data, content):
+				t.Fatalf("got %q", data)
+			}
+			for i, health := range multi.Health() {
+				if health.Failures != test.failures[i] {
+					t.Fatalf("%s has %d failures, want %d", health.Name, health.Failures, test.failures[i])
+				}
+			}
+			if len(multi.served) != 0 || len(multi.exclude) != 0 {
+				t.Fatalf("sources of %s kept after the fetch", cid)
+			}
+		})
+	}
+}
+
+func TestMultiBackendCircuitBreaker(t *testing.T) {
+	content := []byte("circuit breaker content")
+	cid, _ := ComputeCID(content)
+	failing := &fakeBackend{name: "failing", err: errUnavailable}
+	good := &fakeBackend{name: "good", content: content}
+	multi := &MultiBackend{Backends: []Backend{failing, good}, Race: 1, FailureThreshold: 2, Cooldown: 100 * time.Millisecond}
+	store := &Store{Backend: multi}
+
+	// The failing backend is tried first, then ordered after the good one once it failed
+	for i := 0; i < 2; i++ {
+		if _, err := store.Get(context.Background(), cid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := atomic.LoadInt32(&failing.calls); calls != 1 {
+		t.Fatalf("failing backend called %d times, want 1", calls)
+	}
+	multi.Backends = []Backend{failing}
+	if _, err := store.Get(context.Background(), cid); !errors.Is(err, errUnavailable) {
+		t.Fatalf("error %v, want %v", err, errUnavailable)
+	}
+	if health := multi.Health()[0]; health.Available || health.Failures != 2 {
+		t.Fatalf("circuit not open after 2 failures: %+v", health)
+	}
+
+	// While open, the backend is skipped without being called
+	if _, err := store.Get(context.Background(), cid); err == nil || !strings.Contains(err.Error(), "no backend available") {
+		t.Fatalf("error %v with every circuit open, want no backend available", err)
+	}
+	if calls := atomic.LoadInt32(&failing.calls); calls != 2 {
+		t.Fatalf("open circuit called, %d calls", calls)
+	}
+
+	// After the cooldown the backend gets a trial request, and a success closes the circuit
+	time.Sleep(150 * time.Millisecond)
+	failing.err, failing.content = nil, content
+	if _, err := store.Get(context.Background(), cid); err != nil {
+		t.Fatal(err)
+	}
+	if health := multi.Health()[0]; !health.Available || health.Failures != 0 {
+		t.Fatalf("circuit not closed after a successful trial: %+v", health)
+	}
+}
+
+func TestMultiBackendConcurrentGets(t *testing.T) {
+	content := []byte("concurrently fetched content")
+	cid, _ := ComputeCID(content)
+	other := []byte("other concurrently fetched content")
+	otherCID, _ := ComputeCID(other)
+	backend := &fakeBackend{name: "slow", content: content, delay: 5 * time.Millisecond}
+	otherBackend := &fakeBackend{name: "other", content: other, delay: 5 * time.Millisecond}
+	multi := &MultiBackend{Backends: []Backend{backend}, FailureThreshold: 3, Cooldown: time.Minute}
+	otherMulti := &MultiBackend{Backends: []Backend{otherBackend}, FailureThreshold: 3, Cooldown: time.Minute}
+
+	// Fetches of one CID share its recorded sources, so they must not overlap, even
+	// through different stores; fetches of different CIDs may
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		store, want, id := &Store{Backend: multi}, content, cid
+		if i%2 == 1 {
+			store, want, id = &Store{Backend: otherMulti}, other, otherCID
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := store.Get(context.Background(), id)
+			if err == nil && !bytes.Equal(data, want) {
+				err = errors.New("wrong content returned")
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.maxOpen != 1 || otherBackend.maxOpen != 1 {
+		t.Fatalf("fetches of the same CID overlapped: %d and %d at once", backend.maxOpen, otherBackend.maxOpen)
+	}
+}
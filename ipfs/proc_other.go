@@ -0,0 +1,13 @@
+//go:build !unix
+
+package ipfs
+
+import (
+	"os/exec"
+	"time"
+)
+
+// Process groups are not available, so cancellation only kills the command itself
+func killProcessTreeOnCancel(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package ipfs
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Runs the command in its own process group so cancellation kills every child it spawned
+func killProcessTreeOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+}
@@ -0,0 +1,258 @@
+package ipfs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ========================Algorithm Output========================
+
+// How the algorithm's standard output is turned into a result
+const (
+	OutputJSON = "json" // Stdout is a JSON document, stored in canonical form (default)
+	OutputRaw  = "raw"  // Stdout is opaque bytes, stored base64 encoded
+	OutputNone = "none" // Stdout is ignored, only the declared output files count
+)
+
+// Declares the output an algorithm produces
+type OutputSpec struct {
+	Mode   string          `json:"mode,omitempty"`   // One of OutputJSON, OutputRaw or OutputNone
+	Schema json.RawMessage `json:"schema,omitempty"` // JSON Schema the result must satisfy (json mode only)
+	Files  []string        `json:"files,omitempty"`  // Files written by the algorithm, relative to its working directory
+}
+
+// Checks that the output declaration is usable
+func (o OutputSpec) Validate() error {
+	switch o.Mode {
+	case "", OutputJSON, OutputRaw, OutputNone:
+	default:
+		return fmt.Errorf("unknown output mode %q", o.Mode)
+	}
+	if len(o.Schema) > 0 {
+		if o.Mode == OutputRaw || o.Mode == OutputNone {
+			return fmt.Errorf("output schema requires json mode")
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal(o.Schema, &schema); err != nil {
+			return fmt.Errorf("invalid output schema: %w", err)
+		}
+	}
+	for _, name := range o.Files {
+		if name == "" || filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") {
+			return fmt.Errorf("output file %q must be a relative path inside the workspace", name)
+		}
+	}
+	return nil
+}
+
+// Builds the result of a run from the algorithm's stdout and the files it wrote into workDir
+func ParseOutput(spec OutputSpec, stdout []byte, workDir string) (AlgorithmResult, error) {
+	if err := spec.Validate(); err != nil {
+		return AlgorithmResult{}, err
+	}
+
+	var result AlgorithmResult
+	switch spec.Mode {
+	case "", OutputJSON:
+		canonical, err := CanonicalJSON(stdout)
+		if err != nil {
+			return AlgorithmResult{}, fmt.Errorf("error parsing JSON output: %w", err)
+		}
+		if len(spec.Schema) > 0 {
+			if err := ValidateSchema(spec.Schema, canonical); err != nil {
+				return AlgorithmResult{}, fmt.Errorf("output does not match schema: %w", err)
+			}
+		}
+		result.Result = canonical
+	case OutputRaw:
+		encoded, _ := json.Marshal(base64.StdEncoding.EncodeToString(stdout))
+		result.Result = encoded
+	}
+
+	if len(spec.Files) > 0 {
+		result.Files = make(map[string]string, len(spec.Files))
+		for _, name := range spec.Files {
+			hash, err := hashFile(filepath.Join(workDir, name))
+			if err != nil {
+				return AlgorithmResult{}, fmt.Errorf("error reading output file %s: %w", name, err)
+			}
+			result.Files[name] = hash
+		}
+	}
+	return result, nil
+}
+
+// Returns the hex SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// ========================Schema Validation========================
+
+// Validates a JSON document against a JSON Schema. The supported keywords are
+// type, enum, properties, required, additionalProperties, items, minItems,
+// maxItems, minimum and maximum.
+func ValidateSchema(schema json.RawMessage, document []byte) error {
+	var s map[string]interface{}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	value, err := decodeJSON(document)
+	if err != nil {
+		return err
+	}
+	return validateValue(s, value, "$")
+}
+
+func validateValue(schema map[string]interface{}, value interface{}, path string) error {
+	if t, ok := schema["type"]; ok {
+		if !matchesType(t, value) {
+			return fmt.Errorf("%s: expected type %v, got %s", path, t, jsonType(value))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if sameJSON(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed values", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		if min, ok := schema["minimum"].(float64); ok && f < min {
+			return fmt.Errorf("%s: %v is below the minimum %v", path, v, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && f > max {
+			return fmt.Errorf("%s: %v is above the maximum %v", path, v, max)
+		}
+
+	case []interface{}:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: expected at least %v items, got %d", path, min, len(v))
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(v)) > max {
+			return fmt.Errorf("%s: expected at most %v items, got %d", path, max, len(v))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateValue(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := v[key]; !present {
+						return fmt.Errorf("%s: missing required property %q", path, key)
+					}
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if propSchema, ok := properties[key].(map[string]interface{}); ok {
+				if err := validateValue(propSchema, v[key], path+"."+key); err != nil {
+					return err
+				}
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+			case map[string]interface{}:
+				if err := validateValue(additional, v[key], path+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Reports whether value matches a "type" keyword, which is either a name or a list of names
+func matchesType(t interface{}, value interface{}) bool {
+	if names, ok := t.([]interface{}); ok {
+		for _, name := range names {
+			if matchesType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	name, _ := t.(string)
+	actual := jsonType(value)
+	if name == "number" && actual == "integer" {
+		return true
+	}
+	return name == actual
+}
+
+// Returns the JSON Schema type name of a decoded value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		if f, err := v.Float64(); err == nil && f == float64(int64(f)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// Compares a schema value (decoded without UseNumber) with a document value
+func sameJSON(a, b interface{}) bool {
+	left, err := MarshalCanonical(a)
+	if err != nil {
+		return false
+	}
+	right, err := MarshalCanonical(b)
+	if err != nil {
+		return false
+	}
+	return string(left) == string(right)
+}
@@ -0,0 +1,113 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"sync"
+)
+
+// ========================Runtimes========================
+
+// Kinds of dependency manifest a job can declare
+const (
+	DependenciesNone = "none" // The algorithm needs nothing installed
+	DependenciesPip  = "pip"  // requirements.txt installed with pip
+	DependenciesCRAN = "cran" // One R package name per line, installed from CRAN
+)
+
+// An execution backend for algorithms. A runtime prepares the workspace
+// (installing dependencies), runs the algorithm and describes the environment
+// it ran in; the download, deadline and verification pipeline is shared.
+type Runtime interface {
+	AlgorithmFile() string  // Name the algorithm is saved under in the workspace
+	Dependencies() []string // Dependency manifest kinds supported, the first is the default
+	Prepare(ctx context.Context, ws *Workspace) error
+	Run(ctx context.Context, ws *Workspace) (string, error)
+	Fingerprint(ctx context.Context, ws *Workspace) (Fingerprint, error)
+}
+
+// The private directory a job executes in, shared by the stages of one run
+type Workspace struct {
+	Dir          string       // Working directory of the algorithm
+	Algorithm    string       // Path of the downloaded algorithm
+	Args         []string     // Arguments passed to the algorithm, input paths relative to Dir
+	Job          Job          // Job being executed
+	Dependencies string       // Dependency manifest kind in effect
+	Seed         int64        // RNG seed of the run
+	Limits       Limits       // Deadlines and resource limits in effect
+	Env          *Environment // Cached environment set up by Prepare, if any
+	cleanup      []func()
+}
+
+// Registers a function to run once the job is finished
+func (ws *Workspace) OnClose(fn func()) {
+	ws.cleanup = append(ws.cleanup, fn)
+}
+
+// Releases everything the runtime acquired for the job
+func (ws *Workspace) Close() {
+	for i := len(ws.cleanup) - 1; i >= 0; i-- {
+		ws.cleanup[i]()
+	}
+	ws.cleanup = nil
+}
+
+var runtimes = map[string]Runtime{}
+var runtimesMu sync.RWMutex
+
+// Makes a runtime available to jobs under the given name
+func RegisterRuntime(name string, runtime Runtime) {
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+	runtimes[name] = runtime
+}
+
+// Returns the runtime registered under name
+func LookupRuntime(name string) (Runtime, bool) {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	runtime, ok := runtimes[name]
+	return runtime, ok
+}
+
+// Returns the names of all registered runtimes
+func Runtimes() []string {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+
+	names := make([]string, 0, len(runtimes))
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterRuntime(RuntimePython, pythonRuntime{})
+	RegisterRuntime(RuntimeR, rRuntime{})
+	RegisterRuntime(RuntimeShell, shellRuntime{})
+	RegisterRuntime(RuntimeNative, nativeRuntime{})
+	RegisterRuntime(RuntimeWASM, wasmRuntime{})
+}
+
+// Runs a command inside the workspace with the deterministic environment and captures its stdout.
+// Cancelling the context kills the command together with any process it started.
+func runInWorkspace(ctx context.Context, ws *Workspace, extraEnv []string, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = ws.Dir
+	cmd.Env = append(deterministicEnv(ws.Dir, ws.Seed), extraEnv...)
+	killProcessTreeOnCancel(cmd)
+
+	var out bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run %s: %w, stderr: %s", name, err, stderr.String())
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,57 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// Runs POSIX shell scripts; they can only use tools already present on the miner
+type shellRuntime struct{}
+
+func (shellRuntime) AlgorithmFile() string { return "algorithm.sh" }
+
+func (shellRuntime) Dependencies() []string { return []string{DependenciesNone} }
+
+func (shellRuntime) Prepare(ctx context.Context, ws *Workspace) error { return nil }
+
+func (shellRuntime) Run(ctx context.Context, ws *Workspace) (string, error) {
+	return runInWorkspace(ctx, ws, nil, "sh", append([]string{ws.Algorithm}, ws.Args...)...)
+}
+
+func (shellRuntime) Fingerprint(ctx context.Context, ws *Workspace) (Fingerprint, error) {
+	// The resolved interpreter tells dash, bash and busybox apart
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		return Fingerprint{}, fmt.Errorf("failed to locate sh: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(shell); err == nil {
+		shell = resolved
+	}
+	return Fingerprint{Runtime: RuntimeShell, Version: shell, Platform: runtime.GOOS + "/" + runtime.GOARCH}, nil
+}
+
+// Runs a self-contained executable fetched by CID; it must match the miner's platform
+type nativeRuntime struct{}
+
+func (nativeRuntime) AlgorithmFile() string { return "algorithm" }
+
+func (nativeRuntime) Dependencies() []string { return []string{DependenciesNone} }
+
+func (nativeRuntime) Prepare(ctx context.Context, ws *Workspace) error {
+	if err := os.Chmod(ws.Algorithm, 0755); err != nil {
+		return fmt.Errorf("failed to make algorithm executable: %w", err)
+	}
+	return nil
+}
+
+func (nativeRuntime) Run(ctx context.Context, ws *Workspace) (string, error) {
+	return runInWorkspace(ctx, ws, nil, ws.Algorithm, ws.Args...)
+}
+
+func (nativeRuntime) Fingerprint(ctx context.Context, ws *Workspace) (Fingerprint, error) {
+	return Fingerprint{Runtime: RuntimeNative, Platform: runtime.GOOS + "/" + runtime.GOARCH}, nil
+}
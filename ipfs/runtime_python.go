@@ -0,0 +1,45 @@
+package ipfs
+
+import (
+	"context"
+	"runtime"
+)
+
+// Runs Python scripts in cached virtual environments built from a pip requirements file
+type pythonRuntime struct{}
+
+func (pythonRuntime) AlgorithmFile() string { return "algorithm.py" }
+
+func (pythonRuntime) Dependencies() []string { return []string{DependenciesPip, DependenciesNone} }
+
+func (pythonRuntime) Prepare(ctx context.Context, ws *Workspace) error {
+	if ws.Dependencies == DependenciesNone {
+		return nil
+	}
+	env, err := EnsureEnvironment(ctx, ws.Job.RequirementsCID)
+	if err != nil {
+		return err
+	}
+	ws.Env = env
+	ws.OnClose(env.Release)
+	return nil
+}
+
+func (pythonRuntime) Run(ctx context.Context, ws *Workspace) (string, error) {
+	python := PythonInterpreter
+	if ws.Env != nil {
+		python = ws.Env.Interpreter
+	}
+	return RunPythonAlgorithm(ctx, python, ws.Algorithm, ws.Args, ws.Seed)
+}
+
+func (pythonRuntime) Fingerprint(ctx context.Context, ws *Workspace) (Fingerprint, error) {
+	if ws.Env != nil {
+		return EnvironmentFingerprint(ctx, ws.Env)
+	}
+	version, err := PythonVersion()
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	return Fingerprint{Runtime: RuntimePython, Version: version, Platform: runtime.GOOS + "/" + runtime.GOARCH}, nil
+}
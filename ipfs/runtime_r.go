@@ -0,0 +1,142 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// R front-end used to run scripts and install packages
+var RInterpreter = getEnv("RSCRIPT", "Rscript")
+
+// CRAN mirror packages are installed from
+var CRANMirror = getEnv("CRAN_MIRROR", "https://cloud.r-project.org")
+
+// Installs the packages listed in R_PACKAGES into R_LIBS_USER and fails if any of them cannot be loaded
+const rInstallScript = `pkgs <- trimws(readLines(Sys.getenv("R_PACKAGES")))
+pkgs <- pkgs[nzchar(pkgs) & !startsWith(pkgs, "#")]
+lib <- Sys.getenv("R_LIBS_USER")
+install.packages(pkgs, lib = lib, repos = Sys.getenv("CRAN_MIRROR"))
+for (p in pkgs) if (!requireNamespace(p, lib.loc = lib, quietly = TRUE)) quit(status = 1)
+`
+
+// Lists the packages installed in R_LIBS_USER, one "name version" pair per line
+const rListScript = `ip <- installed.packages(lib.loc = Sys.getenv("R_LIBS_USER"))
+cat(paste(ip[, "Package"], ip[, "Version"]), sep = "\n")
+`
+
+// Seeds R's RNG before the algorithm starts, loaded through R_PROFILE_USER
+const rProfile = `set.seed(as.integer(as.numeric(Sys.getenv("JOB_SEED")) %% .Machine$integer.max))
+`
+
+var (
+	rVersionOnce sync.Once
+	rVersion     string
+	rVersionErr  error
+)
+
+// Returns the version of the configured R installation (e.g. "4.3.2")
+func RVersion() (string, error) {
+	rVersionOnce.Do(func() {
+		out, err := exec.Command(RInterpreter, "-e", "cat(as.character(getRversion()))").Output()
+		if err != nil {
+			rVersionErr = fmt.Errorf("failed to query R version: %w", err)
+			return
+		}
+		rVersion = strings.TrimSpace(string(out))
+	})
+	return rVersion, rVersionErr
+}
+
+// Runs R scripts with a cached package library built from a list of CRAN packages
+type rRuntime struct{}
+
+func (rRuntime) AlgorithmFile() string { return "algorithm.R" }
+
+func (rRuntime) Dependencies() []string { return []string{DependenciesCRAN, DependenciesNone} }
+
+func (rRuntime) Prepare(ctx context.Context, ws *Workspace) error {
+	if ws.Dependencies == DependenciesNone {
+		return nil
+	}
+	version, err := RVersion()
+	if err != nil {
+		return err
+	}
+
+	manifestCID := ws.Job.RequirementsCID
+	key := environmentKey("r", manifestCID, version)
+	env := &Environment{Key: key, Dir: filepath.Join(EnvCacheDir, key), Interpreter: RInterpreter}
+
+	err = ensureCached(ctx, env, manifestCID, func(ctx context.Context) error {
+		fmt.Printf("Creating R library %s for packages (CID: %s)\n", key, manifestCID)
+		if err := os.MkdirAll(filepath.Join(env.Dir, "library"), 0755); err != nil {
+			return fmt.Errorf("failed to create R library: %w", err)
+		}
+		packagesFile := filepath.Join(env.Dir, "packages.txt")
+		if err := downloadToFile(ctx, manifestCID, packagesFile); err != nil {
+			return fmt.Errorf("error downloading package list: %w", err)
+		}
+
+		fmt.Println("Installing R packages...")
+		installEnv := []string{
+			"R_PACKAGES=" + packagesFile,
+			"R_LIBS_USER=" + filepath.Join(env.Dir, "library"),
+			"CRAN_MIRROR=" + CRANMirror,
+		}
+		if _, err := runInWorkspace(ctx, &Workspace{Dir: env.Dir, Seed: ws.Seed}, installEnv, RInterpreter, "-e", rInstallScript); err != nil {
+			return fmt.Errorf("failed to install R packages: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ws.Env = env
+	ws.OnClose(env.Release)
+	return nil
+}
+
+func (rRuntime) Run(ctx context.Context, ws *Workspace) (string, error) {
+	profile := filepath.Join(ws.Dir, ".Rprofile")
+	if err := WriteFile(profile, []byte(rProfile)); err != nil {
+		return "", err
+	}
+	return runInWorkspace(ctx, ws, rEnv(ws, "R_PROFILE_USER="+profile), RInterpreter, append([]string{ws.Algorithm}, ws.Args...)...)
+}
+
+func (rRuntime) Fingerprint(ctx context.Context, ws *Workspace) (Fingerprint, error) {
+	version, err := RVersion()
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	fingerprint := Fingerprint{Runtime: RuntimeR, Version: version, Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	if ws.Env == nil {
+		return fingerprint, nil
+	}
+
+	out, err := runInWorkspace(ctx, ws, rEnv(ws), RInterpreter, "-e", rListScript)
+	if err != nil {
+		return Fingerprint{}, fmt.Errorf("failed to list installed R packages: %w", err)
+	}
+	fingerprint.Packages = map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if name, version, ok := strings.Cut(line, " "); ok {
+			fingerprint.Packages[name] = version
+		}
+	}
+	return fingerprint, nil
+}
+
+// Environment variables pointing R at the job's package library
+func rEnv(ws *Workspace, extra ...string) []string {
+	if ws.Env == nil {
+		return extra
+	}
+	return append(extra, "R_LIBS_USER="+filepath.Join(ws.Env.Dir, "library"))
+}
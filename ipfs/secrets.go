@@ -0,0 +1,212 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ========================Secrets========================
+
+// Credentials are never stored in code or configuration structs. Backends hold the
+// name of the secret they need, e.g. "pinata/jwt", and resolve it on every request,
+// so a rotated secret takes effect without restarting the node.
+
+// Returned by providers that do not hold the requested secret
+var ErrSecretNotFound = errors.New("secret not found")
+
+// A credential; it prints as [REDACTED] so it cannot leak through logs by accident
+type Secret string
+
+func (s Secret) String() string   { return "[REDACTED]" }
+func (s Secret) GoString() string { return "[REDACTED]" }
+
+func (s Secret) MarshalJSON() ([]byte, error) { return []byte(`"[REDACTED]"`), nil }
+
+// Returns the value of the secret, for use in requests only
+func (s Secret) Reveal() string { return string(s) }
+
+// Supplies secrets by name
+type SecretProvider interface {
+	Secret(ctx context.Context, name string) (Secret, error)
+}
+
+// Provider used by the backends, configured from the environment: SECRETS_COMMAND
+// (a helper printing the secret named by its argument), then SECRETS_FILE, then
+// environment variables. Replace it to plug in another secret store.
+var Secrets SecretProvider = secretsFromEnv()
+
+func secretsFromEnv() SecretProvider {
+	var chain ChainSecrets
+	if command := os.Getenv("SECRETS_COMMAND"); command != "" {
+		chain = append(chain, &CommandSecrets{Command: command, TTL: DurationEnv("SECRETS_TTL", time.Minute)})
+	}
+	if file := os.Getenv("SECRETS_FILE"); file != "" {
+		chain = append(chain, &FileSecrets{Path: file})
+	}
+	return append(chain, EnvSecrets{})
+}
+
+// Resolves a secret through the configured provider and remembers its value for redaction
+func lookupSecret(ctx context.Context, name string) (Secret, error) {
+	secret, err := Secrets.Secret(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	rememberSecret(secret)
+	return secret, nil
+}
+
+// Tries providers in order until one holds the secret
+type ChainSecrets []SecretProvider
+
+func (c ChainSecrets) Secret(ctx context.Context, name string) (Secret, error) {
+	for _, provider := range c {
+		secret, err := provider.Secret(ctx, name)
+		if err == nil {
+			return secret, nil
+		}
+		if !errors.Is(err, ErrSecretNotFound) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+}
+
+// Reads secrets from environment variables: "pinata/jwt" is read from PINATA_JWT
+type EnvSecrets struct{}
+
+func (EnvSecrets) Secret(ctx context.Context, name string) (Secret, error) {
+	value := os.Getenv(SecretEnvName(name))
+	if value == "" {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return Secret(value), nil
+}
+
+// Returns the environment variable a secret is read from
+func SecretEnvName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// Reads secrets from a JSON object of names to values. The file is read again
+// whenever it changes, so secrets can be rotated by rewriting it.
+type FileSecrets struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	secrets map[string]string
+}
+
+func (f *FileSecrets) Secret(ctx context.Context, name string) (Secret, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secrets file: %w", err)
+	}
+	if f.secrets == nil || !info.ModTime().Equal(f.modTime) || info.Size() != f.size {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secrets file: %w", err)
+		}
+		var secrets map[string]string
+		if err := json.Unmarshal(data, &secrets); err != nil {
+			return "", fmt.Errorf("failed to parse secrets file %s: %w", f.Path, err)
+		}
+		f.secrets, f.modTime, f.size = secrets, info.ModTime(), info.Size()
+	}
+
+	value, ok := f.secrets[name]
+	if !ok || value == "" {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return Secret(value), nil
+}
+
+// Runs a helper program with the secret name as its only argument and reads the secret
+// from its standard output, e.g. a wrapper around a vault CLI. An exit status of 2 means
+// the helper does not know the secret. Values are cached for TTL.
+type CommandSecrets struct {
+	Command string
+	TTL     time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	secret  Secret
+	expires time.Time
+}
+
+func (c *CommandSecrets) Secret(ctx context.Context, name string) (Secret, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.cache[name]; ok && time.Now().Before(cached.expires) {
+		return cached.secret, nil
+	}
+
+	cmd := exec.CommandContext(ctx, c.Command, name)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("secrets command failed for %s: %w, stderr: %s", name, err, RedactSecrets(stderr.String()))
+	}
+
+	secret := Secret(strings.TrimSpace(string(out)))
+	if secret == "" {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if c.cache == nil {
+		c.cache = map[string]cachedSecret{}
+	}
+	c.cache[name] = cachedSecret{secret: secret, expires: time.Now().Add(c.TTL)}
+	return secret, nil
+}
+
+// ========================Redaction========================
+
+var knownSecrets = map[string]bool{}
+var knownSecretsMu sync.RWMutex
+
+func rememberSecret(secret Secret) {
+	if len(secret) < 4 {
+		return // Too short to redact without mangling unrelated text
+	}
+	knownSecretsMu.Lock()
+	knownSecrets[string(secret)] = true
+	knownSecretsMu.Unlock()
+}
+
+// Replaces every secret resolved so far with [REDACTED], for text that may echo credentials
+func RedactSecrets(text string) string {
+	knownSecretsMu.RLock()
+	defer knownSecretsMu.RUnlock()
+	for value := range knownSecrets {
+		text = strings.ReplaceAll(text, value, "[REDACTED]")
+	}
+	return text
+}
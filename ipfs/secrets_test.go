@@ -0,0 +1,282 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	secrets := &FileSecrets{Path: path}
+	modTime := time.Now().Add(-time.Hour)
+	write := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Rewrites get distinct modification times, even on filesystems with coarse timestamps
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(name, want string, wantErr error) {
+		t.Helper()
+		secret, err := secrets.Secret(context.Background(), name)
+		if wantErr != nil {
+			if !errors.Is(err, wantErr) {
+				t.Fatalf("%s: error %v, want %v", name, err, wantErr)
+			}
+			return
+		}
+		if err != nil || secret.Reveal() != want {
+			t.Fatalf("%s: got %q, %v, want %q", name, secret.Reveal(), err, want)
+		}
+	}
+
+	check("pinata/jwt", "", os.ErrNotExist)
+	write(`{"pinata/jwt": "first-token", "empty": ""}`)
+	check("pinata/jwt", "first-token", nil)
+	check("empty", "", ErrSecretNotFound)
+	check("missing", "", ErrSecretNotFound)
+
+	// A rotated secret is read once the file changes, even at the same size
+	write(`{"pinata/jwt": "other-token", "empty": ""}`)
+	check("pinata/jwt", "other-token", nil)
+
+	// An unchanged file is not read again
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), "other", "third", 1)), 0600)
+	os.Chtimes(path, modTime, modTime)
+	check("pinata/jwt", "other-token", nil)
+
+	write(`{"pinata/jwt": `)
+	if _, err := secrets.Secret(context.Background(), "pinata/jwt"); err == nil || errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("malformed file: error %v", err)
+	}
+}
+
+// Writes a secrets helper printing "value-of-<name>", exiting with 2 for names starting with
+// "unknown" and failing for names starting with "broken". Each run appends a line to the
+// returned log.
+func secretsHelper(t *testing.T) (command, log string) {
+	t.Helper()
+	dir := t.TempDir()
+	command, log = filepath.Join(dir, "secrets-helper"), filepath.Join(dir, "runs")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$1" >> %s
+case "$1" in
+unknown*) exit 2 ;;
+broken*) echo "cannot reach the vault with value-of-known" >&2; exit 1 ;;
+blank*) echo ;;
+*) echo "value-of-$1" ;;
+esac
+`, log)
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return command, log
+}
+
+func helperRuns(t *testing.T, log string) int {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestCommandSecrets(t *testing.T) {
+	command, _ := secretsHelper(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		secret   string
+		want     string
+		notFound bool
+	}{
+		{"printed", "pinata/jwt", "value-of-pinata/jwt", false},
+		{"exit status 2", "unknown/key", "", true},
+		{"empty output", "blank/key", "", true},
+		{"failed", "broken/key", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secrets := &CommandSecrets{Command: command, TTL: time.Minute}
+			secret, err := secrets.Secret(ctx, test.secret)
+			switch {
+			case test.want != "" && (err != nil || secret.Reveal() != test.want):
+				t.Fatalf("got %q, %v, want %q", secret.Reveal(), err, test.want)
+			case test.want == "" && err == nil:
+				t.Fatalf("got %q, want an error", secret.Reveal())
+			case test.want == "" && errors.Is(err, ErrSecretNotFound) != test.notFound:
+				t.Fatalf("error %v, not found %v", err, test.notFound)
+			}
+		})
+	}
+
+	// The helper's error output is redacted
+	rememberSecret("value-of-known")
+	_, err := (&CommandSecrets{Command: command}).Secret(ctx, "broken/key")
+	if err == nil || strings.Contains(err.Error(), "value-of-known") || !strings.Contains(err.Error(), "[REDACTED]") {
+		t.Fatalf("error not redacted: %v", err)
+	}
+}
+
+func TestCommandSecretsCache(t *testing.T) {
+	command, log := secretsHelper(t)
+	ctx := context.Background()
+	secrets := &CommandSecrets{Command: command, TTL: 100 * time.Millisecond}
+
+	for i := 0; i < 3; i++ {
+		if _, err := secrets.Secret(ctx, "pinata/jwt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs := helperRuns(t, log); runs != 1 {
+		t.Fatalf("helper run %d times within the TTL, want 1", runs)
+	}
+
+	// Secrets are cached by name, and secrets not found are not cached
+	secrets.Secret(ctx, "pinata/key")
+	secrets.Secret(ctx, "unknown/key")
+	secrets.Secret(ctx, "unknown/key")
+	if runs := helperRuns(t, log); runs != 4 {
+		t.Fatalf("helper run %d times, want 4", runs)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := secrets.Secret(ctx, "pinata/jwt"); err != nil {
+		t.Fatal(err)
+	}
+	if runs := helperRuns(t, log); runs != 5 {
+		t.Fatalf("helper run %d times after the TTL, want 5", runs)
+	}
+}
+
+// Holds fixed secrets, or fails every lookup with err
+type staticSecrets struct {
+	secrets map[string]string
+	err     error
+	calls   int
+}
+
+func (s *staticSecrets) Secret(ctx context.Context, name string) (Secret, error) {
+	s.calls++
+	if s.err != nil {
+		return "", s.err
+	}
+	value, ok := s.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return Secret(value), nil
+}
+
+func TestChainSecrets(t *testing.T) {
+	errVault := errors.New("vault unreachable")
+
+	tests := []struct {
+		name  string
+		chain func() []*staticSecrets
+		want  string
+		err   error
+		calls []int // Lookups of each provider
+	}{
+		{"first provider holds it", func() []*staticSecrets {
+			return []*staticSecrets{{secrets: map[string]string{"key": "first"}}, {secrets: map[string]string{"key": "second"}}}
+		}, "first", nil, []int{1, 0}},
+		{"later provider holds it", func() []*staticSecrets {
+			return []*staticSecrets{{}, {secrets: map[string]string{"key": "second"}}}
+		}, "second", nil, []int{1, 1}},
+		{"no provider holds it", func() []*staticSecrets {
+			return []*staticSecrets{{}, {}}
+		}, "", ErrSecretNotFound, []int{1, 1}},
+		{"failing provider stops the chain", func() []*staticSecrets {
+			return []*staticSecrets{{err: errVault}, {secrets: map[string]string{"key": "second"}}}
+		}, "", errVault, []int{1, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providers := test.chain()
+			var chain ChainSecrets
+			for _, provider := range providers {
+				chain = append(chain, provider)
+			}
+			secret, err := chain.Secret(context.Background(), "key")
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("error %v, want %v", err, test.err)
+				}
+			} else if err != nil || secret.Reveal() != test.want {
+				t.Fatalf("got %q, %v, want %q", secret.Reveal(), err, test.want)
+			}
+			for i, provider := range providers {
+				if provider.calls != test.calls[i] {
+					t.Fatalf("provider %d looked up %d times, want %d", i, provider.calls, test.calls[i])
+				}
+			}
+		})
+	}
+
+	t.Setenv("CHAIN_TEST_KEY", "from-env")
+	chain := ChainSecrets{&staticSecrets{}, EnvSecrets{}}
+	if secret, err := chain.Secret(context.Background(), "chain-test/key"); err != nil || secret.Reveal() != "from-env" {
+		t.Fatalf("environment fallback: %q, %v", secret.Reveal(), err)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	provider := Secrets
+	t.Cleanup(func() { Secrets = provider })
+	Secrets = &staticSecrets{secrets: map[string]string{"jwt": "redact-test-token", "pin": "abc"}}
+
+	if _, err := lookupSecret(context.Background(), "jwt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lookupSecret(context.Background(), "pin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lookupSecret(context.Background(), "missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("error %v, want %v", err, ErrSecretNotFound)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"resolved secret", "Authorization: Bearer redact-test-token", "Authorization: Bearer [REDACTED]"},
+		{"repeated", "redact-test-token/redact-test-token", "[REDACTED]/[REDACTED]"},
+		{"too short to redact", "abc abcd", "abc abcd"},
+		{"no secret", "request failed", "request failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := RedactSecrets(test.text); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// Secrets do not print their value
+	secret := Secret("printed-token")
+	data, _ := json.Marshal(struct{ Token Secret }{secret})
+	for _, text := range []string{fmt.Sprint(secret), fmt.Sprintf("%v %s %#v", secret, secret, secret), string(data)} {
+		if strings.Contains(text, "printed-token") {
+			t.Fatalf("secret printed in %s", text)
+		}
+	}
+}
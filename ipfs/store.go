@@ -0,0 +1,607 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================Content Store========================
+
+// A service content is fetched from and published to
+type Backend interface {
+	Name() string
+	Fetch(ctx context.Context, cid string) (io.ReadCloser, error)
+	// Uploads and pins a single file, returning the CID the backend assigned
+	UploadFile(ctx context.Context, name string, r io.Reader) (string, error)
+	// Uploads and pins a directory tree, returning the CID of its root
+	UploadDirectory(ctx context.Context, dir string) (string, error)
+}
+
+// Returned by backends that can only fetch content
+var ErrReadOnly = errors.New("backend is read-only")
+
+// Fetches and publishes content through a backend, checking CIDs locally
+type Store struct {
+	Backend Backend
+}
+
+// Gateway content is fetched from when IPFS_GATEWAY is unset: Pinata's public gateway, which
+// is rate limited, so accounts with a dedicated gateway should set it instead
+const defaultGateway = "https://gateway.pinata.cloud/ipfs/"
+
+// Store used by the job pipeline, configured from the environment:
+// IPFS_BACKEND selects "pinata" (default), "kubo" or "gateway", IPFS_GATEWAY the gateway of
+// the pinata and gateway backends, defaultGateway by default, and IPFS_GATEWAYS
+// lists comma-separated fallback gateways, none by default, e.g.
+// "https://ipfs.io/ipfs/,https://dweb.link/ipfs/" to fall back to public gateways; the store
+// verifies what they serve. IPFS_RACE backends are queried at once; IPFS_FAILURE_THRESHOLD
+// consecutive failures pause a backend for IPFS_COOLDOWN.
+var DefaultStore = &Store{Backend: backendFromEnv()}
+
+func backendFromEnv() Backend {
+	backends := []Backend{primaryBackendFromEnv()}
+	for _, gateway := range strings.Split(getEnv("IPFS_GATEWAYS", ""), ",") {
+		gateway = strings.TrimSpace(gateway)
+		if gateway != "" && gateway != "none" {
+			backends = append(backends, &GatewayBackend{URL: gateway})
+		}
+	}
+	if len(backends) == 1 {
+		return backends[0]
+	}
+	return &MultiBackend{
+		Backends:         backends,
+		Race:             int(uintEnv("IPFS_RACE", 1)),
+		FailureThreshold: int(uintEnv("IPFS_FAILURE_THRESHOLD", 3)),
+		Cooldown:         DurationEnv("IPFS_COOLDOWN", time.Minute),
+	}
+}
+
+func primaryBackendFromEnv() Backend {
+	gateway := getEnv("IPFS_GATEWAY", defaultGateway)
+	switch os.Getenv("IPFS_BACKEND") {
+	case "kubo":
+		return &KuboBackend{API: getEnv("IPFS_API", "http://127.0.0.1:5001"), Auth: "kubo/token"}
+	case "gateway":
+		return &GatewayBackend{URL: gateway, Auth: "gateway/token"}
+	default:
+		return &PinataBackend{
+			GatewayBackend: GatewayBackend{URL: gateway, Auth: "pinata/gateway-token"},
+			API:            getEnv("PINATA_API", "https://api.pinata.cloud"),
+			JWT:            "pinata/jwt",
+		}
+	}
+}
+
+// Implemented by backends with several sources, which can retry content that failed verification.
+// The Store fetches a CID once at a time, so the sources recorded for it belong to one fetch.
+type sourceTracker interface {
+	Reject(cid string) bool // Excludes the sources that served cid, reporting whether any is left
+	Release(cid string)     // Forgets the sources of cid once a fetch of it is over, whatever its outcome
+}
+
+var (
+	cidMu    sync.Mutex
+	cidLocks = map[string]*sync.Mutex{}
+)
+
+// Returns the lock serializing fetches of a CID, so they do not share the partial file of a
+// download or the sources a sourceTracker records for the CID
+func cidLock(cid string) *sync.Mutex {
+	cidMu.Lock()
+	defer cidMu.Unlock()
+	lock, ok := cidLocks[cid]
+	if !ok {
+		lock = &sync.Mutex{}
+		cidLocks[cid] = lock
+	}
+	return lock
+}
+
+// Downloads the content with the given CID into memory; use Download for datasets
+func (s *Store) Get(ctx context.Context, cid string) ([]byte, error) {
+	lock := cidLock(cid)
+	lock.Lock()
+	defer lock.Unlock()
+	defer s.release(cid)
+	for {
+		data, err := s.get(ctx, cid)
+		if s.retry(cid, err) {
+			continue
+		}
+		return data, err
+	}
+}
+
+// Reports whether content that failed verification can be fetched from another source
+func (s *Store) retry(cid string, err error) bool {
+	tracker, ok := s.Backend.(sourceTracker)
+	if !ok {
+		return false
+	}
+	return errors.Is(err, ErrCIDMismatch) && tracker.Reject(cid)
+}
+
+func (s *Store) release(cid string) {
+	if tracker, ok := s.Backend.(sourceTracker); ok {
+		tracker.Release(cid)
+	}
+}
+
+func (s *Store) get(ctx context.Context, cid string) ([]byte, error) {
+	body, err := s.Backend.Fetch(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var r io.Reader = body
+	if MaxDownloadSize > 0 {
+		r = io.LimitReader(body, MaxDownloadSize+1)
+	}
+	hasher := NewFileHasher()
+	data, err := io.ReadAll(io.TeeReader(r, hasher))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file data: %w", err)
+	}
+	if MaxDownloadSize > 0 && int64(len(data)) > MaxDownloadSize {
+		return nil, fmt.Errorf("%s: %w", cid, ErrTooLarge)
+	}
+	if err := checkContent(cid, hasher.CID()); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Publishes data under the given file name and returns its CID
+func (s *Store) Put(ctx context.Context, name string, data []byte) (string, error) {
+	expected, err := ComputeCID(data)
+	if err != nil {
+		return "", err
+	}
+	cid, err := s.Backend.UploadFile(ctx, name, bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s to %s: %w", name, s.Backend.Name(), err)
+	}
+	if err := checkCID(s.Backend, expected, cid); err != nil {
+		return "", err
+	}
+	return expected, nil
+}
+
+// Publishes a file from disk and returns its CID
+func (s *Store) AddFile(ctx context.Context, path string) (string, error) {
+	expected, err := ComputeFileCID(path)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	cid, err := s.Backend.UploadFile(ctx, filepath.Base(path), file)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s to %s: %w", path, s.Backend.Name(), err)
+	}
+	if err := checkCID(s.Backend, expected, cid); err != nil {
+		return "", err
+	}
+	return expected, nil
+}
+
+// Publishes a directory tree and returns the CID of its root
+func (s *Store) AddDirectory(ctx context.Context, dir string) (string, error) {
+	expected, err := ComputeDirectoryCID(dir)
+	if err != nil {
+		return "", err
+	}
+	cid, err := s.Backend.UploadDirectory(ctx, dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s to %s: %w", dir, s.Backend.Name(), err)
+	}
+	if err := checkCID(s.Backend, expected, cid); err != nil {
+		return "", err
+	}
+	return expected, nil
+}
+
+// Publishes data through the default store
+func Put(ctx context.Context, name string, data []byte) (string, error) {
+	return DefaultStore.Put(ctx, name, data)
+}
+
+// Publishes a file through the default store
+func AddFile(ctx context.Context, path string) (string, error) {
+	return DefaultStore.AddFile(ctx, path)
+}
+
+// Publishes a directory through the default store
+func AddDirectory(ctx context.Context, dir string) (string, error) {
+	return DefaultStore.AddDirectory(ctx, dir)
+}
+
+// A backend chunking content differently would make the locally computed CID unreachable
+func checkCID(backend Backend, expected, actual string) error {
+	if actual != expected {
+		return fmt.Errorf("%s stored the content as %s, expected %s", backend.Name(), actual, expected)
+	}
+	return nil
+}
+
+// ========================Gateway========================
+
+// Fetches content from an HTTP gateway; it cannot publish
+type GatewayBackend struct {
+	URL  string // Gateway prefix, e.g. "https://ipfs.io/ipfs/"
+	Auth string // Name of the secret holding a bearer token; requests are anonymous when unset or not found
+}
+
+func (g *GatewayBackend) Name() string { return "gateway " + g.URL }
+
+func (g *GatewayBackend) Fetch(ctx context.Context, cid string) (io.ReadCloser, error) {
+	resp, err := g.do(ctx, "GET", cid, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to retrieve file, status code: %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (g *GatewayBackend) Size(ctx context.Context, cid string) (int64, error) {
+	resp, err := g.do(ctx, "HEAD", cid, "")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to retrieve file size, status code: %d", resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return 0, errors.New("gateway did not report the file size")
+	}
+	return resp.ContentLength, nil
+}
+
+func (g *GatewayBackend) FetchRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, err := g.do(ctx, "GET", cid, byteRange)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		resp.Body.Close()
+		return nil, ErrRangeUnsupported
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to retrieve file range, status code: %d", resp.StatusCode)
+	}
+}
+
+// Sends a request for cid to the gateway, optionally for a byte range
+func (g *GatewayBackend) do(ctx context.Context, method, cid, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, g.URL+cid, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	if err := authorize(ctx, req, g.Auth, false); err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s request: %w", method, err)
+	}
+	return resp, nil
+}
+
+func (g *GatewayBackend) UploadFile(ctx context.Context, name string, r io.Reader) (string, error) {
+	return "", ErrReadOnly
+}
+
+func (g *GatewayBackend) UploadDirectory(ctx context.Context, dir string) (string, error) {
+	return "", ErrReadOnly
+}
+
+// ========================Pinata========================
+
+// Publishes through the Pinata pinning API and fetches through a Pinata gateway
+type PinataBackend struct {
+	GatewayBackend
+	API string // Pinning API, e.g. "https://api.pinata.cloud"
+	JWT string // Name of the secret holding the API key used for uploads
+}
+
+func (p *PinataBackend) Name() string { return "pinata" }
+
+func (p *PinataBackend) UploadFile(ctx context.Context, name string, r io.Reader) (string, error) {
+	return p.pin(ctx, name, func(w *multipart.Writer) error {
+		return writeFilePart(w, "file", name, r)
+	})
+}
+
+func (p *PinataBackend) UploadDirectory(ctx context.Context, dir string) (string, error) {
+	root := filepath.Base(dir)
+	return p.pin(ctx, root, func(w *multipart.Writer) error {
+		return walkFiles(dir, func(rel string, file *os.File) error {
+			return writeFilePart(w, "file", root+"/"+rel, file)
+		})
+	})
+}
+
+// Uploads the parts written by files, pinned as CIDv1 under name
+func (p *PinataBackend) pin(ctx context.Context, name string, files func(w *multipart.Writer) error) (string, error) {
+	body := func(w *multipart.Writer) error {
+		if err := files(w); err != nil {
+			return err
+		}
+		metadata, _ := json.Marshal(map[string]string{"name": name})
+		if err := w.WriteField("pinataMetadata", string(metadata)); err != nil {
+			return err
+		}
+		return w.WriteField("pinataOptions", `{"cidVersion":1}`)
+	}
+
+	resp, err := postMultipart(ctx, p.API+"/pinning/pinFileToIPFS", p.JWT, true, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var pinned struct {
+		IpfsHash string `json:"IpfsHash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pinned); err != nil {
+		return "", fmt.Errorf("failed to parse pinning response: %w", err)
+	}
+	return pinned.IpfsHash, nil
+}
+
+// ========================Kubo========================
+
+// Publishes to and fetches from the HTTP RPC API of a Kubo (go-ipfs) node
+type KuboBackend struct {
+	API  string // RPC endpoint, e.g. "http://127.0.0.1:5001"
+	Auth string // Name of the secret holding a bearer token for nodes behind an authenticating proxy
+}
+
+func (k *KuboBackend) Name() string { return "kubo " + k.API }
+
+func (k *KuboBackend) Fetch(ctx context.Context, cid string) (io.ReadCloser, error) {
+	return k.FetchRange(ctx, cid, 0, -1)
+}
+
+func (k *KuboBackend) Size(ctx context.Context, cid string) (int64, error) {
+	resp, err := k.call(ctx, "files/stat", url.Values{"arg": {"/ipfs/" + cid}})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var stat struct {
+		Size int64  `json:"Size"`
+		Type string `json:"Type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stat); err != nil {
+		return 0, fmt.Errorf("failed to parse stat response: %w", err)
+	}
+	if stat.Type != "file" {
+		return 0, fmt.Errorf("%s is a %s, not a file", cid, stat.Type)
+	}
+	return stat.Size, nil
+}
+
+func (k *KuboBackend) FetchRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	params := url.Values{"arg": {cid}}
+	if offset > 0 {
+		params.Set("offset", strconv.FormatInt(offset, 10))
+	}
+	if length >= 0 {
+		params.Set("length", strconv.FormatInt(length, 10))
+	}
+	resp, err := k.call(ctx, "cat", params)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Calls an RPC command and checks the response status
+func (k *KuboBackend) call(ctx context.Context, command string, params url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", k.API+"/api/v0/"+command+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", command, err)
+	}
+	if err := authorize(ctx, req, k.Auth, false); err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s request: %w", command, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to retrieve file, status code: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (k *KuboBackend) UploadFile(ctx context.Context, name string, r io.Reader) (string, error) {
+	return k.add(ctx, func(w *multipart.Writer) error {
+		return writeFilePart(w, "file", url.QueryEscape(name), r)
+	})
+}
+
+// Kubo expects query-escaped paths and an explicit part for every directory
+func (k *KuboBackend) UploadDirectory(ctx context.Context, dir string) (string, error) {
+	root := filepath.Base(dir)
+	return k.add(ctx, func(w *multipart.Writer) error {
+		if err := writeDirectoryPart(w, url.QueryEscape(root)); err != nil {
+			return err
+		}
+		return fs.WalkDir(os.DirFS(dir), ".", func(rel string, entry fs.DirEntry, err error) error {
+			if err != nil || rel == "." {
+				return err
+			}
+			if entry.IsDir() {
+				return writeDirectoryPart(w, url.QueryEscape(root+"/"+rel))
+			}
+			file, err := os.Open(filepath.Join(dir, rel))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return writeFilePart(w, "file", url.QueryEscape(root+"/"+rel), file)
+		})
+	})
+}
+
+// Adds and pins the parts written by parts, returning the CID of the last (root) entry
+func (k *KuboBackend) add(ctx context.Context, parts func(w *multipart.Writer) error) (string, error) {
+	endpoint := k.API + "/api/v0/add?cid-version=1&raw-leaves=true&pin=true"
+	resp, err := postMultipart(ctx, endpoint, k.Auth, false, parts)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var root string
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		var added struct {
+			Hash string `json:"Hash"`
+		}
+		if err := decoder.Decode(&added); err != nil {
+			return "", fmt.Errorf("failed to parse add response: %w", err)
+		}
+		root = added.Hash
+	}
+	if root == "" {
+		return "", errors.New("node did not return a CID")
+	}
+	return root, nil
+}
+
+// ========================Multipart Helpers========================
+
+// Streams a multipart body built by write to the endpoint and checks the response status
+func postMultipart(ctx context.Context, endpoint, auth string, required bool, write func(w *multipart.Writer) error) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload request: %w", err)
+	}
+	if err := authorize(ctx, req, auth, required); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		err := write(writer)
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req.Body = pr
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send upload request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("upload failed, status code: %d: %s", resp.StatusCode, RedactSecrets(strings.TrimSpace(string(message))))
+	}
+	return resp, nil
+}
+
+// Adds the bearer token held by the named secret to a request. A missing secret is an
+// error only when required; otherwise the request is sent anonymously.
+func authorize(ctx context.Context, req *http.Request, name string, required bool) error {
+	if name == "" {
+		if required {
+			return errors.New("no credentials configured")
+		}
+		return nil
+	}
+	secret, err := lookupSecret(ctx, name)
+	if errors.Is(err, ErrSecretNotFound) && !required {
+		return nil
+	}
+	if errors.Is(err, ErrSecretNotFound) {
+		return fmt.Errorf("failed to resolve credentials: %w (set %s or configure a secrets provider)", err, SecretEnvName(name))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve credentials: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+secret.Reveal())
+	return nil
+}
+
+func writeFilePart(w *multipart.Writer, field, filename string, r io.Reader) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filename))
+	header.Set("Content-Type", "application/octet-stream")
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, r)
+	return err
+}
+
+func writeDirectoryPart(w *multipart.Writer, filename string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	header.Set("Content-Type", "application/x-directory")
+	_, err := w.CreatePart(header)
+	return err
+}
+
+// Calls fn for every regular file below dir with its slash-separated relative path
+func walkFiles(dir string, fn func(rel string, file *os.File) error) error {
+	return fs.WalkDir(os.DirFS(dir), ".", func(rel string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		file, err := os.Open(filepath.Join(dir, rel))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return fn(rel, file)
+	})
+}
@@ -0,0 +1,298 @@
+package ipfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Python interpreter used to create the per-job virtual environments
+var PythonInterpreter = getEnv("PYTHON_INTERPRETER", "python")
+
+// Directory under which virtual environments are cached, one per requirements CID
+var EnvCacheDir = getEnv("ENV_CACHE_DIR", filepath.Join(os.TempDir(), "algorithm-envs"))
+
+// Optional local wheelhouse; when set, requirements are installed from it without touching the network
+var Wheelhouse = os.Getenv("PIP_WHEELHOUSE")
+
+// Environments that have not been used for this long are removed by the collector
+var EnvMaxIdle = DurationEnv("ENV_MAX_IDLE", 24*time.Hour)
+
+var envCollectInterval = 10 * time.Minute // Interval between cache collections
+
+// Marker file written once an environment has been fully created
+const envReadyMarker = ".ready"
+
+// A cached environment with the dependencies of a job installed
+// (a Python virtual environment, an R package library, ...)
+type Environment struct {
+	Key         string // Cache key derived from the dependency manifest CID and interpreter version
+	Dir         string // Root directory of the environment
+	Interpreter string // Interpreter that runs jobs inside the environment
+}
+
+var (
+	envMu    sync.Mutex
+	envLocks = map[string]*sync.Mutex{} // Serializes creation of the same environment
+	envUsers = map[string]int{}         // Number of jobs currently using each environment
+)
+
+var (
+	pythonVersionOnce sync.Once
+	pythonVersion     string
+	pythonVersionErr  error
+)
+
+// Returns the version of the configured Python interpreter (e.g. "3.11.4")
+func PythonVersion() (string, error) {
+	pythonVersionOnce.Do(func() {
+		out, err := exec.Command(PythonInterpreter, "-c", "import platform; print(platform.python_version())").Output()
+		if err != nil {
+			pythonVersionErr = fmt.Errorf("failed to query Python version: %w", err)
+			return
+		}
+		pythonVersion = strings.TrimSpace(string(out))
+	})
+	return pythonVersion, pythonVersionErr
+}
+
+// Computes the cache key for a requirements CID under the given interpreter version
+func EnvironmentKey(requirementsCID, version string) string {
+	return environmentKey("py", requirementsCID, version)
+}
+
+// Computes the cache key of an environment; prefix names the runtime it belongs to
+func environmentKey(prefix, manifestCID, version string) string {
+	sum := sha256.Sum256([]byte(manifestCID + "|" + version))
+	return prefix + version + "-" + hex.EncodeToString(sum[:8])
+}
+
+// Returns a ready environment for the requirements CID, creating it on first use.
+// Callers must call Release once the job no longer needs the environment.
+func EnsureEnvironment(ctx context.Context, requirementsCID string) (*Environment, error) {
+	version, err := PythonVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	key := EnvironmentKey(requirementsCID, version)
+	env := &Environment{Key: key, Dir: filepath.Join(EnvCacheDir, key)}
+	env.Interpreter = venvPython(env.Dir)
+
+	err = ensureCached(ctx, env, requirementsCID, func(ctx context.Context) error {
+		return createEnvironment(ctx, env, requirementsCID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Marks env as used and builds it with create unless a previous job already did.
+// manifestCID is the dependency manifest the environment is built from.
+func ensureCached(ctx context.Context, env *Environment, manifestCID string, create func(ctx context.Context) error) error {
+	lock := envLock(env.Key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	acquireEnvironment(env.Key)
+
+	marker := filepath.Join(env.Dir, envReadyMarker)
+	if _, err := os.Stat(marker); err == nil {
+		fmt.Printf("Reusing cached environment %s\n", env.Key)
+		now := time.Now()
+		os.Chtimes(marker, now, now)
+		return nil
+	}
+
+	// Remove leftovers of an interrupted creation
+	if err := os.RemoveAll(env.Dir); err != nil {
+		env.Release()
+		return fmt.Errorf("failed to clear environment directory: %w", err)
+	}
+	if err := os.MkdirAll(EnvCacheDir, 0755); err != nil {
+		env.Release()
+		return fmt.Errorf("failed to create environment cache: %w", err)
+	}
+
+	if err := create(ctx); err != nil {
+		env.Release()
+		os.RemoveAll(env.Dir)
+		return err
+	}
+
+	if err := os.WriteFile(marker, []byte(manifestCID+"\n"), 0644); err != nil {
+		env.Release()
+		return fmt.Errorf("failed to mark environment ready: %w", err)
+	}
+	return nil
+}
+
+// Marks the environment as no longer used by the calling job
+func (env *Environment) Release() {
+	envMu.Lock()
+	defer envMu.Unlock()
+
+	if envUsers[env.Key] <= 1 {
+		delete(envUsers, env.Key)
+		return
+	}
+	envUsers[env.Key]--
+}
+
+// Creates the virtual environment and installs the requirements into it
+func createEnvironment(ctx context.Context, env *Environment, requirementsCID string) error {
+	fmt.Printf("Creating environment %s for requirements (CID: %s)\n", env.Key, requirementsCID)
+
+	cmd := exec.CommandContext(ctx, PythonInterpreter, "-m", "venv", env.Dir)
+	killProcessTreeOnCancel(cmd)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create virtual environment: %w, output: %s", err, out)
+	}
+
+	requirementsData, err := DownloadFile(ctx, requirementsCID)
+	if err != nil {
+		return fmt.Errorf("error downloading requirements file: %w", err)
+	}
+	requirementsFile := filepath.Join(env.Dir, "requirements.txt")
+	if err := WriteFile(requirementsFile, requirementsData); err != nil {
+		return fmt.Errorf("error saving requirements file: %w", err)
+	}
+
+	fmt.Println("Installing Python requirements...")
+	if err := InstallRequirements(ctx, env.Interpreter, requirementsFile); err != nil {
+		return err
+	}
+	fmt.Println("Python requirements installed successfully.")
+	return nil
+}
+
+// Removes cached environments that are not in use and have been idle for longer than maxIdle
+func CollectEnvironments(maxIdle time.Duration) ([]string, error) {
+	return collectEnvironments(func(dir string) bool { return environmentIdle(dir, maxIdle) })
+}
+
+// Removes cached environments that are not in use and were built from one of the given
+// dependency manifests, e.g. those only referenced by pruned blocks
+func EvictEnvironments(manifestCIDs map[string]bool) ([]string, error) {
+	return collectEnvironments(func(dir string) bool {
+		data, err := os.ReadFile(filepath.Join(dir, envReadyMarker))
+		return err == nil && manifestCIDs[strings.TrimSpace(string(data))]
+	})
+}
+
+// Removes the environments not in use for which remove returns true, given their directory
+func collectEnvironments(remove func(dir string) bool) ([]string, error) {
+	entries, err := os.ReadDir(EnvCacheDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read environment cache: %w", err)
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		key := entry.Name()
+
+		lock := envLock(key)
+		if !lock.TryLock() {
+			continue // Environment is being created
+		}
+		if environmentInUse(key) || !remove(filepath.Join(EnvCacheDir, key)) {
+			lock.Unlock()
+			continue
+		}
+		err := os.RemoveAll(filepath.Join(EnvCacheDir, key))
+		lock.Unlock()
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove environment %s: %w", key, err)
+		}
+		removed = append(removed, key)
+	}
+	return removed, nil
+}
+
+// Periodically garbage-collects idle environments
+func CheckEnvironmentCache() {
+	for {
+		time.Sleep(envCollectInterval)
+
+		removed, err := CollectEnvironments(EnvMaxIdle)
+		if err != nil {
+			fmt.Println("Error collecting environments:", err)
+		}
+		for _, key := range removed {
+			fmt.Println("Removed idle environment:", key)
+		}
+
+		removed, err = CollectPartialDownloads(EnvMaxIdle)
+		if err != nil {
+			fmt.Println("Error collecting partial downloads:", err)
+		}
+		for _, name := range removed {
+			fmt.Println("Removed abandoned partial download:", name)
+		}
+	}
+}
+
+// Reports whether the environment was last used more than maxIdle ago.
+// Environments without a ready marker are leftovers and always count as idle.
+func environmentIdle(dir string, maxIdle time.Duration) bool {
+	info, err := os.Stat(filepath.Join(dir, envReadyMarker))
+	if err != nil {
+		return true
+	}
+	return time.Since(info.ModTime()) > maxIdle
+}
+
+func envLock(key string) *sync.Mutex {
+	envMu.Lock()
+	defer envMu.Unlock()
+
+	lock, ok := envLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		envLocks[key] = lock
+	}
+	return lock
+}
+
+func acquireEnvironment(key string) {
+	envMu.Lock()
+	defer envMu.Unlock()
+	envUsers[key]++
+}
+
+func environmentInUse(key string) bool {
+	envMu.Lock()
+	defer envMu.Unlock()
+	return envUsers[key] > 0
+}
+
+// Location of the interpreter inside a virtual environment
+func venvPython(dir string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(dir, "Scripts", "python.exe")
+	}
+	return filepath.Join(dir, "bin", "python")
+}
+
+// Returns the value of an environment variable or a fallback when unset
+func getEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,184 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Points the environment cache to a temporary directory for a test
+func setEnvCacheDir(t *testing.T) {
+	t.Helper()
+	dir := EnvCacheDir
+	t.Cleanup(func() { EnvCacheDir = dir })
+	EnvCacheDir = t.TempDir()
+}
+
+func testEnvironment(key string) *Environment {
+	return &Environment{Key: key, Dir: filepath.Join(EnvCacheDir, key)}
+}
+
+// Builds env with a create function that writes one file and counts its calls
+func ensureTestEnvironment(t *testing.T, env *Environment, manifestCID string, created *int) error {
+	t.Helper()
+	return ensureCached(context.Background(), env, manifestCID, func(ctx context.Context) error {
+		*created++
+		if err := os.MkdirAll(env.Dir, 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(env.Dir, "installed"), nil, 0644)
+	})
+}
+
+func TestEnsureCached(t *testing.T) {
+	setEnvCacheDir(t)
+	env := testEnvironment("ensure-cached")
+	created := 0
+
+	if err := ensureTestEnvironment(t, env, "manifest-cid", &created); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(env.Dir, envReadyMarker)
+	data, err := os.ReadFile(marker)
+	if err != nil || strings.TrimSpace(string(data)) != "manifest-cid" {
+		t.Fatalf("ready marker holds %q, %v", data, err)
+	}
+
+	// A ready environment is reused, and its marker touched so it does not look idle
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(marker, old, old)
+	if err := ensureTestEnvironment(t, env, "manifest-cid", &created); err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 {
+		t.Fatalf("environment created %d times, want 1", created)
+	}
+	if info, _ := os.Stat(marker); !info.ModTime().After(old) {
+		t.Fatal("ready marker not touched on reuse")
+	}
+	env.Release()
+	env.Release()
+
+	// An environment without a marker is a leftover of an interrupted creation and is rebuilt
+	leftover := testEnvironment("ensure-cached-leftover")
+	os.MkdirAll(leftover.Dir, 0755)
+	os.WriteFile(filepath.Join(leftover.Dir, "partial"), nil, 0644)
+	if err := ensureTestEnvironment(t, leftover, "manifest-cid", &created); err != nil {
+		t.Fatal(err)
+	}
+	if created != 2 {
+		t.Fatal("leftover environment not rebuilt")
+	}
+	if _, err := os.Stat(filepath.Join(leftover.Dir, "partial")); !os.IsNotExist(err) {
+		t.Fatalf("leftover files kept: %v", err)
+	}
+	leftover.Release()
+}
+
+func TestEnsureCachedFailure(t *testing.T) {
+	setEnvCacheDir(t)
+	env := testEnvironment("ensure-cached-failure")
+	errInstall := errors.New("install failed")
+
+	err := ensureCached(context.Background(), env, "manifest-cid", func(ctx context.Context) error {
+		os.MkdirAll(env.Dir, 0755)
+		return errInstall
+	})
+	if !errors.Is(err, errInstall) {
+		t.Fatalf("error %v, want %v", err, errInstall)
+	}
+	if _, err := os.Stat(env.Dir); !os.IsNotExist(err) {
+		t.Fatalf("failed environment kept: %v", err)
+	}
+	if environmentInUse(env.Key) {
+		t.Fatal("failed environment still counted as in use")
+	}
+}
+
+func TestEnvironmentRelease(t *testing.T) {
+	setEnvCacheDir(t)
+	env := testEnvironment("release")
+	created := 0
+	for i := 0; i < 2; i++ {
+		if err := ensureTestEnvironment(t, env, "manifest-cid", &created); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env.Release()
+	if !environmentInUse(env.Key) {
+		t.Fatal("environment released while a job still uses it")
+	}
+	if removed, _ := CollectEnvironments(0); len(removed) != 0 {
+		t.Fatalf("environment in use collected: %v", removed)
+	}
+
+	env.Release()
+	if environmentInUse(env.Key) {
+		t.Fatal("environment in use after every job released it")
+	}
+	env.Release() // Releasing more than acquired is harmless
+	if environmentInUse(env.Key) {
+		t.Fatal("environment in use after an extra release")
+	}
+	if removed, _ := CollectEnvironments(0); len(removed) != 1 {
+		t.Fatalf("released environment not collected: %v", removed)
+	}
+}
+
+func TestCollectEnvironments(t *testing.T) {
+	setEnvCacheDir(t)
+	created := 0
+	build := func(key, manifestCID string, idle time.Duration) *Environment {
+		t.Helper()
+		env := testEnvironment(key)
+		if err := ensureTestEnvironment(t, env, manifestCID, &created); err != nil {
+			t.Fatal(err)
+		}
+		env.Release()
+		used := time.Now().Add(-idle)
+		os.Chtimes(filepath.Join(env.Dir, envReadyMarker), used, used)
+		return env
+	}
+	build("collect-recent", "recent-cid", time.Minute)
+	build("collect-idle", "idle-cid", 2*time.Hour)
+	inUse := build("collect-in-use", "idle-cid", 2*time.Hour)
+	acquireEnvironment(inUse.Key)
+	defer inUse.Release()
+	os.MkdirAll(filepath.Join(EnvCacheDir, "collect-leftover"), 0755)
+	os.WriteFile(filepath.Join(EnvCacheDir, "not-an-environment"), nil, 0644)
+
+	removed, err := CollectEnvironments(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	if strings.Join(removed, ",") != "collect-idle,collect-leftover" {
+		t.Fatalf("collected %v, want the idle environment and the leftover", removed)
+	}
+
+	// Eviction by dependency manifest ignores idleness but spares environments in use
+	build("evict-recent", "pruned-cid", 0)
+	build("evict-other", "kept-cid", 0)
+	removed, err = EvictEnvironments(map[string]bool{"pruned-cid": true, "idle-cid": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(removed, ",") != "evict-recent" {
+		t.Fatalf("evicted %v, want the environment built from the pruned manifest", removed)
+	}
+
+	entries, _ := os.ReadDir(EnvCacheDir)
+	var kept []string
+	for _, entry := range entries {
+		kept = append(kept, entry.Name())
+	}
+	if strings.Join(kept, ",") != "collect-in-use,collect-recent,evict-other,not-an-environment" {
+		t.Fatalf("cache holds %v afterwards", kept)
+	}
+}
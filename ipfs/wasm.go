@@ -0,0 +1,139 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// ========================WebAssembly Execution========================
+
+// Returned when a module uses up the fuel allotted by the job
+var ErrOutOfFuel = errors.New("wasm module ran out of fuel")
+
+// Size of a WebAssembly memory page
+const wasmPageSize = 64 * 1024
+
+// Executes a WASI module and captures its standard output.
+// The module sees the workspace as its root directory; args are paths relative to it.
+// Fuel is charged one unit per function call, so metering is identical on every miner.
+// Instructions are not metered: a loop that makes no calls is only stopped by the run
+// deadline of the stage.
+func RunWasmAlgorithm(ctx context.Context, moduleFile string, args []string, seed int64, limits Limits) (string, error) {
+	if err := limits.Validate(); err != nil {
+		return "", err
+	}
+	moduleData, err := os.ReadFile(moduleFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read wasm module: %w", err)
+	}
+	workDir := filepath.Dir(moduleFile)
+
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if limits.Memory > 0 {
+		pages := (uint64(limits.Memory)*1024*1024 + wasmPageSize - 1) / wasmPageSize
+		config = config.WithMemoryLimitPages(uint32(pages))
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Meter execution by counting guest function calls
+	var exhausted atomic.Bool
+	if limits.Fuel > 0 {
+		remaining := int64(limits.Fuel)
+		runCtx = experimental.WithFunctionListenerFactory(runCtx, experimental.FunctionListenerFactoryFunc(
+			func(api.FunctionDefinition) experimental.FunctionListener {
+				return experimental.FunctionListenerFunc(func(context.Context, api.Module, api.FunctionDefinition, []uint64, experimental.StackIterator) {
+					if atomic.AddInt64(&remaining, -1) < 0 && !exhausted.Swap(true) {
+						cancel()
+					}
+				})
+			}))
+	}
+
+	r := wazero.NewRuntimeWithConfig(runCtx, config)
+	defer r.Close(context.Background())
+	wasi_snapshot_preview1.MustInstantiate(runCtx, r)
+
+	compiled, err := r.CompileModule(runCtx, moduleData)
+	if err != nil {
+		return "", fmt.Errorf("failed to compile wasm module: %w", err)
+	}
+
+	var out bytes.Buffer
+	var stderr bytes.Buffer
+	moduleConfig := wazero.NewModuleConfig().
+		WithName("algorithm").
+		WithArgs(append([]string{filepath.Base(moduleFile)}, args...)...).
+		WithStdout(&out).
+		WithStderr(&stderr).
+		WithRandSource(rand.New(rand.NewSource(seed))).
+		WithFSConfig(wazero.NewFSConfig().WithDirMount(workDir, "/"))
+	for _, variable := range deterministicEnv("/", seed) {
+		if key, value, ok := strings.Cut(variable, "="); ok && key != "PATH" {
+			moduleConfig = moduleConfig.WithEnv(key, value)
+		}
+	}
+
+	_, err = r.InstantiateModule(runCtx, compiled, moduleConfig)
+	if exhausted.Load() {
+		return "", ErrOutOfFuel
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to run wasm module: %w, stderr: %s", err, stderr.String())
+	}
+	return out.String(), nil
+}
+
+// Fingerprint of the embedded runtime; WASI execution does not depend on the host platform
+func WasmFingerprint(seed int64) Fingerprint {
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/tetratelabs/wazero" {
+				version = dep.Version
+			}
+		}
+	}
+	return Fingerprint{
+		Runtime:  RuntimeWASM,
+		Version:  "wazero " + version,
+		Platform: "wasi_snapshot_preview1",
+		Seed:     seed,
+	}
+}
+
+// Runs WASI modules in the embedded runtime; modules are self-contained
+type wasmRuntime struct{}
+
+func (wasmRuntime) AlgorithmFile() string { return "algorithm.wasm" }
+
+func (wasmRuntime) Dependencies() []string { return []string{DependenciesNone} }
+
+func (wasmRuntime) Prepare(ctx context.Context, ws *Workspace) error { return nil }
+
+func (wasmRuntime) Run(ctx context.Context, ws *Workspace) (string, error) {
+	return RunWasmAlgorithm(ctx, ws.Algorithm, ws.Args, ws.Seed, ws.Limits)
+}
+
+func (wasmRuntime) Fingerprint(ctx context.Context, ws *Workspace) (Fingerprint, error) {
+	return WasmFingerprint(ws.Seed), nil
+}
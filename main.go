@@ -3,8 +3,12 @@ package main
 import (
 	"BlockchainProject/ipfs"
 	"BlockchainProject/p2p"
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"time"
 )
 
 func TestIPFS() {
@@ -20,8 +24,12 @@ func TestIPFS() {
 
 	// Step 1: Process the datasets and algorithm
 	fmt.Println("Starting processing...")
-	dataset := p2p.SelectRandomDatasetCID(datasetCIDs)
-	result, err := ipfs.InitializeAndProcess(dataset, algorithmCID, requirementsCID)
+	job := ipfs.Job{
+		DatasetCID:      p2p.SelectRandomDatasetCID(datasetCIDs),
+		AlgorithmCID:    algorithmCID,
+		RequirementsCID: requirementsCID,
+	}
+	result, _, err := ipfs.InitializeAndProcess(context.Background(), job)
 	if err != nil {
 		fmt.Printf("Error during initialization and processing: %v\n", err)
 		return
@@ -37,7 +45,12 @@ func TestIPFS() {
 
 	// Step 3: Verify the transaction
 	fmt.Println("Starting verification...")
-	isVerified, err := ipfs.VerifyTransaction(hash, result.Dataset, result.Algorithm, requirementsCID)
+	committed, err := ipfs.MarshalCanonical(result)
+	if err != nil {
+		fmt.Printf("Error encoding the output: %v\n", err)
+		return
+	}
+	isVerified, err := ipfs.VerifyTransaction(context.Background(), hash, committed, job)
 	if err != nil {
 		fmt.Printf("Error verifying the transaction: %v\n", err)
 		return
@@ -63,6 +76,97 @@ func TestComms() {
 	p2p.InitMessage()
 }
 
+// Flags of the generator overriding the values of a workload file
+type workloadFlags struct {
+	jobs     *string
+	schedule *string
+	rate     *float64
+	burst    *int
+	interval *time.Duration
+	maxJobs  *int
+	duration *time.Duration
+	seed     *int64
+}
+
+func newWorkloadFlags(flags *flag.FlagSet) workloadFlags {
+	return workloadFlags{
+		jobs:     flags.String("jobs", "", "workload file or directory of manifests"),
+		schedule: flags.String("schedule", "", "schedule: constant, poisson or burst"),
+		rate:     flags.Float64("rate", 0, "jobs per second of constant and poisson schedules"),
+		burst:    flags.Int("burst", 0, "jobs per burst"),
+		interval: flags.Duration("interval", 0, "time between bursts"),
+		maxJobs:  flags.Int("max", 0, "jobs to submit in total, 0 for no limit"),
+		duration: flags.Duration("duration", 0, "time to submit jobs for, 0 for no limit"),
+		seed:     flags.Int64("seed", 0, "seed of the scenario, random when 0"),
+	}
+}
+
+// Runs the generator on a workload file or directory of manifests, or on the demo workload.
+// Flags override the values of the workload file; the counts are printed when it ends.
+func RunGenerator(args []string) {
+	flags := flag.NewFlagSet("Gen", flag.ExitOnError)
+	options := newWorkloadFlags(flags)
+	flags.Usage = func() {
+		fmt.Println("Usage:", os.Args[0], "Gen [flags] <peer>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	for _, peer := range flags.Args() {
+		p2p.AddPeer(peer)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := runWorkload(ctx, flags, options); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// Serves the API of a generator and runs its workload
+func runWorkload(ctx context.Context, flags *flag.FlagSet, options workloadFlags) error {
+	workload := p2p.DefaultWorkload()
+	if *options.jobs != "" {
+		var err error
+		workload, err = p2p.LoadWorkload(ctx, *options.jobs)
+		if err != nil {
+			return fmt.Errorf("error loading workload: %w", err)
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "schedule":
+			workload.Schedule.Kind = *options.schedule
+		case "rate":
+			workload.Schedule.Rate = *options.rate
+		case "burst":
+			workload.Schedule.Burst = *options.burst
+		case "interval":
+			workload.Schedule.Interval = ipfs.Duration(*options.interval)
+		case "max":
+			workload.MaxJobs = *options.maxJobs
+		case "duration":
+			workload.Duration = ipfs.Duration(*options.duration)
+		case "seed":
+			workload.Seed = *options.seed
+		}
+	})
+	go serveAPI("generator")
+
+	report, err := p2p.RunWorkload(ctx, workload)
+	if err != nil {
+		return fmt.Errorf("error running workload: %w", err)
+	}
+	fmt.Println("Workload finished:", report)
+	return nil
+}
+
+// Serves the HTTP API of the node in the background
+func serveAPI(role string) {
+	if err := p2p.StartAPI(role); err != nil {
+		fmt.Println("Error serving API:", err)
+	}
+}
+
 // Main function to demonstrate the process
 func main() {
 
@@ -72,19 +176,28 @@ func main() {
 	//Testing Communication
 	//TestComms()
 
-	if len(os.Args) != 2 {
-		fmt.Println("Usage:", os.Args[0], "Gen/MINER")
+	if len(os.Args) < 2 {
+		printUsage()
+		return
+	}
+	if runCommand(os.Args[1:]) {
 		return
 	}
 
-	role := os.Args[1]
-	switch role {
+	// Commands of earlier versions
+	switch os.Args[1] {
 	case "Gen":
-		p2p.InitMessage()
+		RunGenerator(os.Args[2:])
 	case "MINER":
-		go p2p.Miner()
+		if err := p2p.CheckPruneDepth(p2p.PruneDepth); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+		go serveAPI("miner")
+		p2p.Miner()
 	default:
-		fmt.Println("Invalid role. Please use Gen or MINER.")
+		fmt.Println("Unknown command", os.Args[1])
+		printUsage()
+		os.Exit(2)
 	}
-
 }
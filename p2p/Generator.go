@@ -1,41 +1,61 @@
 package p2p
 
 import (
+	"BlockchainProject/ipfs"
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
-	"time"
 )
 
-// Function to initialize and send a message every 10 seconds
+// Function to submit the demo workload, a job every 10 seconds, until the program stops
 func InitMessage() {
-	// Mapping of datasets to their IPFS CIDs
-	datasetCIDs := map[string]string{
-		"dataset_1": "bafybeicvy4d3odjmys7shzw4cddp6hw2zuoifnbzkiwn6vdomr3wysjkee",
-		"dataset_2": "bafkreieqc5e3pzaksbsxo573bkeerfry6lm7qbkg44vsrt3sbix5254koy",
+	report, err := RunWorkload(context.Background(), DefaultWorkload())
+	if err != nil {
+		fmt.Println("Error running workload:", err)
+		return
 	}
+	fmt.Println("Workload finished:", report)
+}
 
-	algorithmCID := "bafkreib22cejsgdjwahqmnpdqfa5hpp6xrpxukeqcqdyn4liazg3i7noku"
-	requirementsCID := "bafkreigep2i5w2hw5ek4ufubj4ypdzvyzdhzco3wfpj4b25tjwlclgg5bu"
-
-	// Infinite loop to send messages every 10 seconds
-	for {
-		// Select a random dataset CID
-		randomDatasetCID := SelectRandomDatasetCID(datasetCIDs)
+// Function to send a job described by a manifest to as many miners as it asks for.
+// Returns the ID the job is tracked under in Jobs.
+func SubmitManifest(ctx context.Context, manifestCID string) (string, error) {
+	manifest, err := ipfs.LoadManifest(ctx, manifestCID)
+	if err != nil {
+		return "", err
+	}
 
-		// Construct the message
-		message := Message{
-			Type:         "TRANS",
-			Dataset:      randomDatasetCID,
-			Algo:         algorithmCID,
-			Requirements: requirementsCID,
-		}
+	message := Message{
+		Type:     "TRANS",
+		Manifest: manifestCID,
+		JobID:    NewJobID(),
+	}
 
-		SendDataHashToRandomPeer(message)
+	// Keys of encrypted inputs are released only to authorized peers along with the job
+	if cids := manifest.EncryptedInputs(); len(cids) > 0 {
+		return message.JobID, submitEncrypted(message, cids, manifest.Copies())
+	}
+	SendToRandomPeers(message, manifest.Copies())
+	return message.JobID, nil
+}
 
-		// Wait for 10 seconds before generating the next message
-		time.Sleep(10 * time.Second)
+// Function to send a job on a dataset and algorithm to copies random miners, 1 when copies is 0.
+// Returns the ID the job is tracked under in Jobs.
+func SubmitLegacyJob(dataset, algorithm, requirements string, spec *ipfs.JobSpec, copies int) string {
+	message := Message{
+		Type:         "TRANS",
+		Dataset:      dataset,
+		Algo:         algorithm,
+		Requirements: requirements,
+		Spec:         spec,
+		JobID:        NewJobID(),
+	}
+	if copies == 0 {
+		copies = 1
 	}
+	SendToRandomPeers(message, copies)
+	return message.JobID
 }
 
 // Function to randomly select a dataset CID from the map
@@ -50,7 +70,7 @@ func SelectRandomDatasetCID(datasetCIDs map[string]string) string {
 // Function to send the serialized message to a peer
 func SendToPeer(peerAddress, message string) {
 
-	address := fmt.Sprintf("%s:%d", peerAddress, 8080) //Add port to use along with address
+	address := net.JoinHostPort(peerAddress, "8080") //Add port to use along with address
 	conn, err := net.Dial("tcp", address)
 
 	if err != nil {
@@ -4,11 +4,13 @@ import (
 	"BlockchainProject/blockchain"
 	"BlockchainProject/ipfs"
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
 	"time"
 )
 
@@ -64,6 +66,9 @@ func Miner() {
 
 	fmt.Printf("Peer %s listening on port 8080...", peerAddr)
 
+	//Garbage-collect idle algorithm environments
+	go ipfs.CheckEnvironmentCache()
+
 	//Start checking for mining needs
 	go startMiningRoutine()
 	go listenForIncomingBlocks()
@@ -75,6 +80,10 @@ func Miner() {
 			fmt.Println("Error accepting connection:", err)
 			continue
 		}
+		if isBannedConn(conn) {
+			conn.Close()
+			continue
+		}
 		defer conn.Close()
 
 		// Handle the connection
@@ -82,30 +91,63 @@ func Miner() {
 	}
 }
 
-func handleGeneratorMessage(datasetCID, algoCID, requirementsCID string) error {
+func handleGeneratorMessage(job ipfs.Job, jobID string) error {
 
-	result, err := ipfs.InitializeAndProcess(datasetCID, algoCID, requirementsCID)
+	trackJob(jobID, JobUpdate{Status: JobExecuting})
+	result, fingerprint, outputCID, err := ipfs.ProcessAndPublish(context.Background(), job)
 	if err != nil {
-		return fmt.Errorf("Error running algorithm:", err)
+		return fmt.Errorf("Error running algorithm: %w", err)
 	}
 
 	resultHash, err := ipfs.HashOutput(result)
 	if err != nil {
-		return fmt.Errorf("Error hashing output:", err)
+		return fmt.Errorf("Error hashing output: %w", err)
 	}
 
 	fmt.Println("RESULT HASH: ", resultHash)
 
 	//Create transaction
 	trans := blockchain.Transaction{
-		DataHash:     datasetCID,
-		AlgoHash:     algoCID,
-		Requirements: requirementsCID,
+		DataHash:     job.DatasetCID,
+		AlgoHash:     job.AlgorithmCID,
+		Requirements: job.RequirementsCID,
 		Output:       resultHash,
+		OutputCID:    outputCID,
+		Manifest:     job.ManifestCID,
+		JobID:        jobID,
+	}
+
+	//Record the environment the result was computed in
+	trans.Environment, err = json.Marshal(fingerprint)
+	if err != nil {
+		return fmt.Errorf("Error encoding environment fingerprint: %w", err)
+	}
+
+	//Keep the job options so verifiers run the job the same way; manifests already carry them
+	if job.ManifestCID == "" && job.Spec != (ipfs.JobSpec{}) {
+		trans.Spec, err = json.Marshal(job.Spec)
+		if err != nil {
+			return fmt.Errorf("Error encoding job spec: %w", err)
+		}
+	}
+
+	//Results verified with tolerances are compared field by field, so ship them alongside the hash
+	if job.Spec.VerifyPolicy().Structured() {
+		trans.Result, err = ipfs.MarshalCanonical(result)
+		if err != nil {
+			return fmt.Errorf("Error encoding result: %w", err)
+		}
+	}
+
+	//Sign the transaction so the explorer credits this node with it
+	if id, err := NodeIdentity(); err == nil {
+		trans.SignMiner(id.SigningKey())
 	}
 
 	//Add the transaction to the mempool
 	mempool.AddTransaction(&trans)
+	Events.Publish(TopicTransaction, trans, jobID)
+	trackJob(jobID, JobUpdate{Status: JobInMempool, OutputCID: outputCID})
 
 	return nil
 }
@@ -126,6 +168,10 @@ func listenForIncomingBlocks() {
 			fmt.Println("Error accepting incoming block connection:", err)
 			continue
 		}
+		if isBannedConn(conn) {
+			conn.Close()
+			continue
+		}
 		defer conn.Close()
 
 		// Handle the incoming block
@@ -152,8 +198,10 @@ func handleIncomingBlock(conn net.Conn) {
 	// Stop all processing
 	stopAllProcessing()
 
-	// Verify the block
-	verified, err := VerifyBlock(&block)
+	// Verify the block, aborting if a competing block is accepted first
+	ctx := startVerification(&block)
+	verified, err := VerifyBlock(ctx, &block)
+	finishVerification(&block)
 	if err != nil {
 		fmt.Println("Error verifying block:", err)
 		return
@@ -161,7 +209,9 @@ func handleIncomingBlock(conn net.Conn) {
 
 	if verified {
 		fmt.Println("Block verified successfully. Adding block to ledger")
-		ledger.AddBlock(block.Transactions)
+		abortCompetingVerifications(&block)
+		checkFork(&block)
+		blockAdded(ledger.AddBlock(block.Transactions))
 		return
 	}
 
@@ -172,24 +222,87 @@ func handleIncomingBlock(conn net.Conn) {
 
 }
 
-func VerifyBlock(block *blockchain.Block) (bool, error) {
+func VerifyBlock(ctx context.Context, block *blockchain.Block) (bool, error) {
 	fmt.Println("Verifying block...")
 
 	// Verify each transaction in the block
 	for _, tx := range block.Transactions {
-		isVerified, err := ipfs.VerifyTransaction(tx.Output, tx.DataHash, tx.AlgoHash, tx.Requirements)
+		job, err := JobFromTransaction(ctx, tx)
+		if err != nil {
+			return false, fmt.Errorf("error reading transaction: %w", err)
+		}
+		if tx.Miner != "" && tx.VerifiedMiner() == "" {
+			return false, errors.New("transaction has an invalid miner signature")
+		}
+		isVerified, err := ipfs.VerifyTransaction(ctx, tx.Output, tx.Result, job)
 		if err != nil {
 			return false, fmt.Errorf("error verifying transaction: %w", err)
 		}
 		if !isVerified {
 			return false, errors.New("transaction verification failed")
 		}
+
+		// The published output must be the result the transaction commits to, with every
+		// declared output file downloadable and matching its committed hash
+		if tx.OutputCID != "" {
+			if err := verifyOutput(ctx, tx, job); err != nil {
+				return false, err
+			}
+		}
 	}
 
 	fmt.Println("Block verified successfully.")
 	return true, nil
 }
 
+// Fetches the output artifact of a transaction, its files into a scratch directory
+func verifyOutput(ctx context.Context, tx blockchain.Transaction, job ipfs.Job) error {
+	dir, err := os.MkdirTemp("", "verify-output-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	artifact, err := ipfs.FetchOutput(ctx, tx.OutputCID, job, dir)
+	if err != nil {
+		return fmt.Errorf("error fetching transaction output: %w", err)
+	}
+	if artifact.Hash != tx.Output {
+		return errors.New("published output does not match the transaction")
+	}
+	return nil
+}
+
+// JobFromTransaction rebuilds the job a transaction was computed from
+func JobFromTransaction(ctx context.Context, tx blockchain.Transaction) (ipfs.Job, error) {
+	if tx.Manifest != "" {
+		manifest, err := ipfs.LoadManifest(ctx, tx.Manifest)
+		if err != nil {
+			return ipfs.Job{}, err
+		}
+		job := manifest.Job(tx.Manifest)
+		if job.DatasetCID != tx.DataHash || job.AlgorithmCID != tx.AlgoHash || job.RequirementsCID != tx.Requirements {
+			return ipfs.Job{}, errors.New("transaction does not match its manifest")
+		}
+		return job, nil
+	}
+
+	job := ipfs.Job{
+		DatasetCID:      tx.DataHash,
+		AlgorithmCID:    tx.AlgoHash,
+		RequirementsCID: tx.Requirements,
+	}
+	if len(tx.Spec) > 0 {
+		if err := json.Unmarshal(tx.Spec, &job.Spec); err != nil {
+			return ipfs.Job{}, fmt.Errorf("invalid job spec: %w", err)
+		}
+	}
+	if err := job.Spec.Validate(); err != nil {
+		return ipfs.Job{}, fmt.Errorf("invalid job spec: %w", err)
+	}
+	return job, nil
+}
+
 func mineBlock(txs []blockchain.Transaction) {
 
 	// Create a new block with the transactions from the mempool (Mining is done within the NewBlock() function)
@@ -198,8 +311,11 @@ func mineBlock(txs []blockchain.Transaction) {
 	//Assign new prevHash to be used for the next block
 	prevHash = block.Hash
 
+	//Our block wins over any competing block still being verified
+	abortCompetingVerifications(block)
+
 	// Add the block to the ledger
-	ledger.AddBlock(block.Transactions)
+	blockAdded(ledger.AddBlock(block.Transactions))
 
 	fmt.Println("Block mined and added to ledger: Block Hash->", block.Hash)
 
@@ -262,20 +378,86 @@ func handleConnection(conn net.Conn) {
 				fmt.Println("Error unmarshaling message:", err)
 				continue
 			}
-			fmt.Println("Received message from peer:", message)
+			fmt.Println("Received message from peer:", message.Type)
+
+			switch message.Type {
+			case "IDENTIFY":
+				if err := replyIdentity(conn); err != nil {
+					fmt.Println("Error sending identity:", err)
+				}
+				continue
+			case "KEYS":
+				if err := storeReleasedKeys(message.Keys); err != nil {
+					fmt.Println("Error storing released keys:", err)
+				}
+				continue
+			case "WATCH":
+				go streamJob(conn, message.JobID)
+				continue
+			case "RESULT":
+				if err := replyResult(conn, message); err != nil {
+					fmt.Println("Error sending job result:", err)
+				}
+				continue
+			}
+			//Report the progress of tracked jobs on the connection they were submitted on
+			if message.JobID != "" {
+				trackJob(message.JobID, JobUpdate{Status: JobReceived})
+				go streamJob(conn, message.JobID)
+			}
+
+			if err := storeReleasedKeys(message.Keys); err != nil {
+				fmt.Println("Rejecting job, released keys are unusable:", err)
+				trackJob(message.JobID, JobUpdate{Status: JobFailed, Error: "released keys are unusable: " + err.Error()})
+				continue
+			}
 
 			//BroadcastMessage(message, peerAddr) //==============================================TODO
 
 			//Extract data from message
-			datasetCID := message.Dataset.(string)
-			algoCID := message.Algo.(string)
-			requirementsCID := message.Requirements.(string)
+			job, err := jobFromMessage(message)
+			if err != nil {
+				fmt.Println("Rejecting job:", err)
+				trackJob(message.JobID, JobUpdate{Status: JobFailed, Error: err.Error()})
+				continue
+			}
 
 			//Handles the message sent by Generator peer on port 8080
-			err = handleGeneratorMessage(datasetCID, algoCID, requirementsCID)
+			err = handleGeneratorMessage(job, message.JobID)
 			if err != nil {
 				fmt.Println("Error handling generator message:", err)
+				trackJob(message.JobID, JobUpdate{Status: JobFailed, Error: err.Error()})
 			}
 		}
 	}
 }
+
+// Builds the job a generator message describes, loading its manifest if it references one
+func jobFromMessage(message Message) (ipfs.Job, error) {
+	if message.Manifest != "" {
+		manifest, err := ipfs.LoadManifest(context.Background(), message.Manifest)
+		if err != nil {
+			return ipfs.Job{}, err
+		}
+		return manifest.Job(message.Manifest), nil
+	}
+
+	dataset, _ := message.Dataset.(string)
+	algo, _ := message.Algo.(string)
+	requirements, _ := message.Requirements.(string)
+	if dataset == "" || algo == "" {
+		return ipfs.Job{}, errors.New("message has no dataset or algorithm")
+	}
+	job := ipfs.Job{
+		DatasetCID:      dataset,
+		AlgorithmCID:    algo,
+		RequirementsCID: requirements,
+	}
+	if message.Spec != nil {
+		job.Spec = *message.Spec
+	}
+	if err := job.Spec.Validate(); err != nil {
+		return ipfs.Job{}, fmt.Errorf("invalid spec: %w", err)
+	}
+	return job, nil
+}
@@ -0,0 +1,415 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"BlockchainProject/ipfs"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========================HTTP API========================
+
+// Every node serves a JSON API for dashboards and scripts on API_ADDR. Failed requests get
+// {"error": {"code": ..., "message": ...}} with the matching status code, and
+// GET /api/v1/schema returns the JSON Schemas of every request and response body.
+// Routes that administer the node or spend its miners' work, such as adding or banning peers
+// and submitting jobs, require the API_TOKEN as a bearer token, or come from this machine
+// when no token is set.
+
+// Address the API listens on
+var APIAddr = envOr("API_ADDR", ":8090")
+
+// Token administrative routes require; when unset they only accept requests from this machine
+var APIToken = envOr("API_TOKEN", "")
+
+// Version of the API, the prefix of its routes
+const APIVersion = "v1"
+
+//go:embed api.schema.json
+var apiSchema []byte
+
+// Largest request body accepted
+const maxRequestSize = 1 << 20
+
+// Body of failed requests
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type APIError struct {
+	Code    string `json:"code"` // Stable identifier of the error, e.g. "not_found"
+	Message string `json:"message"`
+}
+
+// Body of POST /api/v1/jobs: a manifest CID, or a dataset and algorithm
+type SubmitJobRequest struct {
+	Manifest     string        `json:"manifest,omitempty"`
+	Dataset      string        `json:"dataset,omitempty"`
+	Algorithm    string        `json:"algorithm,omitempty"`
+	Requirements string        `json:"requirements,omitempty"`
+	Spec         *ipfs.JobSpec `json:"spec,omitempty"`
+	Copies       int           `json:"copies,omitempty"` // Miners to send a legacy job to, 1 by default
+}
+
+type SubmitJobResponse struct {
+	JobID string `json:"jobId"`
+}
+
+type JobStatusResponse struct {
+	JobID   string      `json:"jobId"`
+	Status  JobStatus   `json:"status"` // Status of the latest update
+	Final   bool        `json:"final"`  // Whether the job is confirmed or failed for good
+	Updates []JobUpdate `json:"updates"`
+}
+
+// Transaction recording the published output of a job
+type JobResultResponse struct {
+	JobID       string                 `json:"jobId"`
+	Transaction blockchain.Transaction `json:"transaction"` // OutputCID holds the output, Output its hash
+}
+
+// A block with its height; transactions are encoded as on the chain
+type BlockResponse struct {
+	Height       int                      `json:"height"`
+	Hash         string                   `json:"hash"`
+	PrevHash     string                   `json:"prevHash"`
+	MerkleRoot   string                   `json:"merkleRoot"`
+	Nonce        int                      `json:"nonce"`
+	Transactions []blockchain.Transaction `json:"transactions"`
+}
+
+type MempoolResponse struct {
+	Count        int                      `json:"count"`
+	Transactions []blockchain.Transaction `json:"transactions"`
+}
+
+type PeersResponse struct {
+	Peers  []string `json:"peers"`
+	Banned []string `json:"banned"` // Peers refused until the node restarts
+}
+
+// Body of POST /api/v1/peers
+type AddPeerRequest struct {
+	Address string `json:"address"`
+}
+
+type NodeInfoResponse struct {
+	Address       string `json:"address"`
+	Role          string `json:"role"`
+	Light         bool   `json:"light"`              // Whether the node follows the chain by its headers only
+	Identity      string `json:"identity,omitempty"` // Public key other nodes authorize for dataset keys
+	APIVersion    string `json:"apiVersion"`
+	Height        int    `json:"height"`
+	Tip           string `json:"tip"`
+	Mempool       int    `json:"mempool"`
+	Peers         int    `json:"peers"`
+	Confirmations int    `json:"confirmations"`
+	PruneDepth    int    `json:"pruneDepth"`   // Blocks whose transactions the node keeps, 0 for an archive node
+	PrunedHeight  int    `json:"prunedHeight"` // Height of the latest block whose transactions were discarded, -1 if none
+}
+
+// Serves the API on APIAddr until it fails. role is reported by GET /api/v1/node.
+func StartAPI(role string) error {
+	server := &http.Server{
+		Addr:              APIAddr,
+		Handler:           NewAPIHandler(role),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Println("API listening on", APIAddr)
+	return server.ListenAndServe()
+}
+
+// Returns the handler of the API. On light nodes, StartLightClient must be called first.
+func NewAPIHandler(role string) http.Handler {
+	mux := http.NewServeMux()
+	route := newRouter(mux)
+	prefix := "/api/" + APIVersion
+	light := lightClient
+	route(http.MethodGet, prefix+"/node", func(w http.ResponseWriter, r *http.Request) { nodeInfo(w, role, light) })
+	route(http.MethodGet, prefix+"/peers", listPeers)
+	route(http.MethodPost, prefix+"/peers", admin(addPeer))
+	route(http.MethodPost, prefix+"/peers/{address}/ban", admin(banPeer))
+	route(http.MethodGet, prefix+"/mempool", listMempool)
+	route(http.MethodGet, prefix+"/headers", listHeaders(light))
+	route(http.MethodGet, prefix+"/checkpoints", listCheckpoints)
+	if light != nil {
+		lightRoutes(route, prefix, light)
+	} else {
+		route(http.MethodGet, prefix+"/chain/tip", chainTip)
+		route(http.MethodGet, prefix+"/blocks/{ref}", getBlock)
+		route(http.MethodGet, prefix+"/transactions/{id}", getTransaction)
+		route(http.MethodGet, prefix+"/transactions/{id}/proof", getTransactionProof)
+		route(http.MethodGet, prefix+"/datasets/{cid}/transactions", listTransactions("cid", ledger.TransactionsByDataset))
+		route(http.MethodGet, prefix+"/algorithms/{cid}/transactions", listTransactions("cid", ledger.TransactionsByAlgorithm))
+		route(http.MethodGet, prefix+"/outputs/{hash}/transactions", listTransactions("hash", ledger.TransactionsByOutput))
+		route(http.MethodGet, prefix+"/miners/{id}/blocks", listMinerBlocks)
+		route(http.MethodGet, prefix+"/snapshot", getSnapshot)
+		route(http.MethodGet, prefix+"/chain/export", exportChain)
+		route(http.MethodPost, prefix+"/chain/import", admin(importChain))
+	}
+	route(http.MethodPost, prefix+"/jobs", admin(submitJob))
+	route(http.MethodGet, prefix+"/jobs/{id}", jobStatus)
+	route(http.MethodGet, prefix+"/jobs/{id}/result", jobResult)
+	route(http.MethodGet, prefix+"/events", streamEvents)
+	route(http.MethodGet, prefix+"/schema", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write(apiSchema)
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "no such endpoint: "+r.URL.Path)
+	})
+	return mux
+}
+
+// Returns a function registering handlers by method and pattern. Each pattern answers the
+// methods it has no handler for with a JSON error.
+func newRouter(mux *http.ServeMux) func(method, pattern string, handler http.HandlerFunc) {
+	routes := map[string]map[string]http.HandlerFunc{}
+	return func(method, pattern string, handler http.HandlerFunc) {
+		handlers, ok := routes[pattern]
+		if !ok {
+			handlers = map[string]http.HandlerFunc{}
+			routes[pattern] = handlers
+			mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+				handler, ok := handlers[r.Method]
+				if !ok {
+					var allowed []string
+					for method := range handlers {
+						allowed = append(allowed, method)
+					}
+					sort.Strings(allowed)
+					w.Header().Set("Allow", strings.Join(allowed, ", "))
+					writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed, use "+strings.Join(allowed, " or "))
+					return
+				}
+				handler(w, r)
+			})
+		}
+		handlers[method] = handler
+	}
+}
+
+// Restricts a handler to callers presenting the API token, or to this machine without one
+func admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if APIToken != "" {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(APIToken)) != 1 {
+				writeError(w, http.StatusUnauthorized, "unauthorized", "this endpoint requires the API token as a bearer token")
+				return
+			}
+		} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err != nil || !net.ParseIP(host).IsLoopback() {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "this endpoint only accepts requests from the node's machine unless API_TOKEN is set")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func nodeInfo(w http.ResponseWriter, role string, light *LightClient) {
+	height, tip := chainTipHeader(light)
+	info := NodeInfoResponse{
+		Address:       peerAddr,
+		Role:          role,
+		Light:         light != nil,
+		APIVersion:    APIVersion,
+		Height:        height,
+		Tip:           tip,
+		Mempool:       len(mempool.GetTransactions()),
+		Peers:         len(GetPeers()),
+		Confirmations: Confirmations,
+		PruneDepth:    PruneDepth,
+		PrunedHeight:  -1,
+	}
+	if light == nil {
+		info.PrunedHeight = ledger.PrunedHeight()
+	}
+	if id, err := NodeIdentity(); err == nil {
+		info.Identity = id.PublicKey()
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func listPeers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, PeersResponse{Peers: GetPeers(), Banned: GetBannedPeers()})
+}
+
+func addPeer(w http.ResponseWriter, r *http.Request) {
+	var request AddPeerRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if request.Address == "" {
+		writeError(w, http.StatusBadRequest, "invalid_peer", "address is required")
+		return
+	}
+	if containsString(GetBannedPeers(), request.Address) {
+		writeError(w, http.StatusConflict, "peer_banned", request.Address+" is banned")
+		return
+	}
+	AddPeer(request.Address)
+	listPeers(w, r)
+}
+
+func banPeer(w http.ResponseWriter, r *http.Request) {
+	BanPeer(r.PathValue("address"))
+	listPeers(w, r)
+}
+
+func chainTip(w http.ResponseWriter, r *http.Request) {
+	height := ledger.Height()
+	block, _ := ledger.BlockAt(height)
+	writeJSON(w, http.StatusOK, blockResponse(block, height))
+}
+
+// Looks a block up by height when the reference is a number, by hash otherwise
+func getBlock(w http.ResponseWriter, r *http.Request) {
+	ref := r.PathValue("ref")
+	var block *blockchain.Block
+	height, err := strconv.Atoi(ref)
+	ok := false
+	if err == nil {
+		block, ok = ledger.BlockAt(height)
+	} else {
+		block, height, ok = ledger.BlockByHash(ref)
+	}
+	switch {
+	case ok && block.Pruned:
+		writePruned(w, "block "+block.Hash)
+	case ok:
+		writeJSON(w, http.StatusOK, blockResponse(block, height))
+	default:
+		writeError(w, http.StatusNotFound, "block_not_found", "no block with height or hash "+ref)
+	}
+}
+
+func blockResponse(block *blockchain.Block, height int) BlockResponse {
+	return BlockResponse{
+		Height:       height,
+		Hash:         block.Hash,
+		PrevHash:     block.PrevHash,
+		MerkleRoot:   block.MerkleRoot,
+		Nonce:        block.Nonce,
+		Transactions: block.Transactions,
+	}
+}
+
+func listMempool(w http.ResponseWriter, r *http.Request) {
+	pending := mempool.GetTransactions()
+	response := MempoolResponse{Count: len(pending), Transactions: make([]blockchain.Transaction, 0, len(pending))}
+	for _, tx := range pending {
+		response.Transactions = append(response.Transactions, *tx)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func submitJob(w http.ResponseWriter, r *http.Request) {
+	var request SubmitJobRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	switch {
+	case request.Manifest != "" && (request.Dataset != "" || request.Algorithm != ""):
+		writeError(w, http.StatusBadRequest, "invalid_job", "give either a manifest or a dataset and algorithm, not both")
+		return
+	case request.Manifest == "" && (request.Dataset == "" || request.Algorithm == ""):
+		writeError(w, http.StatusBadRequest, "invalid_job", "a manifest, or a dataset and an algorithm, is required")
+		return
+	case request.Copies < 0:
+		writeError(w, http.StatusBadRequest, "invalid_job", "copies cannot be negative")
+		return
+	}
+	if len(GetPeers()) == 0 {
+		writeError(w, http.StatusServiceUnavailable, "no_peers", "this node knows no miner to send the job to")
+		return
+	}
+
+	if request.Manifest == "" {
+		jobID := SubmitLegacyJob(request.Dataset, request.Algorithm, request.Requirements, request.Spec, request.Copies)
+		writeJSON(w, http.StatusAccepted, SubmitJobResponse{JobID: jobID})
+		return
+	}
+	jobID, err := SubmitManifest(r.Context(), request.Manifest)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "submission_failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, SubmitJobResponse{JobID: jobID})
+}
+
+func jobStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	updates, ok := Jobs.History(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job_not_found", "no job with ID "+id)
+		return
+	}
+	last := updates[len(updates)-1]
+	writeJSON(w, http.StatusOK, JobStatusResponse{
+		JobID:   id,
+		Status:  last.Status,
+		Final:   Jobs.Final(id),
+		Updates: updates,
+	})
+}
+
+// Answers with the transaction of a job from the ledger or mempool, or from the first peer
+// that knows it
+func jobResult(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	tx, ok := findJobTransaction(id)
+	for _, peer := range GetPeers() {
+		if ok {
+			break
+		}
+		var err error
+		tx, err = QueryJobResult(peer, id)
+		ok = err == nil
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "result_not_found", "no published result for job "+id)
+		return
+	}
+	writeJSON(w, http.StatusOK, JobResultResponse{JobID: id, Transaction: tx})
+}
+
+// Decodes a JSON request body, answering with an error if it is invalid
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("unexpected data after the JSON object")
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", err.Error())
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, APIErrorResponse{Error: APIError{Code: code, Message: message}})
+}
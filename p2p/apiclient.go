@@ -0,0 +1,317 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========================API Client========================
+
+// The command line drives a running node through its HTTP API. Failed requests return an
+// *APIError carrying the code and message of the node's error response.
+
+// API of the local node, used unless a command is given another
+var NodeAPI = envOr("NODE_API", "http://localhost:8090")
+
+type APIClient struct {
+	BaseURL string // e.g. http://localhost:8090
+	Token   string // Bearer token for administrative routes, API_TOKEN by default
+	HTTP    *http.Client
+}
+
+// An event received from GET /api/v1/events; Data is decoded according to the topic
+type ReceivedEvent struct {
+	ID    string          `json:"id"`
+	Topic string          `json:"topic"`
+	Time  time.Time       `json:"time"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func NewAPIClient(baseURL string) *APIClient {
+	return &APIClient{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   APIToken,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (e *APIError) Error() string {
+	return e.Message + " (" + e.Code + ")"
+}
+
+func (c *APIClient) Node(ctx context.Context) (NodeInfoResponse, error) {
+	var info NodeInfoResponse
+	return info, c.do(ctx, http.MethodGet, "/node", nil, &info)
+}
+
+func (c *APIClient) Peers(ctx context.Context) (PeersResponse, error) {
+	var peers PeersResponse
+	return peers, c.do(ctx, http.MethodGet, "/peers", nil, &peers)
+}
+
+func (c *APIClient) AddPeer(ctx context.Context, address string) (PeersResponse, error) {
+	var peers PeersResponse
+	return peers, c.do(ctx, http.MethodPost, "/peers", AddPeerRequest{Address: address}, &peers)
+}
+
+func (c *APIClient) BanPeer(ctx context.Context, address string) (PeersResponse, error) {
+	var peers PeersResponse
+	return peers, c.do(ctx, http.MethodPost, "/peers/"+url.PathEscape(address)+"/ban", nil, &peers)
+}
+
+func (c *APIClient) Tip(ctx context.Context) (BlockResponse, error) {
+	var block BlockResponse
+	return block, c.do(ctx, http.MethodGet, "/chain/tip", nil, &block)
+}
+
+// Returns the block at a height, or with a hash
+func (c *APIClient) Block(ctx context.Context, ref string) (BlockResponse, error) {
+	var block BlockResponse
+	return block, c.do(ctx, http.MethodGet, "/blocks/"+url.PathEscape(ref), nil, &block)
+}
+
+func (c *APIClient) BlockAt(ctx context.Context, height int) (BlockResponse, error) {
+	return c.Block(ctx, strconv.Itoa(height))
+}
+
+// Returns the headers from height from on
+func (c *APIClient) Headers(ctx context.Context, from, limit int) (HeadersResponse, error) {
+	var headers HeadersResponse
+	return headers, c.do(ctx, http.MethodGet, "/headers?"+pageQuery(blockchain.Page{Offset: from, Limit: limit}), nil, &headers)
+}
+
+func (c *APIClient) Checkpoints(ctx context.Context) (CheckpointsResponse, error) {
+	var checkpoints CheckpointsResponse
+	return checkpoints, c.do(ctx, http.MethodGet, "/checkpoints", nil, &checkpoints)
+}
+
+// Downloads a snapshot of the chain up to a height, the tip when negative
+func (c *APIClient) Snapshot(ctx context.Context, height int) (*blockchain.Snapshot, error) {
+	path := "/snapshot"
+	if height >= 0 {
+		path += "?height=" + strconv.Itoa(height)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.stream(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return blockchain.ReadSnapshot(response.Body)
+}
+
+// Writes the blocks from height from to height to, the tip when negative, in an export format
+func (c *APIClient) Export(ctx context.Context, from, to int, format string, w io.Writer) error {
+	query := url.Values{"from": {strconv.Itoa(from)}, "format": {format}}
+	if to >= 0 {
+		query.Set("to", strconv.Itoa(to))
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/chain/export?"+query.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	response, err := c.stream(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+// Imports blocks in an export format into the node's ledger
+func (c *APIClient) Import(ctx context.Context, r io.Reader) (ImportResponse, error) {
+	var imported ImportResponse
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/chain/import"), r)
+	if err != nil {
+		return imported, err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	response, err := c.stream(request)
+	if err != nil {
+		return imported, err
+	}
+	defer response.Body.Close()
+	if err := json.NewDecoder(response.Body).Decode(&imported); err != nil {
+		return imported, fmt.Errorf("invalid response from node API: %w", err)
+	}
+	return imported, nil
+}
+
+// Sends a request whose body or response may take a while to transfer
+func (c *APIClient) stream(request *http.Request) (*http.Response, error) {
+	c.authorize(request)
+	client := *c.HTTP
+	client.Timeout = 0
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("node API unreachable: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		return nil, decodeAPIError(response)
+	}
+	return response, nil
+}
+
+func (c *APIClient) Transaction(ctx context.Context, id string) (TransactionResponse, error) {
+	var tx TransactionResponse
+	return tx, c.do(ctx, http.MethodGet, "/transactions/"+url.PathEscape(id), nil, &tx)
+}
+
+// Returns the inclusion proof of a transaction; check it with its Verify method
+func (c *APIClient) Proof(ctx context.Context, id string) (InclusionProofResponse, error) {
+	var proof InclusionProofResponse
+	return proof, c.do(ctx, http.MethodGet, "/transactions/"+url.PathEscape(id)+"/proof", nil, &proof)
+}
+
+// Returns a page of the transactions indexed under key in an index: "datasets", "algorithms" or "outputs"
+func (c *APIClient) Transactions(ctx context.Context, index, key string, page blockchain.Page) (TransactionsResponse, error) {
+	var txs TransactionsResponse
+	return txs, c.do(ctx, http.MethodGet, "/"+index+"/"+url.PathEscape(key)+"/transactions?"+pageQuery(page), nil, &txs)
+}
+
+func (c *APIClient) MinerBlocks(ctx context.Context, miner string, page blockchain.Page) (MinerBlocksResponse, error) {
+	var blocks MinerBlocksResponse
+	return blocks, c.do(ctx, http.MethodGet, "/miners/"+url.PathEscape(miner)+"/blocks?"+pageQuery(page), nil, &blocks)
+}
+
+func pageQuery(page blockchain.Page) string {
+	query := url.Values{"offset": {strconv.Itoa(page.Offset)}}
+	if page.Limit > 0 {
+		query.Set("limit", strconv.Itoa(page.Limit))
+	}
+	return query.Encode()
+}
+
+func (c *APIClient) Mempool(ctx context.Context) (MempoolResponse, error) {
+	var pending MempoolResponse
+	return pending, c.do(ctx, http.MethodGet, "/mempool", nil, &pending)
+}
+
+func (c *APIClient) SubmitJob(ctx context.Context, request SubmitJobRequest) (string, error) {
+	var response SubmitJobResponse
+	return response.JobID, c.do(ctx, http.MethodPost, "/jobs", request, &response)
+}
+
+func (c *APIClient) JobStatus(ctx context.Context, jobID string) (JobStatusResponse, error) {
+	var status JobStatusResponse
+	return status, c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(jobID), nil, &status)
+}
+
+func (c *APIClient) JobResult(ctx context.Context, jobID string) (JobResultResponse, error) {
+	var result JobResultResponse
+	return result, c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(jobID)+"/result", nil, &result)
+}
+
+// Streams the events selected by query (topics, job, since) to handle until the context
+// ends, the stream closes or handle returns an error
+func (c *APIClient) Events(ctx context.Context, query url.Values, handle func(ReceivedEvent) error) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/events")+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "text/event-stream")
+
+	// The stream stays open, so the client's timeout does not apply
+	client := *c.HTTP
+	client.Timeout = 0
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return decodeAPIError(response)
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxRequestSize)
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		case line == "" && len(data) > 0:
+			var event ReceivedEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return fmt.Errorf("invalid event: %w", err)
+			}
+			data = data[:0]
+			if err := handle(event); err != nil {
+				return err
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+func (c *APIClient) url(path string) string {
+	return c.BaseURL + "/api/" + APIVersion + path
+}
+
+// Sends a request with an optional JSON body and decodes the JSON response into out
+func (c *APIClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.url(path), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(request)
+
+	response, err := c.HTTP.Do(request)
+	if err != nil {
+		return fmt.Errorf("node API unreachable: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return decodeAPIError(response)
+	}
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from node API: %w", err)
+	}
+	return nil
+}
+
+// Sends the token, if any, with requests that may change the node's state. Reads go
+// without it, so the token does not reach the other nodes a client syncs from.
+func (c *APIClient) authorize(request *http.Request) {
+	if c.Token != "" && request.Method != http.MethodGet {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+func decodeAPIError(response *http.Response) error {
+	var body APIErrorResponse
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.Error.Code == "" {
+		return &APIError{Code: "http_" + strconv.Itoa(response.StatusCode), Message: response.Status}
+	}
+	return &body.Error
+}
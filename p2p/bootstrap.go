@@ -0,0 +1,282 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ========================Bootstrap========================
+
+// A new node loads a snapshot of the chain and then syncs the blocks after it from the API
+// of full nodes. Blocks up to the highest checkpoint they reach are accepted on their hashes;
+// the jobs of later blocks are re-executed as for blocks received from miners.
+
+// File of the signed checkpoints the node enforces, a JSON array
+var CheckpointFile = envOr("CHECKPOINTS", "checkpoints.json")
+
+// Public keys trusted to sign checkpoints, hex, comma separated
+var CheckpointSigners = envOr("CHECKPOINT_SIGNERS", "")
+
+// File of the Ed25519 key this node signs checkpoints with
+var CheckpointKeyFile = envOr("CHECKPOINT_KEY", "checkpoint.key")
+
+var checkpoints *blockchain.Checkpoints
+var checkpointsErr error
+var checkpointsOnce sync.Once
+
+type CheckpointsResponse struct {
+	Checkpoints []CheckpointEntry `json:"checkpoints"`
+}
+
+type CheckpointEntry struct {
+	Height    int    `json:"height"`
+	Hash      string `json:"hash"`
+	Signer    string `json:"signer,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Returns the checkpoints this node enforces, loading them on first use
+func NodeCheckpoints() (*blockchain.Checkpoints, error) {
+	checkpointsOnce.Do(func() {
+		var trusted []string
+		if CheckpointSigners != "" {
+			trusted = strings.Split(CheckpointSigners, ",")
+		}
+		checkpoints, checkpointsErr = blockchain.LoadCheckpoints(CheckpointFile, trusted)
+	})
+	return checkpoints, checkpointsErr
+}
+
+// Loads the checkpoint signing key stored in path, creating one if the file does not exist
+func LoadCheckpointKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate checkpoint key: %w", err)
+		}
+		if dir := filepath.Dir(path); dir != "." {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to save checkpoint key: %w", err)
+		}
+		fmt.Println("Generated checkpoint key", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint key: %w", err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid checkpoint key in %s", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Loads a snapshot into the ledger. The jobs of blocks above the highest checkpoint the
+// snapshot reaches are re-executed.
+func Bootstrap(ctx context.Context, path string) error {
+	snapshot, err := blockchain.LoadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	checkpoints, err := NodeCheckpoints()
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Verify(checkpoints); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	trusted := checkpoints.HighestUpTo(snapshot.Height)
+	for height := trusted.Height + 1; height <= snapshot.Height; height++ {
+		fmt.Println("Re-executing the jobs of block", height, "above checkpoint", trusted.Height)
+		verified, err := VerifyBlock(ctx, snapshot.Blocks[height])
+		if err != nil {
+			return fmt.Errorf("block %d of snapshot: %w", height, err)
+		}
+		if !verified {
+			return fmt.Errorf("block %d of snapshot failed verification", height)
+		}
+	}
+
+	ledger.Reset(snapshot.Blocks)
+	prevHash = snapshot.Hash
+	fmt.Printf("Loaded snapshot %s: %d blocks up to %s, checkpoint at height %d\n", snapshot.ContentHash, snapshot.Height+1, snapshot.Hash, trusted.Height)
+	return nil
+}
+
+// Adds the blocks after the ledger's tip from the first of the full nodes' APIs that serves them
+func SyncLedger(ctx context.Context, sources []string) error {
+	checkpoints, err := NodeCheckpoints()
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return errors.New("no full node to sync from")
+	}
+	var errs []error
+	for _, source := range sources {
+		count, err := syncLedgerFrom(ctx, NewAPIClient(source), checkpoints)
+		if err == nil {
+			fmt.Println("Synced", count, "blocks from", source)
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", source, err))
+	}
+	return errors.Join(errs...)
+}
+
+func syncLedgerFrom(ctx context.Context, source *APIClient, checkpoints *blockchain.Checkpoints) (int, error) {
+	tip, err := source.Tip(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	appender := newLedgerAppender(checkpoints)
+	for height := appender.next(); height <= tip.Height; height++ {
+		response, err := source.BlockAt(ctx, height)
+		if err != nil {
+			return appender.count, err
+		}
+		block := &blockchain.Block{
+			Hash:         response.Hash,
+			PrevHash:     response.PrevHash,
+			MerkleRoot:   response.MerkleRoot,
+			Nonce:        response.Nonce,
+			Transactions: response.Transactions,
+		}
+		if err := appender.append(ctx, block, height); err != nil {
+			return appender.count, err
+		}
+	}
+	return appender.count, appender.finish()
+}
+
+// Adds blocks received from elsewhere to the ledger's tip, checking each one. Blocks up to
+// the highest checkpoint are held until they reach a checkpoint; the jobs of later blocks
+// are re-executed.
+type ledgerAppender struct {
+	checkpoints *blockchain.Checkpoints
+	pending     []*blockchain.Block // Blocks below a checkpoint, held until the checkpoint is reached
+	prev        *blockchain.Block
+	count       int // Blocks added to the ledger
+}
+
+func newLedgerAppender(checkpoints *blockchain.Checkpoints) *ledgerAppender {
+	return &ledgerAppender{checkpoints: checkpoints, prev: ledger.GetLatestBlock()}
+}
+
+// Height the next block must have
+func (a *ledgerAppender) next() int {
+	return ledger.Height() + len(a.pending) + 1
+}
+
+func (a *ledgerAppender) append(ctx context.Context, block *blockchain.Block, height int) error {
+	if block.PrevHash != a.prev.Hash {
+		return fmt.Errorf("block %d (%s) forks from our chain", height, block.Hash)
+	}
+	if err := blockchain.VerifyBlocks([]*blockchain.Block{block}); err != nil {
+		return fmt.Errorf("block %d: %w", height, err)
+	}
+	if err := a.checkpoints.Check(height, block.Hash); err != nil {
+		return err
+	}
+	a.prev = block
+
+	if height <= a.checkpoints.Highest().Height {
+		a.pending = append(a.pending, block)
+		if a.checkpoints.Pins(height) {
+			pending := a.pending
+			a.pending = nil
+			for _, block := range pending {
+				if err := a.add(block); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	verified, err := VerifyBlock(ctx, block)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", height, err)
+	}
+	if !verified {
+		return fmt.Errorf("block %d failed verification", height)
+	}
+	return a.add(block)
+}
+
+// Adds a checked block; the miner builds its next block on it. Fails if a block was mined
+// on the ledger's tip since the block was checked.
+func (a *ledgerAppender) add(block *blockchain.Block) error {
+	block, height, err := ledger.AddBlockToChain(block)
+	if err != nil {
+		return err
+	}
+	blockAdded(block, height)
+	prevHash = block.Hash
+	a.count++
+	return nil
+}
+
+// Checks that no block is left waiting for a checkpoint
+func (a *ledgerAppender) finish() error {
+	if len(a.pending) > 0 {
+		return fmt.Errorf("the blocks end at height %d, below checkpoint %d", a.next()-1, a.checkpoints.Highest().Height)
+	}
+	return nil
+}
+
+// ========================Snapshot API========================
+
+// Serves GET /api/v1/snapshot?height=, the chain up to a height, the tip by default
+func getSnapshot(w http.ResponseWriter, r *http.Request) {
+	height := ledger.Height()
+	if text := r.URL.Query().Get("height"); text != "" {
+		var err error
+		if height, err = strconv.Atoi(text); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_height", "height must be an integer")
+			return
+		}
+	}
+	snapshot, err := ledger.SnapshotAt(height)
+	if errors.Is(err, blockchain.ErrPruned) {
+		writePruned(w, "block "+strconv.Itoa(height))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, "block_not_found", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	snapshot.Write(w)
+}
+
+func listCheckpoints(w http.ResponseWriter, r *http.Request) {
+	checkpoints, err := NodeCheckpoints()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "invalid_checkpoints", err.Error())
+		return
+	}
+	response := CheckpointsResponse{Checkpoints: []CheckpointEntry{}}
+	for _, cp := range checkpoints.List() {
+		response.Checkpoints = append(response.Checkpoints, CheckpointEntry{Height: cp.Height, Hash: cp.Hash, Signer: cp.Signer, Signature: cp.Signature})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
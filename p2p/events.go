@@ -0,0 +1,316 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================Event Stream========================
+
+// Node events are published on a bus and served as Server-Sent Events by GET /api/v1/events.
+// Each event has an ID "<epoch>-<sequence>", where the epoch identifies the node process, and
+// the bus keeps the latest events so a client reconnecting with Last-Event-ID (or ?since=)
+// receives what it missed. When missed events are no longer kept, or the node restarted, a
+// "gap" event tells the client to resynchronize from the API first.
+
+// Topics of node events
+const (
+	TopicBlock       = "block"       // A block was added to the ledger, data is a BlockResponse
+	TopicTransaction = "transaction" // A transaction entered the mempool, data is the transaction
+	TopicJob         = "job"         // A job changed status, data is a JobUpdate
+	TopicReorg       = "reorg"       // A competing block or fork was seen, data is a ReorgEvent
+	TopicPeer        = "peer"        // A peer was added or found unreachable, data is a PeerEvent
+	topicGap         = "gap"         // Events between the client's cursor and the first event sent were lost
+)
+
+var eventTopics = []string{TopicBlock, TopicTransaction, TopicJob, TopicReorg, TopicPeer}
+
+// Events kept for clients resuming from a cursor
+var eventHistory = intEnv("EVENT_HISTORY", 1000)
+
+// Interval of the comments keeping idle streams open through proxies
+var eventKeepAlive = 15 * time.Second
+
+type Event struct {
+	ID    string      `json:"id,omitempty"`
+	Topic string      `json:"topic"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+
+	seq  uint64
+	jobs []string // Jobs the event concerns, for filtering
+}
+
+// A block that did not extend the ledger as received
+type ReorgEvent struct {
+	Reason    string `json:"reason"`              // "fork": the block's parent is not our tip; "competing": a block at the same height was discarded; "replaced": our tip was disconnected for the block
+	Height    int    `json:"height"`              // Height of the ledger's tip
+	Tip       string `json:"tip"`                 // Hash of the ledger's tip
+	Block     string `json:"block"`               // Hash of the block received
+	PrevHash  string `json:"prevHash"`            // Parent of the block received
+	Discarded string `json:"discarded,omitempty"` // Hash of the discarded competing block
+}
+
+type PeerEvent struct {
+	Address string `json:"address"`
+	Status  string `json:"status"` // "connected" or "disconnected"
+}
+
+// Publishes events to subscribers and keeps the latest ones
+type EventBus struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	history     []Event
+	subscribers map[chan Event]bool
+}
+
+// Events of this node
+var Events = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: map[chan Event]bool{},
+	}
+}
+
+// Publishes an event on a topic. jobs lists the jobs it concerns.
+func (b *EventBus) Publish(topic string, data interface{}, jobs ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event := Event{
+		ID:    b.epoch + "-" + strconv.FormatUint(b.seq, 10),
+		Topic: topic,
+		Time:  time.Now(),
+		Data:  data,
+		seq:   b.seq,
+		jobs:  jobs,
+	}
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistory {
+		b.history = b.history[len(b.history)-eventHistory:]
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// A slow client is dropped; it resumes from its last event ID
+			close(ch)
+			delete(b.subscribers, ch)
+		}
+	}
+}
+
+// Subscribes to the events after the cursor, an event ID or "" for new events only.
+// gap is set when events after the cursor were lost. The channel is closed if the
+// subscriber falls behind.
+func (b *EventBus) Subscribe(cursor string) (missed []Event, gap bool, events <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cursor != "" {
+		epoch, seqText, _ := strings.Cut(cursor, "-")
+		seq, err := strconv.ParseUint(seqText, 10, 64)
+		switch {
+		case err != nil || epoch != b.epoch || seq > b.seq:
+			gap = true
+			missed = append(missed, b.history...)
+		default:
+			for _, event := range b.history {
+				if event.seq > seq {
+					missed = append(missed, event)
+				}
+			}
+			oldest := b.seq + 1
+			if len(b.history) > 0 {
+				oldest = b.history[0].seq
+			}
+			gap = seq+1 < oldest
+		}
+	}
+
+	ch := make(chan Event, 256)
+	b.subscribers[ch] = true
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return missed, gap, ch, cancel
+}
+
+// Selects the events a client asked for
+type eventFilter struct {
+	topics map[string]bool
+	job    string
+}
+
+func (f eventFilter) match(event Event) bool {
+	if !f.topics[event.Topic] {
+		return false
+	}
+	if f.job == "" {
+		return true
+	}
+	for _, job := range event.jobs {
+		if job == f.job {
+			return true
+		}
+	}
+	return false
+}
+
+// Serves GET /api/v1/events as Server-Sent Events. Query parameters: topics (comma
+// separated, all by default), job (events concerning one job), since (cursor, also read
+// from the Last-Event-ID header).
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming_unsupported", "the connection does not support streaming")
+		return
+	}
+
+	filter := eventFilter{topics: map[string]bool{}, job: r.URL.Query().Get("job")}
+	if topics := r.URL.Query().Get("topics"); topics != "" {
+		for _, topic := range strings.Split(topics, ",") {
+			topic = strings.TrimSpace(topic)
+			if !containsString(eventTopics, topic) {
+				writeError(w, http.StatusBadRequest, "invalid_topic", fmt.Sprintf("unknown topic %q, use %s", topic, strings.Join(eventTopics, ", ")))
+				return
+			}
+			filter.topics[topic] = true
+		}
+	} else {
+		for _, topic := range eventTopics {
+			filter.topics[topic] = true
+		}
+	}
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("since")
+	}
+
+	missed, gap, events, cancel := Events.Subscribe(cursor)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if gap {
+		writeEvent(w, Event{Topic: topicGap, Time: time.Now(), Data: map[string]string{"since": cursor}})
+	}
+	for _, event := range missed {
+		if filter.match(event) {
+			writeEvent(w, event)
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.match(event) {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.ID != "" {
+		fmt.Fprintf(w, "id: %s\n", event.ID)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Topic, data)
+	return err
+}
+
+// Records a block added to the ledger: its jobs are mined and an event is published
+func blockAdded(block *blockchain.Block, height int) {
+	Jobs.BlockAdded(block, height)
+	defer pruneLedger()
+
+	var jobs []string
+	for _, tx := range block.Transactions {
+		if tx.JobID != "" {
+			jobs = append(jobs, tx.JobID)
+		}
+	}
+	Events.Publish(TopicBlock, blockResponse(block, height), jobs...)
+}
+
+// Publishes a reorg event when a received block does not extend the ledger's tip. A block
+// built on the same parent as our tip replaces it: the tip is disconnected and its
+// transactions missing from the received block go back to the mempool.
+func checkFork(block *blockchain.Block) {
+	height := ledger.Height()
+	tip, _ := ledger.BlockAt(height)
+	if block.PrevHash == tip.Hash {
+		return
+	}
+	fmt.Println("Received block", block.Hash, "forks from our tip", tip.Hash)
+	Events.Publish(TopicReorg, ReorgEvent{Reason: "fork", Height: height, Tip: tip.Hash, Block: block.Hash, PrevHash: block.PrevHash})
+
+	if block.PrevHash != tip.PrevHash {
+		return
+	}
+	replaced, ok := ledger.DisconnectTip()
+	if !ok {
+		return
+	}
+	fmt.Println("Replacing our tip", replaced.Hash, "with block", block.Hash)
+	parent := ledger.GetLatestBlock()
+	Events.Publish(TopicReorg, ReorgEvent{Reason: "replaced", Height: height - 1, Tip: parent.Hash, Block: block.Hash, PrevHash: block.PrevHash, Discarded: replaced.Hash})
+
+	included := make(map[string]bool, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		included[tx.ID()] = true
+	}
+	for i := range replaced.Transactions {
+		if tx := replaced.Transactions[i]; !included[tx.ID()] {
+			mempool.AddTransaction(&tx)
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Sets the number of events kept for a test, restoring it afterwards
+func setEventHistory(t *testing.T, n int) {
+	t.Helper()
+	history := eventHistory
+	t.Cleanup(func() { eventHistory = history })
+	eventHistory = n
+}
+
+func eventIDs(events []Event) []string {
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestEventBusSubscribe(t *testing.T) {
+	setEventHistory(t, 3)
+	bus := NewEventBus()
+	var published []Event
+	for i := 0; i < 5; i++ {
+		bus.Publish(TopicBlock, i)
+		published = append(published, bus.history[len(bus.history)-1])
+	}
+	ids := eventIDs(published)
+
+	tests := []struct {
+		name   string
+		cursor string
+		missed []string
+		gap    bool
+	}{
+		{"new events only", "", nil, false},
+		{"up to date", ids[4], nil, false},
+		{"resumed within the history", ids[2], ids[3:], false},
+		{"resumed at the oldest kept event", ids[1], ids[2:], false},
+		{"events evicted", ids[0], ids[2:], true},
+		{"another epoch", "otherepoch-3", ids[2:], true},
+		{"ahead of the bus", bus.epoch + "-9", ids[2:], true},
+		{"malformed", "cursor", ids[2:], true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			missed, gap, _, cancel := bus.Subscribe(test.cursor)
+			defer cancel()
+			if gap != test.gap {
+				t.Fatalf("gap %v, want %v", gap, test.gap)
+			}
+			if got := eventIDs(missed); strings.Join(got, ",") != strings.Join(test.missed, ",") {
+				t.Fatalf("missed %q, want %q", got, test.missed)
+			}
+		})
+	}
+}
+
+func TestEventBusPublish(t *testing.T) {
+	bus := NewEventBus()
+	_, _, events, cancel := bus.Subscribe("")
+	bus.Publish(TopicJob, "update", "job-1")
+	if event := <-events; event.Topic != TopicJob || event.Data != "update" || event.jobs[0] != "job-1" {
+		t.Fatalf("received %+v", event)
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("channel open after cancelling")
+	}
+	bus.Publish(TopicJob, "after cancelling")
+}
+
+func TestEventFilter(t *testing.T) {
+	blocks := eventFilter{topics: map[string]bool{TopicBlock: true}}
+	jobs := eventFilter{topics: map[string]bool{TopicBlock: true, TopicJob: true}, job: "job-1"}
+
+	tests := []struct {
+		name   string
+		filter eventFilter
+		event  Event
+		match  bool
+	}{
+		{"topic selected", blocks, Event{Topic: TopicBlock}, true},
+		{"topic not selected", blocks, Event{Topic: TopicJob}, false},
+		{"job concerned", jobs, Event{Topic: TopicJob, jobs: []string{"job-1"}}, true},
+		{"one of the jobs concerned", jobs, Event{Topic: TopicBlock, jobs: []string{"job-0", "job-1"}}, true},
+		{"other job", jobs, Event{Topic: TopicJob, jobs: []string{"job-2"}}, false},
+		{"no job", jobs, Event{Topic: TopicBlock}, false},
+		{"job concerned, topic not selected", jobs, Event{Topic: TopicPeer, jobs: []string{"job-1"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if match := test.filter.match(test.event); match != test.match {
+				t.Fatalf("match %v, want %v", match, test.match)
+			}
+		})
+	}
+}
+
+// A streamed event, as the ID and topic lines of the stream
+type streamedEvent struct {
+	id    string
+	topic string
+}
+
+// Streams the events a request receives up to the ones already published
+func streamedEvents(t *testing.T, target string, lastEventID string) (*httptest.ResponseRecorder, []streamedEvent) {
+	t.Helper()
+	// A cancelled request returns once the missed events are written
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	if lastEventID != "" {
+		r.Header.Set("Last-Event-ID", lastEventID)
+	}
+	w := httptest.NewRecorder()
+	streamEvents(w, r)
+
+	var events []streamedEvent
+	var event streamedEvent
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.topic = strings.TrimPrefix(line, "event: ")
+		case line == "" && event.topic != "":
+			events = append(events, event)
+			event = streamedEvent{}
+		}
+	}
+	return w, events
+}
+
+func TestStreamEvents(t *testing.T) {
+	setEventHistory(t, 4)
+	events := Events
+	t.Cleanup(func() { Events = events })
+	Events = NewEventBus()
+
+	Events.Publish(TopicBlock, "evicted")
+	Events.Publish(TopicJob, "job-1 queued", "job-1")
+	Events.Publish(TopicBlock, "block", "job-1")
+	Events.Publish(TopicPeer, "peer")
+	Events.Publish(TopicJob, "job-2 queued", "job-2")
+	ids := eventIDs(Events.history)
+	all := []streamedEvent{{ids[0], TopicJob}, {ids[1], TopicBlock}, {ids[2], TopicPeer}, {ids[3], TopicJob}}
+	gap := streamedEvent{topic: topicGap}
+
+	tests := []struct {
+		name        string
+		target      string
+		lastEventID string
+		want        []streamedEvent
+	}{
+		{"new events only", "/api/v1/events", "", nil},
+		{"resumed from since", "/api/v1/events?since=" + ids[1], "", all[2:]},
+		{"resumed from Last-Event-ID", "/api/v1/events?since=" + ids[0], ids[2], all[3:]},
+		{"gap after eviction", "/api/v1/events", Events.epoch + "-0", append([]streamedEvent{gap}, all...)},
+		{"gap after a restart", "/api/v1/events?since=before-3", "", append([]streamedEvent{gap}, all...)},
+		{"topics", "/api/v1/events?topics=job,peer&since=" + ids[0], "", []streamedEvent{all[2], all[3]}},
+		{"job", "/api/v1/events?job=job-1&since=" + Events.epoch + "-1", "", all[:2]},
+		{"topics and job", "/api/v1/events?topics=job&job=job-1&since=" + Events.epoch + "-1", "", all[:1]},
+		{"gap not filtered", "/api/v1/events?topics=peer&job=job-2&since=" + Events.epoch + "-0", "", []streamedEvent{gap}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, events := streamedEvents(t, test.target, test.lastEventID)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if len(events) != len(test.want) {
+				t.Fatalf("streamed %+v, want %+v", events, test.want)
+			}
+			for i, event := range events {
+				if event != test.want[i] {
+					t.Fatalf("streamed %+v, want %+v", events, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamEventsInvalidTopic(t *testing.T) {
+	w, _ := streamedEvents(t, "/api/v1/events?topics=block,blocks", "")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_topic") {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+}
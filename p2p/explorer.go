@@ -0,0 +1,199 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"net/http"
+	"strconv"
+)
+
+// ========================Explorer========================
+
+// Queries of the chain's indexes, for auditors asking e.g. for every result ever computed on
+// a dataset. Lists take ?offset= and ?limit= and are ordered oldest first.
+
+// A transaction with its location in the chain
+type TransactionResponse struct {
+	ID          string                 `json:"id"`
+	Height      int                    `json:"height"`
+	Block       string                 `json:"block"`
+	Position    int                    `json:"position"`         // Index of the transaction in its block
+	Transaction blockchain.Transaction `json:"transaction"`      // Empty when pruned
+	Pruned      bool                   `json:"pruned,omitempty"` // Whether this node discarded the transaction's body
+}
+
+type TransactionsResponse struct {
+	Total        int                   `json:"total"` // Results of the query, across all pages
+	Offset       int                   `json:"offset"`
+	Limit        int                   `json:"limit"`
+	Transactions []TransactionResponse `json:"transactions"`
+}
+
+type MinerBlocksResponse struct {
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Blocks []BlockRefEntry `json:"blocks"`
+}
+
+type BlockRefEntry struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+func getTransaction(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	tx, ok := ledger.TransactionByID(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "transaction_not_found", "no transaction with ID "+id)
+		return
+	}
+	if tx.Pruned {
+		writePruned(w, "transaction "+id)
+		return
+	}
+	writeJSON(w, http.StatusOK, transactionResponse(tx))
+}
+
+// Serves a list of transactions found by a path value with the given query
+func listTransactions(key string, query func(string, blockchain.Page) ([]blockchain.IndexedTransaction, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, ok := readPage(w, r)
+		if !ok {
+			return
+		}
+		txs, total := query(r.PathValue(key), page)
+		response := TransactionsResponse{Total: total, Offset: page.Offset, Limit: page.Limit, Transactions: make([]TransactionResponse, 0, len(txs))}
+		for _, tx := range txs {
+			response.Transactions = append(response.Transactions, transactionResponse(tx))
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+func listMinerBlocks(w http.ResponseWriter, r *http.Request) {
+	page, ok := readPage(w, r)
+	if !ok {
+		return
+	}
+	blocks, total := ledger.BlocksByMiner(r.PathValue("id"), page)
+	response := MinerBlocksResponse{Total: total, Offset: page.Offset, Limit: page.Limit, Blocks: make([]BlockRefEntry, 0, len(blocks))}
+	for _, block := range blocks {
+		response.Blocks = append(response.Blocks, BlockRefEntry{Height: block.Height, Hash: block.Hash})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func transactionResponse(tx blockchain.IndexedTransaction) TransactionResponse {
+	return TransactionResponse{
+		ID:          tx.ID,
+		Height:      tx.Height,
+		Block:       tx.Block,
+		Position:    tx.Position,
+		Transaction: tx.Transaction,
+		Pruned:      tx.Pruned,
+	}
+}
+
+// Reads ?offset= and ?limit=, answering with an error if they are invalid
+func readPage(w http.ResponseWriter, r *http.Request) (blockchain.Page, bool) {
+	page := blockchain.Page{Limit: blockchain.DefaultPageLimit}
+	for name, value := range map[string]*int{"offset": &page.Offset, "limit": &page.Limit} {
+		text := r.URL.Query().Get(name)
+		if text == "" {
+			continue
+		}
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_page", name+" must be a non-negative integer")
+			return page, false
+		}
+		*value = n
+	}
+	if page.Limit == 0 || page.Limit > blockchain.MaxPageLimit {
+		writeError(w, http.StatusBadRequest, "invalid_page", "limit must be between 1 and "+strconv.Itoa(blockchain.MaxPageLimit))
+		return page, false
+	}
+	return page, true
+}
+
+// ========================Inclusion Proofs========================
+
+// Fields of a block its proof-of-work hash covers
+type BlockHeaderResponse struct {
+	Height     int    `json:"height"`
+	Hash       string `json:"hash"`
+	PrevHash   string `json:"prevHash"`
+	MerkleRoot string `json:"merkleRoot"`
+	Nonce      int    `json:"nonce"`
+}
+
+// Proof that a transaction is recorded in a block, checkable with the header alone
+type InclusionProofResponse struct {
+	Transaction TransactionResponse `json:"transaction"`
+	Header      BlockHeaderResponse `json:"header"`
+	Proof       MerkleProofEntry    `json:"proof"`
+}
+
+type MerkleProofEntry struct {
+	TxID  string           `json:"txId"`
+	Root  string           `json:"root"`
+	Steps []ProofStepEntry `json:"steps"` // Siblings from the leaf up
+}
+
+type ProofStepEntry struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // Whether the sibling is hashed on the left
+}
+
+func getTransactionProof(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	tx, ok := ledger.TransactionByID(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "transaction_not_found", "no transaction with ID "+id)
+		return
+	}
+	if tx.Pruned {
+		writePruned(w, "transaction "+id)
+		return
+	}
+	block, ok := ledger.BlockAt(tx.Height)
+	if !ok {
+		writeError(w, http.StatusNotFound, "transaction_not_found", "no transaction with ID "+id)
+		return
+	}
+	proof, err := blockchain.NewMerkleProof(block.Transactions, tx.Position)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "proof_failed", err.Error())
+		return
+	}
+
+	response := InclusionProofResponse{
+		Transaction: transactionResponse(tx),
+		Header:      headerResponse(block.Header(), tx.Height),
+		Proof:       MerkleProofEntry{TxID: proof.TxID, Root: proof.Root, Steps: make([]ProofStepEntry, 0, len(proof.Steps))},
+	}
+	for _, step := range proof.Steps {
+		response.Proof.Steps = append(response.Proof.Steps, ProofStepEntry{Hash: step.Hash, Left: step.Left})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func headerResponse(header blockchain.BlockHeader, height int) BlockHeaderResponse {
+	return BlockHeaderResponse{
+		Height:     height,
+		Hash:       header.Hash,
+		PrevHash:   header.PrevHash,
+		MerkleRoot: header.MerkleRoot,
+		Nonce:      header.Nonce,
+	}
+}
+
+// Checks an inclusion proof received from a node, without trusting the node
+func (p InclusionProofResponse) Verify() error {
+	proof := blockchain.MerkleProof{TxID: p.Proof.TxID, Root: p.Proof.Root}
+	for _, step := range p.Proof.Steps {
+		proof.Steps = append(proof.Steps, blockchain.ProofStep{Hash: step.Hash, Left: step.Left})
+	}
+	header := blockchain.BlockHeader{Hash: p.Header.Hash, PrevHash: p.Header.PrevHash, MerkleRoot: p.Header.MerkleRoot, Nonce: p.Header.Nonce}
+	return blockchain.VerifyInclusion(p.Transaction.Transaction, header, proof)
+}
@@ -0,0 +1,133 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ========================Chain Export and Import========================
+
+// A range of the ledger can be exported as JSON Lines or in the compact binary format, and
+// imported into another node. Imported blocks are checked like synced blocks before they
+// are added. Blocks the ledger already has are skipped, so an interrupted import resumes
+// when run again with the same file.
+
+// Largest export accepted by POST /api/v1/chain/import, in bytes
+var maxImportSize = int64(intEnv("MAX_IMPORT_SIZE", 1<<30))
+
+type ImportResponse struct {
+	Imported int    `json:"imported"` // Blocks added to the ledger
+	Skipped  int    `json:"skipped"`  // Blocks the ledger already had
+	Height   int    `json:"height"`   // Height of the ledger's tip after the import
+	Tip      string `json:"tip"`
+}
+
+// Adds the blocks read from r, in either export format, to the ledger
+func ImportChain(ctx context.Context, r io.Reader) (response ImportResponse, err error) {
+	checkpoints, err := NodeCheckpoints()
+	if err != nil {
+		return response, err
+	}
+	reader, err := blockchain.NewBlockReader(r)
+	if err != nil {
+		return response, err
+	}
+
+	appender := newLedgerAppender(checkpoints)
+	defer func() {
+		response.Imported = appender.count
+		response.Height = ledger.Height()
+		response.Tip = ledger.GetLatestBlock().Hash
+	}()
+	for {
+		exported, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return response, err
+		}
+
+		block := exported.Block()
+		next := appender.next()
+		switch {
+		case exported.Height <= ledger.Height():
+			existing, _ := ledger.BlockAt(exported.Height)
+			if existing == nil || existing.Hash != block.Hash {
+				return response, fmt.Errorf("block %d (%s) conflicts with our block", exported.Height, block.Hash)
+			}
+			response.Skipped++
+		case exported.Height != next:
+			return response, fmt.Errorf("block %d is out of order, expected height %d", exported.Height, next)
+		default:
+			if err := appender.append(ctx, block, exported.Height); err != nil {
+				return response, err
+			}
+		}
+	}
+	return response, appender.finish()
+}
+
+// Serves GET /api/v1/chain/export?from=&to=&format=, a range of the ledger, the whole chain by default
+func exportChain(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to := 0, -1
+	for name, value := range map[string]*int{"from": &from, "to": &to} {
+		if text := query.Get(name); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_height", name+" must be an integer")
+				return
+			}
+			*value = n
+		}
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = blockchain.FormatJSONL
+	}
+
+	writer, err := blockchain.NewBlockWriter(w, format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_format", err.Error())
+		return
+	}
+	from, to, err = ledger.ExportRange(from, to)
+	if errors.Is(err, blockchain.ErrPruned) {
+		writePruned(w, "block "+strconv.Itoa(from))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_height", err.Error())
+		return
+	}
+	if format == blockchain.FormatBinary {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	if _, err := ledger.Export(writer, from, to); err != nil {
+		fmt.Println("Error exporting chain:", err)
+	}
+}
+
+// Serves POST /api/v1/chain/import, the body being an export in either format
+func importChain(w http.ResponseWriter, r *http.Request) {
+	response, err := ImportChain(r.Context(), http.MaxBytesReader(w, r.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("exports larger than %d bytes are not accepted (imported %d blocks, skipped %d, tip at height %d)", maxImportSize, response.Imported, response.Skipped, response.Height))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "import_failed", fmt.Sprintf("%v (imported %d blocks, skipped %d, tip at height %d)", err, response.Imported, response.Skipped, response.Height))
+		return
+	}
+	fmt.Println("Imported", response.Imported, "blocks, skipped", response.Skipped)
+	writeJSON(w, http.StatusOK, response)
+}
@@ -20,6 +20,7 @@ func CheckPeerHealth() {
 				fmt.Println("Peer unreachable:", peer)
 				// Remove dead peer
 				peers = append(peers[:i], peers[i+1:]...)
+				Events.Publish(TopicPeer, PeerEvent{Address: peer, Status: "disconnected"})
 			} else {
 				conn.Close() // Connection is healthy
 			}
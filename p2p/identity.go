@@ -0,0 +1,209 @@
+package p2p
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ========================Node Identity========================
+
+// Every node owns an X25519 key pair. Its public key identifies the node to generators,
+// which release dataset keys sealed to it, so only the holder of the private key can use them.
+// The Ed25519 key the node signs the transactions it mines with is derived from the same key.
+
+// File the private key of the node is kept in
+var NodeKeyFile = envOr("NODE_KEY_FILE", "node.key")
+
+// File listing the public keys of the nodes a generator releases dataset keys to,
+// one hex key per line, optionally followed by a comment
+var AuthorizedKeysFile = envOr("AUTHORIZED_KEYS", "authorized_keys")
+
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+var identity *Identity
+var identityErr error
+var identityOnce sync.Once
+
+// Returns the identity of this node, generating it on first use
+func NodeIdentity() (*Identity, error) {
+	identityOnce.Do(func() {
+		identity, identityErr = LoadIdentity(NodeKeyFile)
+	})
+	return identity, identityErr
+}
+
+// Loads the identity stored in path, creating a new one if the file does not exist
+func LoadIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return GenerateIdentity(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node key: %w", err)
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid node key in %s: %w", path, err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node key in %s: %w", path, err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// Creates a new identity and stores it in path, readable by the node's user only
+func GenerateIdentity(path string) (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate node key: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Bytes())+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save node key: %w", err)
+	}
+	fmt.Println("Generated node key", path)
+	return &Identity{key: key}, nil
+}
+
+// Returns the public key of the node, hex encoded
+func (id *Identity) PublicKey() string {
+	return hex.EncodeToString(id.key.PublicKey().Bytes())
+}
+
+// Returns the key the node signs the transactions it mines with
+func (id *Identity) SigningKey() ed25519.PrivateKey {
+	seed := sha256.Sum256(append([]byte("miner signing key:"), id.key.Bytes()...))
+	return ed25519.NewKeyFromSeed(seed[:])
+}
+
+// Returns the public key of the node's signing key, hex encoded, which the explorer
+// credits mined transactions to
+func (id *Identity) MinerID() string {
+	return hex.EncodeToString(id.SigningKey().Public().(ed25519.PublicKey))
+}
+
+// Seals a dataset key to the node with the given public key: an ephemeral X25519 exchange
+// derives an AES-GCM key only the recipient can recompute
+func SealKey(recipient string, key []byte) (string, error) {
+	raw, err := hex.DecodeString(recipient)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	public, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(public)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := sealingCipher(shared, ephemeral.PublicKey().Bytes(), raw)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := append(ephemeral.PublicKey().Bytes(), nonce...)
+	sealed = aead.Seal(sealed, nonce, key, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Opens a dataset key sealed to this node
+func (id *Identity) OpenKey(sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed key: %w", err)
+	}
+	if len(data) < 32+12 {
+		return nil, errors.New("invalid sealed key: too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(data[:32])
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed key: %w", err)
+	}
+	shared, err := id.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := sealingCipher(shared, data[:32], id.key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	key, err := aead.Open(nil, data[32:32+aead.NonceSize()], data[32+aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("sealed key was not meant for this node")
+	}
+	return key, nil
+}
+
+// Derives the AES-GCM cipher of a sealed key from the shared secret and both public keys
+func sealingCipher(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write([]byte("dataset-key-release"))
+	h.Write(shared)
+	h.Write(ephemeral)
+	h.Write(recipient)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the public keys listed in the authorized keys file
+func AuthorizedKeys() (map[string]bool, error) {
+	file, err := os.Open(AuthorizedKeysFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	defer file.Close()
+
+	keys := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		keys[strings.ToLower(fields[0])] = true
+	}
+	return keys, scanner.Err()
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
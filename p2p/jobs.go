@@ -0,0 +1,345 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ========================Job Tracking========================
+
+// Every submission carries a job ID chosen by the generator. Miners report the progress of
+// the job as STATUS messages on the connection it was submitted on, or on any connection
+// that asks with WATCH, and the generator records them in its own tracker. The job is
+// final once one miner reports it confirmed, or every miner it was sent to reports a failure.
+
+type JobStatus string
+
+const (
+	JobSubmitted JobStatus = "submitted" // Sent to a miner, recorded by the generator
+	JobReceived  JobStatus = "received"  // Accepted by the miner
+	JobExecuting JobStatus = "executing" // Being run by the miner
+	JobInMempool JobStatus = "mempool"   // Transaction waiting to be mined
+	JobMined     JobStatus = "mined"     // Transaction included in a block
+	JobConfirmed JobStatus = "confirmed" // Block buried under Confirmations blocks
+	JobFailed    JobStatus = "failed"    // Job rejected or failed to run
+	JobUnknown   JobStatus = "unknown"   // Job never seen by the miner asked
+)
+
+// Reports whether no update follows the status
+func (s JobStatus) Final() bool {
+	return s == JobConfirmed || s == JobFailed || s == JobUnknown
+}
+
+// A status transition of a job
+type JobUpdate struct {
+	JobID         string    `json:"jobId"`
+	Status        JobStatus `json:"status"`
+	Peer          string    `json:"peer,omitempty"`          // Miner reporting the update, as seen by the generator
+	Block         string    `json:"block,omitempty"`         // Hash of the block the job was mined in
+	Height        int       `json:"height,omitempty"`        // Height of that block
+	Confirmations int       `json:"confirmations,omitempty"` // Blocks on top of that block, itself included
+	OutputCID     string    `json:"outputCid,omitempty"`     // CID of the published output
+	Error         string    `json:"error,omitempty"`         // Reason of a failure
+	Time          time.Time `json:"time"`
+}
+
+// Blocks, counting its own, a job's block must be buried under to be confirmed
+var Confirmations = intEnv("CONFIRMATIONS", 3)
+
+// Time finished jobs are remembered for
+var jobRetention = time.Hour
+
+// Records the status of jobs and notifies subscribers of every update
+type JobTracker struct {
+	mu     sync.Mutex
+	jobs   map[string]*trackedJob
+	events *EventBus
+}
+
+type trackedJob struct {
+	updates     []JobUpdate
+	peers       map[string]JobStatus // Last status reported by each miner, "" for this node
+	mined       *JobUpdate           // Block this node saw the job mined in, awaiting confirmation
+	done        time.Time
+	subscribers map[chan JobUpdate]bool
+}
+
+// Jobs known to this node: its own submissions and the jobs it runs or sees mined
+var Jobs = NewJobTracker(Events)
+
+// Returns a tracker publishing every update on events, if set
+func NewJobTracker(events *EventBus) *JobTracker {
+	return &JobTracker{jobs: map[string]*trackedJob{}, events: events}
+}
+
+// Returns a new random job ID
+func NewJobID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Records a status transition. Updates to a finished job are ignored.
+func (t *JobTracker) Update(update JobUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.update(update)
+}
+
+func (t *JobTracker) update(update JobUpdate) {
+	if update.Time.IsZero() {
+		update.Time = time.Now()
+	}
+	t.prune()
+	job, ok := t.jobs[update.JobID]
+	if !ok {
+		job = &trackedJob{peers: map[string]JobStatus{}, subscribers: map[chan JobUpdate]bool{}}
+		t.jobs[update.JobID] = job
+	}
+	if !job.done.IsZero() {
+		return
+	}
+
+	job.updates = append(job.updates, update)
+	job.peers[update.Peer] = update.Status
+	if t.events != nil {
+		t.events.Publish(TopicJob, update, update.JobID)
+	}
+	for ch := range job.subscribers {
+		select {
+		case ch <- update:
+		default:
+			// A subscriber that falls behind is dropped rather than blocking the node
+			close(ch)
+			delete(job.subscribers, ch)
+		}
+	}
+	if job.finished() {
+		job.done = time.Now()
+		for ch := range job.subscribers {
+			close(ch)
+		}
+		job.subscribers = nil
+	}
+}
+
+func (j *trackedJob) finished() bool {
+	failed := 0
+	for _, status := range j.peers {
+		if status == JobConfirmed {
+			return true
+		}
+		if status.Final() {
+			failed++
+		}
+	}
+	return failed == len(j.peers)
+}
+
+// Forgets jobs that finished more than jobRetention ago
+func (t *JobTracker) prune() {
+	for id, job := range t.jobs {
+		if !job.done.IsZero() && time.Since(job.done) > jobRetention {
+			delete(t.jobs, id)
+		}
+	}
+}
+
+// Returns the updates recorded for a job so far
+func (t *JobTracker) History(jobID string) ([]JobUpdate, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[jobID]
+	if !ok {
+		return nil, false
+	}
+	return append([]JobUpdate(nil), job.updates...), true
+}
+
+// Reports whether a job is confirmed or failed for good
+func (t *JobTracker) Final(jobID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[jobID]
+	return ok && !job.done.IsZero()
+}
+
+// Returns the updates recorded for a job so far and a channel receiving the next ones,
+// closed once the job is final. cancel stops the subscription.
+func (t *JobTracker) Subscribe(jobID string) (history []JobUpdate, updates <-chan JobUpdate, cancel func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan JobUpdate, 64)
+	job, ok := t.jobs[jobID]
+	if ok {
+		history = append(history, job.updates...)
+	}
+	if !ok || !job.done.IsZero() {
+		close(ch)
+		return history, ch, func() {}
+	}
+
+	job.subscribers[ch] = true
+	cancel = func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if job.subscribers[ch] {
+			delete(job.subscribers, ch)
+			close(ch)
+		}
+	}
+	return history, ch, cancel
+}
+
+// Waits until a job is final and returns the update that settled it:
+// the confirmation, or the last failure
+func (t *JobTracker) Wait(ctx context.Context, jobID string) (JobUpdate, error) {
+	history, updates, cancel := t.Subscribe(jobID)
+	defer cancel()
+	if len(history) == 0 {
+		return JobUpdate{}, fmt.Errorf("unknown job %s", jobID)
+	}
+
+	last := history[len(history)-1]
+	for _, update := range history {
+		if update.Status == JobConfirmed {
+			return update, nil
+		}
+	}
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return last, nil
+			}
+			last = update
+			if update.Status == JobConfirmed {
+				return update, nil
+			}
+		case <-ctx.Done():
+			return last, ctx.Err()
+		}
+	}
+}
+
+// Records that this node saw a block at the given height: jobs in it are mined, and jobs
+// mined in earlier blocks are confirmed once enough blocks are built on top of them
+func (t *JobTracker) BlockAdded(block *blockchain.Block, height int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		job, ok := t.jobs[tx.JobID]
+		if tx.JobID == "" || ok && job.mined != nil {
+			continue
+		}
+		t.update(JobUpdate{JobID: tx.JobID, Status: JobMined, Block: block.Hash, Height: height, Confirmations: 1, OutputCID: tx.OutputCID})
+		if job = t.jobs[tx.JobID]; job.done.IsZero() {
+			mined := job.updates[len(job.updates)-1]
+			job.mined = &mined
+		}
+	}
+
+	for _, job := range t.jobs {
+		if job.mined == nil || !job.done.IsZero() {
+			continue
+		}
+		confirmed := *job.mined
+		confirmed.Confirmations = height - confirmed.Height + 1
+		if confirmed.Confirmations >= Confirmations {
+			confirmed.Status, confirmed.Time = JobConfirmed, time.Time{}
+			t.update(confirmed)
+		}
+	}
+}
+
+// Records an update of a job run by this node, if the job is tracked
+func trackJob(jobID string, update JobUpdate) {
+	if jobID == "" {
+		return
+	}
+	update.JobID = jobID
+	Jobs.Update(update)
+}
+
+// Sends the updates of a job to a peer as STATUS messages until the job is final
+func streamJob(conn net.Conn, jobID string) {
+	history, updates, cancel := Jobs.Subscribe(jobID)
+	defer cancel()
+	if len(history) == 0 {
+		sendMessage(conn, Message{Type: "STATUS", JobID: jobID, Status: &JobUpdate{JobID: jobID, Status: JobUnknown, Time: time.Now()}})
+		return
+	}
+
+	for _, update := range history {
+		if err := sendMessage(conn, Message{Type: "STATUS", JobID: jobID, Status: &update}); err != nil {
+			return
+		}
+	}
+	for update := range updates {
+		if err := sendMessage(conn, Message{Type: "STATUS", JobID: jobID, Status: &update}); err != nil {
+			return
+		}
+	}
+}
+
+// Records the miners a job is about to be sent to, so it is only final once all of them answered
+func expectJob(jobID string, peers []string) {
+	for _, peer := range peers {
+		trackJob(jobID, JobUpdate{Status: JobSubmitted, Peer: peer})
+	}
+}
+
+// Reads the STATUS messages a miner sends about a job into the tracker, then closes the connection
+func followJob(conn net.Conn, peer, jobID string) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		message, err := DeserializeMessage(scanner.Text())
+		if err != nil || message.Type != "STATUS" || message.Status == nil || message.Status.JobID != jobID {
+			continue
+		}
+		update := *message.Status
+		update.Peer = peer
+		fmt.Printf("Job %s on %s: %s\n", jobID, peer, update.Status)
+		Jobs.Update(update)
+		if update.Status.Final() {
+			return
+		}
+	}
+	Jobs.Update(JobUpdate{JobID: jobID, Peer: peer, Status: JobFailed, Error: "connection to miner lost"})
+}
+
+// Asks a miner for the status of a job, e.g. after the generator restarted, and follows it
+func WatchJob(peer, jobID string) error {
+	conn, err := net.Dial("tcp", net.JoinHostPort(peer, "8080"))
+	if err != nil {
+		return err
+	}
+	if err := sendMessage(conn, Message{Type: "WATCH", JobID: jobID}); err != nil {
+		conn.Close()
+		return err
+	}
+	expectJob(jobID, []string{peer})
+	go followJob(conn, peer, jobID)
+	return nil
+}
+
+// Reads an integer from an environment variable, falling back when unset or invalid
+func intEnv(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,191 @@
+package p2p
+
+import (
+	"BlockchainProject/ipfs"
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================Dataset Key Release========================
+
+// Jobs with encrypted inputs are sent only to authorized nodes. The generator asks every
+// peer for its identity, picks the assigned miners among the authorized ones and sends
+// them the job with the dataset keys sealed to their identity; the other authorized
+// peers receive the keys alone so they can verify the job once it is mined.
+
+// Time a peer has to answer an identity request
+var identifyTimeout = 10 * time.Second
+
+// A connection to a peer that proved it holds an authorized key
+type identifiedPeer struct {
+	address  string
+	identity string
+	conn     net.Conn
+}
+
+// Sends a job whose inputs are encrypted to count authorized miners and releases the keys to every authorized peer
+func submitEncrypted(message Message, cids []string, count int) error {
+	keys := make(map[string][]byte, len(cids))
+	for _, cid := range cids {
+		key, ok := ipfs.DatasetKeys.Get(cid)
+		if !ok {
+			return fmt.Errorf("%w: %s", ipfs.ErrNoDatasetKey, cid)
+		}
+		keys[cid] = key
+	}
+	authorized, err := AuthorizedKeys()
+	if err != nil {
+		return err
+	}
+
+	recipients := identifyPeers(GetPeers(), authorized)
+	defer func() {
+		for _, peer := range recipients {
+			if peer.conn != nil {
+				peer.conn.Close()
+			}
+		}
+	}()
+	if len(recipients) == 0 {
+		return errors.New("no authorized peer available for a job with encrypted inputs")
+	}
+	if count > len(recipients) {
+		fmt.Printf("Only %d authorized peers available, job will be replicated %d times instead of %d\n", len(recipients), len(recipients), count)
+		count = len(recipients)
+	}
+
+	// The first peers of a random order run the job, the others only receive the keys
+	rand.Shuffle(len(recipients), func(i, j int) { recipients[i], recipients[j] = recipients[j], recipients[i] })
+	for _, peer := range recipients[:count] {
+		expectJob(message.JobID, []string{peer.address})
+	}
+	for i, peer := range recipients {
+		sealed := make(map[string]string, len(keys))
+		for cid, key := range keys {
+			sealed[cid], err = SealKey(peer.identity, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		release := Message{Type: "KEYS", Keys: sealed}
+		if i < count {
+			release = message
+			release.Keys = sealed
+		}
+		if err := sendMessage(peer.conn, release); err != nil {
+			fmt.Println("Error releasing keys to peer", peer.address+":", err)
+			if i < count {
+				trackJob(message.JobID, JobUpdate{Status: JobFailed, Peer: peer.address, Error: err.Error()})
+			}
+			continue
+		}
+		fmt.Printf("Released %d dataset keys to %s (%s)\n", len(sealed), peer.address, release.Type)
+
+		// Miners running a tracked job report its progress on the same connection
+		if i < count && message.JobID != "" {
+			go followJob(peer.conn, peer.address, message.JobID)
+			recipients[i].conn = nil
+		}
+	}
+	return nil
+}
+
+// Connects to the peers and keeps the connections of those whose identity is authorized
+func identifyPeers(addresses []string, authorized map[string]bool) []identifiedPeer {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var identified []identifiedPeer
+	for _, address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			peer, err := identifyPeer(address)
+			if err != nil {
+				fmt.Println("Error identifying peer", address+":", err)
+				return
+			}
+			if !authorized[peer.identity] {
+				fmt.Printf("Peer %s is not authorized to receive dataset keys (key %s)\n", address, peer.identity)
+				peer.conn.Close()
+				return
+			}
+			mu.Lock()
+			identified = append(identified, peer)
+			mu.Unlock()
+		}(address)
+	}
+	wg.Wait()
+	return identified
+}
+
+// Asks a peer for its public key on its transaction port
+func identifyPeer(address string) (identifiedPeer, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, "8080"), identifyTimeout)
+	if err != nil {
+		return identifiedPeer{}, err
+	}
+	conn.SetDeadline(time.Now().Add(identifyTimeout))
+	if err := sendMessage(conn, Message{Type: "IDENTIFY"}); err != nil {
+		conn.Close()
+		return identifiedPeer{}, err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return identifiedPeer{}, fmt.Errorf("no identity received: %w", err)
+	}
+	reply, err := DeserializeMessage(line)
+	if err != nil || reply.Type != "IDENTITY" || reply.Identity == "" {
+		conn.Close()
+		return identifiedPeer{}, errors.New("invalid identity reply")
+	}
+	conn.SetDeadline(time.Time{})
+	return identifiedPeer{address: address, identity: strings.ToLower(reply.Identity), conn: conn}, nil
+}
+
+// Answers an identity request with the public key of this node
+func replyIdentity(conn net.Conn) error {
+	id, err := NodeIdentity()
+	if err != nil {
+		return err
+	}
+	return sendMessage(conn, Message{Type: "IDENTITY", Identity: id.PublicKey()})
+}
+
+// Opens the dataset keys released to this node and stores them for running and verifying jobs
+func storeReleasedKeys(sealed map[string]string) error {
+	if len(sealed) == 0 {
+		return nil
+	}
+	id, err := NodeIdentity()
+	if err != nil {
+		return err
+	}
+	for cid, box := range sealed {
+		key, err := id.OpenKey(box)
+		if err != nil {
+			return fmt.Errorf("key for %s: %w", cid, err)
+		}
+		if err := ipfs.DatasetKeys.Put(cid, key); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Received %d dataset keys\n", len(sealed))
+	return nil
+}
+
+func sendMessage(conn net.Conn, message Message) error {
+	messageJSON, err := SerializeMessage(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "%s\n", messageJSON)
+	return err
+}
@@ -0,0 +1,411 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"BlockchainProject/ipfs"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================Light Client========================
+
+// A light node follows the chain by its headers alone, fetched from the API of full nodes,
+// and executes no jobs. It answers the chain queries of the API by fetching the data from a
+// full node and checking it against its own headers: transactions through their Merkle
+// proofs, blocks through their hash and Merkle root. Full and light nodes both serve
+// GET /api/v1/headers, so a light node can also sync from another light node.
+
+// Interval between header syncs
+var lightSyncInterval = ipfs.DurationEnv("LIGHT_SYNC_INTERVAL", 10*time.Second)
+
+// APIs of the full nodes a light node syncs from, comma separated; the API of each peer by default
+var LightSources = envOr("LIGHT_SOURCES", "")
+
+// Port of the API of peers
+var peerAPIPort = envOr("PEER_API_PORT", "8090")
+
+// Returned when a full node's answer does not match the headers followed
+var ErrUnverified = errors.New("response does not match the chain followed")
+
+// Page of headers, from GET /api/v1/headers?offset=&limit=; offset is the height of the first
+type HeadersResponse struct {
+	Height  int                   `json:"height"` // Height of the node's tip
+	Offset  int                   `json:"offset"`
+	Limit   int                   `json:"limit"`
+	Headers []BlockHeaderResponse `json:"headers"`
+}
+
+type LightClient struct {
+	Headers *blockchain.HeaderChain
+	sources []*APIClient
+	syncMu  sync.Mutex // One sync at a time
+}
+
+// The light client of this node, nil on full nodes. The API answers chain queries through it.
+var lightClient *LightClient
+
+func NewLightClient(sources []string) *LightClient {
+	light := &LightClient{Headers: blockchain.NewHeaderChain()}
+	for _, source := range sources {
+		light.sources = append(light.sources, NewAPIClient(source))
+	}
+	return light
+}
+
+// Makes this node a light node following the chain through sources, or through the APIs
+// of its peers when none are given, until ctx ends. Call it before starting the API.
+func StartLightClient(ctx context.Context, sources []string) *LightClient {
+	sources = APISources(sources)
+	fmt.Println("Following the chain's headers from", strings.Join(sources, ", "))
+
+	lightClient = NewLightClient(sources)
+	go lightClient.Run(ctx)
+	return lightClient
+}
+
+// Returns the APIs of full nodes to fetch the chain from: sources if given, LIGHT_SOURCES
+// if set, the APIs of the peers otherwise
+func APISources(sources []string) []string {
+	if len(sources) == 0 && LightSources != "" {
+		sources = strings.Split(LightSources, ",")
+	}
+	if len(sources) == 0 {
+		for _, peer := range GetPeers() {
+			sources = append(sources, "http://"+net.JoinHostPort(peer, peerAPIPort))
+		}
+	}
+	return sources
+}
+
+// Syncs the headers periodically until ctx ends
+func (l *LightClient) Run(ctx context.Context) {
+	for {
+		if err := l.Sync(ctx); err != nil && ctx.Err() == nil {
+			fmt.Println("Error syncing headers:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(lightSyncInterval):
+		}
+	}
+}
+
+// Fetches the headers after our tip from the first source that answers
+func (l *LightClient) Sync(ctx context.Context) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+
+	if len(l.sources) == 0 {
+		return errors.New("no full node to sync from")
+	}
+	var errs []error
+	for _, source := range l.sources {
+		err := l.syncFrom(ctx, source)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", source.BaseURL, err))
+	}
+	return errors.Join(errs...)
+}
+
+func (l *LightClient) syncFrom(ctx context.Context, source *APIClient) error {
+	for {
+		height := l.Headers.Height()
+		page, err := source.Headers(ctx, height+1, blockchain.MaxPageLimit)
+		if err != nil {
+			return err
+		}
+		if len(page.Headers) == 0 {
+			return nil
+		}
+		headers := make([]blockchain.BlockHeader, 0, len(page.Headers))
+		for _, header := range page.Headers {
+			headers = append(headers, header.header())
+		}
+
+		// Walk back to where the source's chain meets ours
+		from := height + 1
+		for from > 1 {
+			ours, _ := l.Headers.HeaderAt(from - 1)
+			if headers[0].PrevHash == ours.Hash {
+				break
+			}
+			back, err := source.Headers(ctx, from-1, 1)
+			if err != nil {
+				return err
+			}
+			if len(back.Headers) == 0 {
+				return errors.New("the source's chain has a gap")
+			}
+			headers = append([]blockchain.BlockHeader{back.Headers[0].header()}, headers...)
+			from--
+		}
+		tip := l.Headers.Tip()
+		if err := l.Headers.Connect(from, headers); err != nil {
+			return err
+		}
+		if from <= height {
+			fmt.Println("Headers from height", from, "replaced by the longer chain of", source.BaseURL)
+			Events.Publish(TopicReorg, ReorgEvent{Reason: "fork", Height: height, Tip: tip.Hash, Block: headers[0].Hash, PrevHash: headers[0].PrevHash})
+		}
+		if from+len(headers)-1 >= page.Height {
+			return nil
+		}
+	}
+}
+
+// Checks that a header received from a full node is on the chain followed, syncing first
+// if it is beyond our tip
+func (l *LightClient) checkHeader(ctx context.Context, header BlockHeaderResponse) error {
+	if header.Height > l.Headers.Height() {
+		l.Sync(ctx)
+	}
+	ours, ok := l.Headers.HeaderAt(header.Height)
+	if !ok || ours != header.header() {
+		return fmt.Errorf("%w: block %s at height %d", ErrUnverified, header.Hash, header.Height)
+	}
+	return nil
+}
+
+// Asks the sources in turn until one answers. Errors of the API, such as a transaction not
+// being found, are returned as they are; other errors move on to the next source.
+func (l *LightClient) query(fetch func(source *APIClient) error) error {
+	var errs []error
+	for _, source := range l.sources {
+		err := fetch(source)
+		// Errors of the source's API are final, unless it pruned data an archive node may have
+		var apiErr *APIError
+		if err == nil || errors.As(err, &apiErr) && apiErr.Code != "pruned" {
+			return err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", source.BaseURL, err))
+	}
+	if len(errs) == 0 {
+		return errors.New("no full node to query")
+	}
+	return errors.Join(errs...)
+}
+
+// ========================Verified queries========================
+
+// Returns the inclusion proof of a transaction, checked against the headers
+func (l *LightClient) Proof(ctx context.Context, id string) (InclusionProofResponse, error) {
+	var proof InclusionProofResponse
+	err := l.query(func(source *APIClient) error {
+		var err error
+		proof, err = l.proofFrom(ctx, source, id)
+		return err
+	})
+	return proof, err
+}
+
+func (l *LightClient) proofFrom(ctx context.Context, source *APIClient, id string) (InclusionProofResponse, error) {
+	proof, err := source.Proof(ctx, id)
+	if err != nil {
+		return proof, err
+	}
+	if proof.Proof.TxID != id || proof.Transaction.ID != id {
+		return proof, fmt.Errorf("%w: proof of transaction %s instead of %s", ErrUnverified, proof.Proof.TxID, id)
+	}
+	if proof.Transaction.Height != proof.Header.Height || proof.Transaction.Block != proof.Header.Hash {
+		return proof, fmt.Errorf("%w: transaction %s is not located in the block of its proof", ErrUnverified, id)
+	}
+	if err := proof.Verify(); err != nil {
+		return proof, fmt.Errorf("%w: %v", ErrUnverified, err)
+	}
+	return proof, l.checkHeader(ctx, proof.Header)
+}
+
+// Returns a page of the transactions indexed under key, each checked with its inclusion proof.
+// A full node could still leave transactions out; the ones returned are on the chain. Pruned
+// transactions cannot be proven and are left out.
+func (l *LightClient) Transactions(ctx context.Context, index, key string, page blockchain.Page) (TransactionsResponse, error) {
+	var txs TransactionsResponse
+	err := l.query(func(source *APIClient) error {
+		var err error
+		if txs, err = source.Transactions(ctx, index, key, page); err != nil {
+			return err
+		}
+		verified := make([]TransactionResponse, 0, len(txs.Transactions))
+		for _, tx := range txs.Transactions {
+			if tx.Pruned {
+				continue
+			}
+			if indexKey(index, tx.Transaction) != key {
+				return fmt.Errorf("%w: transaction %s is not indexed under %s", ErrUnverified, tx.ID, key)
+			}
+			proof, err := l.proofFrom(ctx, source, tx.Transaction.ID())
+			if err != nil {
+				return err
+			}
+			verified = append(verified, proof.Transaction)
+		}
+		txs.Transactions = verified
+		return nil
+	})
+	return txs, err
+}
+
+// Returns the value of a transaction an index keys it by
+func indexKey(index string, tx blockchain.Transaction) string {
+	switch index {
+	case "datasets":
+		return tx.DataHash
+	case "algorithms":
+		return tx.AlgoHash
+	case "outputs":
+		return tx.Output
+	}
+	return ""
+}
+
+// Returns the blocks holding transactions of a miner, checked to be on the chain followed
+func (l *LightClient) MinerBlocks(ctx context.Context, miner string, page blockchain.Page) (MinerBlocksResponse, error) {
+	var blocks MinerBlocksResponse
+	err := l.query(func(source *APIClient) error {
+		var err error
+		if blocks, err = source.MinerBlocks(ctx, miner, page); err != nil {
+			return err
+		}
+		for _, block := range blocks.Blocks {
+			if ours, ok := l.Headers.HeaderAt(block.Height); !ok || ours.Hash != block.Hash {
+				return fmt.Errorf("%w: block %s at height %d", ErrUnverified, block.Hash, block.Height)
+			}
+		}
+		return nil
+	})
+	return blocks, err
+}
+
+// Returns a block by height or hash, checked against its header and Merkle root
+func (l *LightClient) Block(ctx context.Context, ref string) (BlockResponse, error) {
+	if _, height, ok := l.Headers.HeaderByHash(ref); ok {
+		ref = strconv.Itoa(height)
+	}
+	var block BlockResponse
+	err := l.query(func(source *APIClient) error {
+		var err error
+		if block, err = source.Block(ctx, ref); err != nil {
+			return err
+		}
+		if blockchain.MerkleRoot(block.Transactions) != block.MerkleRoot {
+			return fmt.Errorf("%w: transactions of block %s do not match its Merkle root", ErrUnverified, block.Hash)
+		}
+		return l.checkHeader(ctx, BlockHeaderResponse{Height: block.Height, Hash: block.Hash, PrevHash: block.PrevHash, MerkleRoot: block.MerkleRoot, Nonce: block.Nonce})
+	})
+	return block, err
+}
+
+func (header BlockHeaderResponse) header() blockchain.BlockHeader {
+	return blockchain.BlockHeader{Hash: header.Hash, PrevHash: header.PrevHash, MerkleRoot: header.MerkleRoot, Nonce: header.Nonce}
+}
+
+// ========================Light API========================
+
+// Registers the chain queries of the API answered through the light client
+func lightRoutes(route func(method, pattern string, handler http.HandlerFunc), prefix string, l *LightClient) {
+	route(http.MethodGet, prefix+"/chain/tip", func(w http.ResponseWriter, r *http.Request) {
+		block, err := l.Block(r.Context(), strconv.Itoa(l.Headers.Height()))
+		writeLightResponse(w, block, err)
+	})
+	route(http.MethodGet, prefix+"/blocks/{ref}", func(w http.ResponseWriter, r *http.Request) {
+		block, err := l.Block(r.Context(), r.PathValue("ref"))
+		writeLightResponse(w, block, err)
+	})
+	route(http.MethodGet, prefix+"/transactions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		proof, err := l.Proof(r.Context(), r.PathValue("id"))
+		writeLightResponse(w, proof.Transaction, err)
+	})
+	route(http.MethodGet, prefix+"/transactions/{id}/proof", func(w http.ResponseWriter, r *http.Request) {
+		proof, err := l.Proof(r.Context(), r.PathValue("id"))
+		writeLightResponse(w, proof, err)
+	})
+	for _, index := range []struct{ name, key string }{{"datasets", "cid"}, {"algorithms", "cid"}, {"outputs", "hash"}} {
+		route(http.MethodGet, prefix+"/"+index.name+"/{"+index.key+"}/transactions", func(w http.ResponseWriter, r *http.Request) {
+			page, ok := readPage(w, r)
+			if !ok {
+				return
+			}
+			txs, err := l.Transactions(r.Context(), index.name, r.PathValue(index.key), page)
+			writeLightResponse(w, txs, err)
+		})
+	}
+	route(http.MethodGet, prefix+"/miners/{id}/blocks", func(w http.ResponseWriter, r *http.Request) {
+		page, ok := readPage(w, r)
+		if !ok {
+			return
+		}
+		blocks, err := l.MinerBlocks(r.Context(), r.PathValue("id"), page)
+		writeLightResponse(w, blocks, err)
+	})
+}
+
+// Answers with a verified response, or with the error of the full nodes
+func writeLightResponse(w http.ResponseWriter, v interface{}, err error) {
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, v)
+	case errors.As(err, &apiErr):
+		status := http.StatusBadGateway
+		if strings.HasSuffix(apiErr.Code, "_not_found") {
+			status = http.StatusNotFound
+		} else if apiErr.Code == "pruned" {
+			status = http.StatusGone
+		} else if strings.HasPrefix(apiErr.Code, "invalid_") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, apiErr.Code, apiErr.Message)
+	case errors.Is(err, ErrUnverified):
+		writeError(w, http.StatusBadGateway, "unverified_response", err.Error())
+	default:
+		writeError(w, http.StatusBadGateway, "source_unavailable", err.Error())
+	}
+}
+
+// Serves GET /api/v1/headers?offset=&limit=, from the ledger or, on light nodes, the headers followed
+func listHeaders(l *LightClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, ok := readPage(w, r)
+		if !ok {
+			return
+		}
+		height, headerAt := ledger.Height(), func(h int) (blockchain.BlockHeader, bool) {
+			block, ok := ledger.BlockAt(h)
+			if !ok {
+				return blockchain.BlockHeader{}, false
+			}
+			return block.Header(), true
+		}
+		if l != nil {
+			height, headerAt = l.Headers.Height(), l.Headers.HeaderAt
+		}
+
+		response := HeadersResponse{Height: height, Offset: page.Offset, Limit: page.Limit, Headers: []BlockHeaderResponse{}}
+		for h := page.Offset; h < page.Offset+page.Limit; h++ {
+			header, ok := headerAt(h)
+			if !ok {
+				break
+			}
+			response.Headers = append(response.Headers, headerResponse(header, h))
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// Height and hash of the tip of the chain followed, the ledger's on full nodes
+func chainTipHeader(l *LightClient) (int, string) {
+	if l != nil {
+		return l.Headers.Height(), l.Headers.Tip().Hash
+	}
+	return ledger.Height(), ledger.GetLatestBlock().Hash
+}
@@ -1,16 +1,27 @@
 package p2p
 
-import "encoding/json"
+import (
+	"BlockchainProject/blockchain"
+	"BlockchainProject/ipfs"
+	"encoding/json"
+)
 
-//structured message
+// structured message
 type Message struct {
-	Type         string      `json:"type"`    //e.g., "REQUEST_CHAIN", "NEW_BLOCK"
-	Dataset      interface{} `json:"dataset"` //CID of dataset to be used with the algorithm
-	Algo         interface{} `json:"algo"`    //CID of algo to be used
-	Requirements interface{} `json:"req"`     //CID requirements file to be installed
+	Type         string                  `json:"type"`                  //e.g., "REQUEST_CHAIN", "NEW_BLOCK"
+	Dataset      interface{}             `json:"dataset"`               //CID of dataset to be used with the algorithm
+	Algo         interface{}             `json:"algo"`                  //CID of algo to be used
+	Requirements interface{}             `json:"req"`                   //CID requirements file to be installed
+	Spec         *ipfs.JobSpec           `json:"spec,omitempty"`        //Optional job options such as deadlines and output schema
+	Manifest     string                  `json:"manifest,omitempty"`    //CID of a job manifest, replaces the fields above
+	Keys         map[string]string       `json:"keys,omitempty"`        //Dataset keys sealed to the recipient, by dataset CID
+	Identity     string                  `json:"identity,omitempty"`    //Public key of the sender, in IDENTITY replies
+	JobID        string                  `json:"jobId,omitempty"`       //ID of the submission, in TRANS, WATCH and STATUS messages
+	Status       *JobUpdate              `json:"status,omitempty"`      //Status transition of a job, in STATUS messages
+	Transaction  *blockchain.Transaction `json:"transaction,omitempty"` //Transaction of the job, in RESULT replies
 }
 
-//convert to JSON
+// convert to JSON
 func SerializeMessage(message Message) (string, error) {
 	messageBytes, err := json.Marshal(message)
 	if err != nil {
@@ -19,7 +30,7 @@ func SerializeMessage(message Message) (string, error) {
 	return string(messageBytes), nil
 }
 
-//convert from JSON
+// convert from JSON
 func DeserializeMessage(jsonString string) (Message, error) {
 	var message Message
 	err := json.Unmarshal([]byte(jsonString), &message)
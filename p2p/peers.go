@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"sort"
 	"sync"
 	"time"
 )
@@ -15,17 +16,24 @@ import (
 var peers []string // List of connected peers
 var mu sync.Mutex  // Mutex for thread-safe access to the peers list
 
+var banned = map[string]bool{} // Peers refused until the node restarts, guarded by mu
+
 // AddPeer adds a new peer to the list if it's not already present
 func AddPeer(peerAddress string) {
 	mu.Lock()
 	defer mu.Unlock()
 
+	if banned[peerAddress] {
+		fmt.Println("Ignoring banned peer", peerAddress)
+		return
+	}
 	for _, peer := range peers {
 		if peer == peerAddress {
 			return // Peer is already in the list
 		}
 	}
 	peers = append(peers, peerAddress)
+	Events.Publish(TopicPeer, PeerEvent{Address: peerAddress, Status: "connected"})
 }
 
 // GetPeers returns a copy of the list of connected peers
@@ -38,6 +46,45 @@ func GetPeers() []string {
 	return copyPeers
 }
 
+// BanPeer removes a peer from the list and refuses its connections until the node restarts
+func BanPeer(peerAddress string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	banned[peerAddress] = true
+	for i, peer := range peers {
+		if peer == peerAddress {
+			peers = append(peers[:i], peers[i+1:]...)
+			Events.Publish(TopicPeer, PeerEvent{Address: peerAddress, Status: "disconnected"})
+			break
+		}
+	}
+}
+
+// GetBannedPeers returns the banned peers, sorted
+func GetBannedPeers() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]string, 0, len(banned))
+	for peer := range banned {
+		list = append(list, peer)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Reports whether a connection comes from a banned peer
+func isBannedConn(conn net.Conn) bool {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return banned[host]
+}
+
 // ========================Message Broadcasting========================
 
 // BroadcastMessage sends a message of the specified type and data to all connected peers
@@ -109,33 +156,65 @@ func BroadcastBlock(block *blockchain.Block, senderPeer string) {
 
 // SendDataHashToRandomPeer selects a random peer and sends the provided data hash
 func SendDataHashToRandomPeer(message Message) {
-	mu.Lock()
-	defer mu.Unlock()
+	SendToRandomPeers(message, 1)
+}
 
+// SendToRandomPeers sends the message to count distinct random peers, or to every peer if there are fewer.
+// It returns once every send has completed.
+func SendToRandomPeers(message Message, count int) {
+	mu.Lock()
 	if len(peers) == 0 {
+		mu.Unlock()
 		fmt.Println("No peers available to send data.")
 		return
 	}
+	if count > len(peers) {
+		fmt.Printf("Only %d peers available, job will be replicated %d times instead of %d\n", len(peers), len(peers), count)
+		count = len(peers)
+	}
 
-	// Select a random peer
+	// Select random peers
 	rand.Seed(time.Now().UnixNano())
-	randomPeer := peers[rand.Intn(len(peers))]
-
-	// Send the data hash to the random peer
-	go func(peer string) {
-		messageJSON, err := SerializeMessage(message)
-		if err != nil {
-			fmt.Println("Error serializing message:", err)
-			return
-		}
-		address := fmt.Sprintf("%s:%d", peer, 8080) //Add port to use along with address
-		conn, err := net.Dial("tcp", address)
-		if err != nil {
-			fmt.Println("Error connecting to peer:", err)
-			return
-		}
-		defer conn.Close()
+	var selected []string
+	for _, i := range rand.Perm(len(peers))[:count] {
+		selected = append(selected, peers[i])
+	}
+	mu.Unlock()
+
+	SendToPeers(message, selected)
+}
+
+// SendToPeers sends the message to each of the given peers and returns once every send has completed.
+// If the message carries a job ID, the connections stay open to receive the status updates of the job.
+func SendToPeers(message Message, selected []string) {
+	messageJSON, err := SerializeMessage(message)
+	if err != nil {
+		fmt.Println("Error serializing message:", err)
+		return
+	}
 
-		fmt.Fprintf(conn, "%s\n", messageJSON)
-	}(randomPeer)
+	// Send the message to the selected peers
+	expectJob(message.JobID, selected)
+	var wg sync.WaitGroup
+	for _, peer := range selected {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			address := net.JoinHostPort(peer, "8080") //Add port to use along with address
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				fmt.Println("Error connecting to peer:", err)
+				trackJob(message.JobID, JobUpdate{Status: JobFailed, Peer: peer, Error: err.Error()})
+				return
+			}
+
+			fmt.Fprintf(conn, "%s\n", messageJSON)
+			if message.JobID == "" {
+				conn.Close()
+				return
+			}
+			go followJob(conn, peer, message.JobID)
+		}(peer)
+	}
+	wg.Wait()
 }
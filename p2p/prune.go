@@ -0,0 +1,68 @@
+package p2p
+
+import (
+	"BlockchainProject/ipfs"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ========================Pruning========================
+
+// Nodes with limited disks prune: they keep the headers and indexes of every block but
+// discard the transactions of blocks buried deeper than PruneDepth, along with the cached
+// environments of jobs only those blocks referenced. Archive nodes keep everything and can
+// answer every query; a pruned node answers queries about discarded data with a "pruned" error.
+
+// Blocks whose transactions a node keeps; 0 runs an archive node
+var PruneDepth = intEnv("PRUNE_DEPTH", 0)
+
+// Checks a retention depth: jobs are tracked until confirmed, so blocks are kept at least that long
+func CheckPruneDepth(depth int) error {
+	if depth != 0 && depth < Confirmations {
+		return fmt.Errorf("retention depth must be 0 (archive) or at least %d blocks, the confirmation depth", Confirmations)
+	}
+	return nil
+}
+
+// Discards the transactions of the blocks buried deeper than PruneDepth
+func pruneLedger() {
+	if PruneDepth == 0 {
+		return
+	}
+	pruned, err := ledger.Prune(PruneDepth)
+	if err != nil {
+		fmt.Println("Error pruning ledger:", err)
+		return
+	}
+	if len(pruned) == 0 {
+		return
+	}
+	fmt.Println("Pruned the transactions of", len(pruned), "blocks up to height", ledger.PrunedHeight())
+
+	// Requirements no retained block refers to are no longer needed to verify a job
+	unused := map[string]bool{}
+	for _, block := range pruned {
+		for _, tx := range block.Transactions {
+			unused[tx.Requirements] = true
+		}
+	}
+	for _, block := range ledger.Snapshot() {
+		for _, tx := range block.Transactions {
+			delete(unused, tx.Requirements)
+		}
+	}
+	removed, err := ipfs.EvictEnvironments(unused)
+	if err != nil {
+		fmt.Println("Error evicting environments:", err)
+	}
+	for _, key := range removed {
+		fmt.Println("Removed environment of pruned jobs:", key)
+	}
+}
+
+// Answers a request for data discarded by pruning
+func writePruned(w http.ResponseWriter, what string) {
+	writeError(w, http.StatusGone, "pruned", what+" was pruned: this node keeps only the headers of blocks up to height "+
+		strconv.Itoa(ledger.PrunedHeight())+" and the transactions of its latest "+strconv.Itoa(PruneDepth)+" blocks; ask an archive node")
+}
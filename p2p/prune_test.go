@@ -0,0 +1,25 @@
+package p2p
+
+import "testing"
+
+func TestCheckPruneDepth(t *testing.T) {
+	tests := []struct {
+		name  string
+		depth int
+		valid bool
+	}{
+		{"archive", 0, true},
+		{"confirmation depth", Confirmations, true},
+		{"above the confirmation depth", Confirmations + 10, true},
+		{"below the confirmation depth", Confirmations - 1, false},
+		{"one block", 1, Confirmations <= 1},
+		{"negative", -1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := CheckPruneDepth(test.depth); (err == nil) != test.valid {
+				t.Fatalf("error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
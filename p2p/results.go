@@ -0,0 +1,117 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"BlockchainProject/ipfs"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ========================Job Results========================
+
+// Miners publish the output of every job they run and record its CID in the transaction.
+// A generator asks any miner for the transaction of a job with a RESULT message naming its
+// job ID, and downloads the output.
+
+// Time a peer has to answer a result request
+var resultTimeout = 10 * time.Second
+
+// Returned when no transaction for the job is known to the peer
+var ErrNoResult = errors.New("no result recorded for job")
+
+// Answers a result request with the transaction of the job, if known
+func replyResult(conn net.Conn, query Message) error {
+	reply := Message{Type: "RESULT", JobID: query.JobID}
+	if tx, ok := findJobTransaction(query.JobID); ok {
+		reply.Transaction = &tx
+	}
+	return sendMessage(conn, reply)
+}
+
+// Looks for the transaction of a job with a published output in the ledger, then in the mempool
+func findJobTransaction(jobID string) (blockchain.Transaction, bool) {
+	matches := func(tx *blockchain.Transaction) bool {
+		return jobID != "" && tx.JobID == jobID && tx.OutputCID != ""
+	}
+
+	blocks := ledger.Snapshot()
+	for i := len(blocks) - 1; i >= 0; i-- {
+		for _, tx := range blocks[i].Transactions {
+			if matches(&tx) {
+				return tx, true
+			}
+		}
+	}
+	for _, tx := range mempool.GetTransactions() {
+		if matches(tx) {
+			return *tx, true
+		}
+	}
+	return blockchain.Transaction{}, false
+}
+
+// Asks a peer for the transaction of a job
+func QueryJobResult(peer, jobID string) (blockchain.Transaction, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(peer, "8080"), resultTimeout)
+	if err != nil {
+		return blockchain.Transaction{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(resultTimeout))
+
+	if err := sendMessage(conn, Message{Type: "RESULT", JobID: jobID}); err != nil {
+		return blockchain.Transaction{}, err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return blockchain.Transaction{}, fmt.Errorf("no result received: %w", err)
+	}
+	reply, err := DeserializeMessage(line)
+	if err != nil || reply.Type != "RESULT" {
+		return blockchain.Transaction{}, errors.New("invalid result reply")
+	}
+	if reply.Transaction == nil || reply.Transaction.JobID != jobID || reply.Transaction.OutputCID == "" {
+		return blockchain.Transaction{}, ErrNoResult
+	}
+	return *reply.Transaction, nil
+}
+
+// Fetches the output of a job from the first peer that knows its transaction and serves an
+// output matching it, writing it to dir
+func FetchJobResult(ctx context.Context, jobID string, peers []string, dir string) (ipfs.OutputArtifact, error) {
+	for _, peer := range peers {
+		tx, err := QueryJobResult(peer, jobID)
+		if err != nil {
+			fmt.Println("No result from peer", peer+":", err)
+			continue
+		}
+		artifact, err := FetchTransactionOutput(ctx, tx, dir)
+		if err != nil {
+			fmt.Println("Unusable result from peer", peer+":", err)
+			continue
+		}
+		return artifact, nil
+	}
+	return ipfs.OutputArtifact{}, fmt.Errorf("%w: %s", ErrNoResult, jobID)
+}
+
+// Downloads the published output of a transaction to dir, checked against the hash the
+// transaction commits to
+func FetchTransactionOutput(ctx context.Context, tx blockchain.Transaction, dir string) (ipfs.OutputArtifact, error) {
+	job, err := JobFromTransaction(ctx, tx)
+	if err != nil {
+		return ipfs.OutputArtifact{}, err
+	}
+	artifact, err := ipfs.FetchOutput(ctx, tx.OutputCID, job, dir)
+	if err != nil {
+		return ipfs.OutputArtifact{}, err
+	}
+	if artifact.Hash != tx.Output {
+		return ipfs.OutputArtifact{}, fmt.Errorf("output %s does not match the transaction of the job", tx.OutputCID)
+	}
+	return artifact, nil
+}
@@ -0,0 +1,60 @@
+package p2p
+
+import (
+	"BlockchainProject/blockchain"
+	"context"
+	"sync"
+)
+
+// ========================In-flight Block Verification========================
+
+// A block whose transactions are being re-executed
+type verification struct {
+	prevHash string
+	cancel   context.CancelFunc
+}
+
+var verifications = map[string]verification{} // In-flight verifications keyed by block hash
+var verifyMu sync.Mutex                       // Mutex for thread-safe access to the verifications
+
+// startVerification registers a block under verification and returns the context to verify it with
+func startVerification(block *blockchain.Block) context.Context {
+	verifyMu.Lock()
+	defer verifyMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	verifications[block.Hash] = verification{prevHash: block.PrevHash, cancel: cancel}
+	return ctx
+}
+
+// finishVerification releases the context of a block once its verification is over
+func finishVerification(block *blockchain.Block) {
+	verifyMu.Lock()
+	defer verifyMu.Unlock()
+
+	if v, ok := verifications[block.Hash]; ok {
+		v.cancel()
+		delete(verifications, block.Hash)
+	}
+}
+
+// abortCompetingVerifications cancels the verification of every other block built on the same parent
+func abortCompetingVerifications(winner *blockchain.Block) {
+	verifyMu.Lock()
+	defer verifyMu.Unlock()
+
+	for hash, v := range verifications {
+		if hash != winner.Hash && v.prevHash == winner.PrevHash {
+			v.cancel()
+			delete(verifications, hash)
+			Events.Publish(TopicReorg, ReorgEvent{
+				Reason:    "competing",
+				Height:    ledger.Height(),
+				Tip:       ledger.GetLatestBlock().Hash,
+				Block:     winner.Hash,
+				PrevHash:  winner.PrevHash,
+				Discarded: hash,
+			})
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package p2p
+
+import (
+	"BlockchainProject/ipfs"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ========================Workload Driver========================
+
+// The generator submits jobs following a workload: a set of weighted job templates and a
+// schedule. Template, dataset and peer selection and arrival times all come from one seeded
+// source, so a workload with a fixed seed replays the same scenario against the same peers.
+// Job IDs stay random so replays are not mistaken for the jobs of an earlier run.
+
+// Schedules jobs are submitted on
+const (
+	ScheduleConstant = "constant" // Rate jobs per second, evenly spaced
+	SchedulePoisson  = "poisson"  // Rate jobs per second on average, exponentially distributed gaps
+	ScheduleBurst    = "burst"    // Burst jobs at once every Interval
+)
+
+// A workload, read from a JSON or YAML file
+type Workload struct {
+	Seed      int64         `json:"seed,omitempty"`     // Seed of the scenario, random when 0
+	Schedule  Schedule      `json:"schedule"`           // When jobs are submitted
+	MaxJobs   int           `json:"maxJobs,omitempty"`  // Jobs to submit in total, unlimited when 0
+	Duration  ipfs.Duration `json:"duration,omitempty"` // Time to submit jobs for, unlimited when 0
+	Drain     ipfs.Duration `json:"drain,omitempty"`    // Time to wait for miners to acknowledge the last jobs, 30s by default
+	Templates []JobTemplate `json:"templates"`          // Jobs to pick from
+}
+
+type Schedule struct {
+	Kind     string        `json:"kind,omitempty"`     // ScheduleConstant (default), SchedulePoisson or ScheduleBurst
+	Rate     float64       `json:"rate,omitempty"`     // Jobs per second of constant and poisson schedules
+	Burst    int           `json:"burst,omitempty"`    // Jobs per burst
+	Interval ipfs.Duration `json:"interval,omitempty"` // Time between bursts
+}
+
+// A job the workload submits, either a manifest or a legacy dataset and algorithm pair
+type JobTemplate struct {
+	Name         string        `json:"name,omitempty"`
+	Weight       float64       `json:"weight,omitempty"`       // Relative frequency of the template, 1 by default
+	Manifest     string        `json:"manifest,omitempty"`     // CID of a published manifest
+	File         string        `json:"file,omitempty"`         // Manifest file published when the workload is loaded, relative to the workload file
+	Datasets     []string      `json:"datasets,omitempty"`     // CIDs of datasets, one picked per job
+	Algorithm    string        `json:"algorithm,omitempty"`    // CID of the algorithm
+	Requirements string        `json:"requirements,omitempty"` // CID of the requirements file
+	Spec         *ipfs.JobSpec `json:"spec,omitempty"`         // Job options of legacy jobs
+	Copies       int           `json:"copies,omitempty"`       // Miners per job; the manifest's replication or 1 by default
+
+	encrypted []string // Encrypted inputs of the manifest, whose keys go to authorized peers only
+}
+
+// Counts of a workload run
+type WorkloadReport struct {
+	Submitted    int64 // Jobs sent to miners
+	Acknowledged int64 // Jobs a miner acknowledged
+	Failed       int64 // Jobs every miner failed or could not be reached for
+	Confirmed    int64 // Jobs confirmed on the chain before the run ended
+}
+
+func (r WorkloadReport) String() string {
+	return fmt.Sprintf("submitted %d, acknowledged %d, failed %d, confirmed %d", r.Submitted, r.Acknowledged, r.Failed, r.Confirmed)
+}
+
+// Returns the demo workload: a job on one of two datasets every 10 seconds
+func DefaultWorkload() Workload {
+	return Workload{
+		Schedule: Schedule{Kind: ScheduleConstant, Rate: 0.1},
+		Templates: []JobTemplate{{
+			Name: "demo",
+			Datasets: []string{
+				"bafybeicvy4d3odjmys7shzw4cddp6hw2zuoifnbzkiwn6vdomr3wysjkee",
+				"bafkreieqc5e3pzaksbsxo573bkeerfry6lm7qbkg44vsrt3sbix5254koy",
+			},
+			Algorithm:    "bafkreib22cejsgdjwahqmnpdqfa5hpp6xrpxukeqcqdyn4liazg3i7noku",
+			Requirements: "bafkreigep2i5w2hw5ek4ufubj4ypdzvyzdhzco3wfpj4b25tjwlclgg5bu",
+		}},
+	}
+}
+
+// Loads a workload file, or builds a workload from a directory of manifest files, each one a
+// template of weight 1 submitted with the default schedule. Manifest files are published.
+func LoadWorkload(ctx context.Context, path string) (Workload, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Workload{}, err
+	}
+
+	var workload Workload
+	dir := filepath.Dir(path)
+	if info.IsDir() {
+		dir = path
+		workload.Schedule = DefaultWorkload().Schedule
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return Workload{}, err
+		}
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".json", ".yaml", ".yml":
+				workload.Templates = append(workload.Templates, JobTemplate{Name: entry.Name(), File: entry.Name()})
+			}
+		}
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Workload{}, err
+		}
+		if data, err = ipfs.YAMLToJSON(data); err != nil {
+			return Workload{}, fmt.Errorf("failed to parse workload %s: %w", path, err)
+		}
+		if err := json.Unmarshal(data, &workload); err != nil {
+			return Workload{}, fmt.Errorf("failed to parse workload %s: %w", path, err)
+		}
+	}
+
+	for i := range workload.Templates {
+		template := &workload.Templates[i]
+		if template.File == "" {
+			continue
+		}
+		file := template.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return Workload{}, err
+		}
+		if _, err := ipfs.ParseManifest(data); err != nil {
+			return Workload{}, fmt.Errorf("%s: %w", file, err)
+		}
+		template.Manifest, err = ipfs.Put(ctx, filepath.Base(file), data)
+		if err != nil {
+			return Workload{}, fmt.Errorf("error publishing %s: %w", file, err)
+		}
+		fmt.Printf("Template %s: manifest %s\n", template.Name, template.Manifest)
+	}
+	return workload, workload.Validate()
+}
+
+// Checks that the workload describes runnable jobs on a usable schedule
+func (w Workload) Validate() error {
+	switch w.Schedule.Kind {
+	case "", ScheduleConstant, SchedulePoisson:
+		if w.Schedule.Rate <= 0 {
+			return errors.New("schedule rate must be positive")
+		}
+	case ScheduleBurst:
+		if w.Schedule.Burst <= 0 || w.Schedule.Interval <= 0 {
+			return errors.New("burst schedules need a positive burst size and interval")
+		}
+	default:
+		return fmt.Errorf("unknown schedule %q", w.Schedule.Kind)
+	}
+	if w.MaxJobs < 0 || w.Duration < 0 || w.Drain < 0 {
+		return errors.New("job count and durations cannot be negative")
+	}
+	if len(w.Templates) == 0 {
+		return errors.New("workload has no job templates")
+	}
+	for i, template := range w.Templates {
+		name := template.Name
+		if name == "" {
+			name = fmt.Sprint("#", i+1)
+		}
+		if template.Weight < 0 || template.Copies < 0 {
+			return fmt.Errorf("template %s: weight and copies cannot be negative", name)
+		}
+		if template.Manifest == "" && (len(template.Datasets) == 0 || template.Algorithm == "") {
+			return fmt.Errorf("template %s needs a manifest, or datasets and an algorithm", name)
+		}
+	}
+	return nil
+}
+
+// Returns the time to wait before submitting job n, counting from 0
+func (s Schedule) delay(rng *rand.Rand, n int) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	switch s.Kind {
+	case SchedulePoisson:
+		return time.Duration(rng.ExpFloat64() / s.Rate * float64(time.Second))
+	case ScheduleBurst:
+		if n%s.Burst == 0 {
+			return time.Duration(s.Interval)
+		}
+		return 0
+	default:
+		return time.Duration(float64(time.Second) / s.Rate)
+	}
+}
+
+// Submits the jobs of a workload to the known peers until MaxJobs jobs are submitted,
+// Duration elapses or ctx is cancelled, then waits up to Drain for the last jobs to be
+// acknowledged and returns the counts
+func RunWorkload(ctx context.Context, workload Workload) (WorkloadReport, error) {
+	if err := workload.Validate(); err != nil {
+		return WorkloadReport{}, err
+	}
+	peers := GetPeers()
+	if len(peers) == 0 {
+		return WorkloadReport{}, errors.New("no peers available to submit jobs to")
+	}
+	sort.Strings(peers) // Selection depends on the seed only, not on the order peers were added
+
+	templates, total, err := prepareTemplates(ctx, workload.Templates)
+	if err != nil {
+		return WorkloadReport{}, err
+	}
+
+	seed := workload.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Running workload with seed %d on %d peers\n", seed, len(peers))
+	rng := rand.New(rand.NewSource(seed))
+
+	if workload.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(workload.Duration))
+		defer cancel()
+	}
+
+	run := &workloadRun{}
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
+	var sends sync.WaitGroup
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+submit:
+	for n := 0; workload.MaxJobs == 0 || n < workload.MaxJobs; n++ {
+		timer.Reset(workload.Schedule.delay(rng, n))
+		select {
+		case <-ctx.Done():
+			break submit
+		case <-timer.C:
+		}
+
+		// Every random choice is made here, in submission order, so the scenario is reproducible
+		template := pickTemplate(rng, templates, total)
+		message := template.message(rng)
+		selected := pickPeers(rng, peers, template.copies())
+
+		sends.Add(1)
+		go func() {
+			defer sends.Done()
+			run.submit(watchCtx, message, template, selected)
+		}()
+	}
+	sends.Wait()
+
+	// Give miners time to acknowledge the last jobs before reporting
+	drain := time.Duration(workload.Drain)
+	if drain == 0 {
+		drain = 30 * time.Second
+	}
+	done := make(chan struct{})
+	go func() {
+		run.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(drain):
+		fmt.Println("Drain period over, some jobs are still unacknowledged")
+	}
+	return run.report(), nil
+}
+
+// Loads the manifests of the templates and returns them with the sum of their weights
+func prepareTemplates(ctx context.Context, templates []JobTemplate) ([]JobTemplate, float64, error) {
+	prepared := make([]JobTemplate, len(templates))
+	total := 0.0
+	for i, template := range templates {
+		if template.Weight == 0 {
+			template.Weight = 1
+		}
+		if template.Manifest != "" {
+			manifest, err := ipfs.LoadManifest(ctx, template.Manifest)
+			if err != nil {
+				return nil, 0, err
+			}
+			if template.Copies == 0 {
+				template.Copies = manifest.Copies()
+			}
+			template.encrypted = manifest.EncryptedInputs()
+		}
+		total += template.Weight
+		prepared[i] = template
+	}
+	return prepared, total, nil
+}
+
+func pickTemplate(rng *rand.Rand, templates []JobTemplate, total float64) JobTemplate {
+	r := rng.Float64() * total
+	for _, template := range templates {
+		if r < template.Weight {
+			return template
+		}
+		r -= template.Weight
+	}
+	return templates[len(templates)-1]
+}
+
+func pickPeers(rng *rand.Rand, peers []string, count int) []string {
+	if count > len(peers) {
+		fmt.Printf("Only %d peers available, job will be replicated %d times instead of %d\n", len(peers), len(peers), count)
+		count = len(peers)
+	}
+	var selected []string
+	for _, i := range rng.Perm(len(peers))[:count] {
+		selected = append(selected, peers[i])
+	}
+	return selected
+}
+
+// Builds the TRANS message of one job of the template
+func (t JobTemplate) message(rng *rand.Rand) Message {
+	message := Message{Type: "TRANS", JobID: NewJobID()}
+	if t.Manifest != "" {
+		message.Manifest = t.Manifest
+		return message
+	}
+	message.Dataset = t.Datasets[rng.Intn(len(t.Datasets))]
+	message.Algo = t.Algorithm
+	message.Requirements = t.Requirements
+	message.Spec = t.Spec
+	return message
+}
+
+func (t JobTemplate) copies() int {
+	if t.Copies == 0 {
+		return 1
+	}
+	return t.Copies
+}
+
+// Counters of a running workload
+type workloadRun struct {
+	submitted, acknowledged, failed, confirmed atomic.Int64
+	pending                                    sync.WaitGroup // Jobs neither acknowledged nor failed yet
+}
+
+// Sends one job and follows it. Jobs with encrypted inputs go to authorized peers chosen by
+// identity instead of the selected ones.
+func (r *workloadRun) submit(ctx context.Context, message Message, template JobTemplate, selected []string) {
+	if len(template.encrypted) > 0 {
+		if err := submitEncrypted(message, template.encrypted, template.copies()); err != nil {
+			fmt.Println("Error submitting job", message.JobID+":", err)
+			r.failed.Add(1)
+			return
+		}
+	} else {
+		SendToPeers(message, selected)
+	}
+	r.submitted.Add(1)
+	r.pending.Add(1)
+	go r.watch(ctx, message.JobID)
+}
+
+// Counts the acknowledgement and the outcome of a job
+func (r *workloadRun) watch(ctx context.Context, jobID string) {
+	history, updates, cancel := Jobs.Subscribe(jobID)
+	defer cancel()
+
+	acknowledged, settled := false, false
+	settle := func() {
+		if !settled {
+			settled = true
+			r.pending.Done()
+		}
+	}
+	defer settle()
+
+	observe := func(update JobUpdate) {
+		switch update.Status {
+		case JobSubmitted, JobFailed, JobUnknown:
+		default:
+			if !acknowledged {
+				acknowledged = true
+				r.acknowledged.Add(1)
+				settle()
+			}
+		}
+		if update.Status == JobConfirmed {
+			r.confirmed.Add(1)
+		}
+	}
+	for _, update := range history {
+		observe(update)
+	}
+
+	last := JobSubmitted
+	if len(history) > 0 {
+		last = history[len(history)-1].Status
+	}
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				if last != JobConfirmed {
+					r.failed.Add(1)
+				}
+				return
+			}
+			last = update.Status
+			observe(update)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *workloadRun) report() WorkloadReport {
+	return WorkloadReport{
+		Submitted:    r.submitted.Load(),
+		Acknowledged: r.acknowledged.Load(),
+		Failed:       r.failed.Load(),
+		Confirmed:    r.confirmed.Load(),
+	}
+}
@@ -0,0 +1,206 @@
+package p2p
+
+import (
+	"BlockchainProject/ipfs"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		want     []time.Duration // Delays of the first jobs, nil to only check replays
+	}{
+		{"constant", Schedule{Kind: ScheduleConstant, Rate: 4}, []time.Duration{0, 250 * time.Millisecond, 250 * time.Millisecond}},
+		{"constant by default", Schedule{Rate: 0.5}, []time.Duration{0, 2 * time.Second, 2 * time.Second}},
+		{"burst", Schedule{Kind: ScheduleBurst, Burst: 2, Interval: ipfs.Duration(time.Minute)}, []time.Duration{0, 0, time.Minute, 0, time.Minute}},
+		{"poisson", Schedule{Kind: SchedulePoisson, Rate: 2}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delays := func(seed int64) []time.Duration {
+				rng := rand.New(rand.NewSource(seed))
+				var delays []time.Duration
+				for n := 0; n < 50; n++ {
+					delays = append(delays, test.schedule.delay(rng, n))
+				}
+				return delays
+			}
+			first := delays(42)
+			if !reflect.DeepEqual(first, delays(42)) {
+				t.Fatal("the same seed gave different delays")
+			}
+			if first[0] != 0 {
+				t.Fatalf("first job delayed by %v", first[0])
+			}
+			if test.want != nil && !reflect.DeepEqual(first[:len(test.want)], test.want) {
+				t.Fatalf("delays %v, want %v", first[:len(test.want)], test.want)
+			}
+		})
+	}
+
+	// Poisson gaps vary and average the inverse of the rate
+	rng := rand.New(rand.NewSource(1))
+	schedule := Schedule{Kind: SchedulePoisson, Rate: 2}
+	var total time.Duration
+	const jobs = 10000
+	for n := 1; n <= jobs; n++ {
+		total += schedule.delay(rng, n)
+	}
+	if mean := total / jobs; mean < 450*time.Millisecond || mean > 550*time.Millisecond {
+		t.Fatalf("mean gap %v, want about 500ms", mean)
+	}
+	if schedule.delay(rng, 1) == schedule.delay(rng, 1) {
+		t.Fatal("poisson gaps do not vary")
+	}
+}
+
+func TestPickTemplate(t *testing.T) {
+	templates := []JobTemplate{
+		{Name: "rare", Weight: 1},
+		{Name: "never", Weight: 0},
+		{Name: "frequent", Weight: 3},
+	}
+	picks := func(seed int64, n int) []string {
+		rng := rand.New(rand.NewSource(seed))
+		var names []string
+		for i := 0; i < n; i++ {
+			names = append(names, pickTemplate(rng, templates, 4).Name)
+		}
+		return names
+	}
+
+	if !reflect.DeepEqual(picks(7, 100), picks(7, 100)) {
+		t.Fatal("the same seed picked different templates")
+	}
+	if reflect.DeepEqual(picks(7, 100), picks(8, 100)) {
+		t.Fatal("different seeds picked the same templates")
+	}
+
+	counts := map[string]int{}
+	const n = 20000
+	for _, name := range picks(1, n) {
+		counts[name]++
+	}
+	if counts["never"] != 0 {
+		t.Fatalf("template of weight 0 picked %d times", counts["never"])
+	}
+	if share := float64(counts["frequent"]) / n; math.Abs(share-0.75) > 0.02 {
+		t.Fatalf("template of weight 3 out of 4 picked %.3f of the time", share)
+	}
+}
+
+func TestWorkloadReplay(t *testing.T) {
+	template := JobTemplate{Datasets: []string{"dataset-1", "dataset-2", "dataset-3"}, Algorithm: "algorithm", Copies: 2}
+	peers := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+
+	// Datasets and peers picked with one seed are picked again with it
+	scenario := func(seed int64) []string {
+		rng := rand.New(rand.NewSource(seed))
+		var picks []string
+		for i := 0; i < 20; i++ {
+			picks = append(picks, fmt.Sprint(template.message(rng).Dataset))
+			picks = append(picks, pickPeers(rng, peers, template.copies())...)
+		}
+		return picks
+	}
+	if !reflect.DeepEqual(scenario(3), scenario(3)) {
+		t.Fatal("the same seed gave different scenarios")
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	if first, second := template.message(rng), template.message(rng); first.JobID == second.JobID {
+		t.Fatal("jobs of a template share an ID")
+	}
+	if selected := pickPeers(rng, peers[:1], 2); len(selected) != 1 {
+		t.Fatalf("%d peers selected out of 1", len(selected))
+	}
+}
+
+func TestLoadWorkload(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		err      string
+	}{
+		{"json", "workload.json", `{"seed": 5, "schedule": {"kind": "poisson", "rate": 2}, "maxJobs": 10, "duration": "1m",
+			"templates": [{"name": "legacy", "weight": 2, "datasets": ["dataset"], "algorithm": "algorithm"}, {"manifest": "manifest"}]}`, ""},
+		{"yaml", "workload.yaml", "seed: 5\nschedule:\n  kind: burst\n  burst: 3\n  interval: 10s\ntemplates:\n  - name: legacy\n    datasets: [dataset]\n    algorithm: algorithm\n", ""},
+		{"duration in seconds", "workload.yml", "schedule: {rate: 1}\ndrain: 5\ntemplates:\n  - manifest: manifest\n", ""},
+		{"malformed", "workload.json", `{"schedule": `, "failed to parse workload"},
+		{"unknown schedule", "workload.yaml", "schedule: {kind: hourly, rate: 1}\ntemplates: [{manifest: manifest}]\n", `unknown schedule "hourly"`},
+		{"no rate", "workload.yaml", "schedule: {kind: constant}\ntemplates: [{manifest: manifest}]\n", "schedule rate must be positive"},
+		{"burst without interval", "workload.yaml", "schedule: {kind: burst, burst: 2}\ntemplates: [{manifest: manifest}]\n", "positive burst size and interval"},
+		{"negative duration", "workload.yaml", "schedule: {rate: 1}\nduration: -1s\ntemplates: [{manifest: manifest}]\n", "cannot be negative"},
+		{"no templates", "workload.yaml", "schedule: {rate: 1}\n", "workload has no job templates"},
+		{"negative weight", "workload.yaml", "schedule: {rate: 1}\ntemplates: [{name: heavy, weight: -1, manifest: manifest}]\n", "template heavy: weight and copies cannot be negative"},
+		{"no algorithm", "workload.yaml", "schedule: {rate: 1}\ntemplates: [{datasets: [dataset]}]\n", "template #1 needs a manifest"},
+		{"missing manifest file", "workload.yaml", "schedule: {rate: 1}\ntemplates: [{file: missing.yaml}]\n", "no such file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadWorkload(context.Background(), path)
+			checkError(t, err, test.err)
+		})
+	}
+
+	// A workload reads the same in either format
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "workload.json"), []byte(`{"seed": 9, "schedule": {"kind": "burst", "burst": 2, "interval": "30s"},
+		"drain": 10, "templates": [{"name": "legacy", "weight": 0.5, "datasets": ["a", "b"], "algorithm": "algorithm", "copies": 2}]}`), 0644)
+	os.WriteFile(filepath.Join(dir, "workload.yaml"), []byte("seed: 9\nschedule:\n  kind: burst\n  burst: 2\n  interval: 30s\ndrain: 10\n"+
+		"templates:\n  - name: legacy\n    weight: 0.5\n    datasets:\n      - a\n      - b\n    algorithm: algorithm\n    copies: 2\n"), 0644)
+	fromJSON, err := LoadWorkload(context.Background(), filepath.Join(dir, "workload.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := LoadWorkload(context.Background(), filepath.Join(dir, "workload.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Fatalf("JSON workload %+v read as %+v from YAML", fromJSON, fromYAML)
+	}
+	if fromJSON.Seed != 9 || time.Duration(fromJSON.Schedule.Interval) != 30*time.Second || time.Duration(fromJSON.Drain) != 10*time.Second {
+		t.Fatalf("workload read as %+v", fromJSON)
+	}
+
+	workload, err := LoadWorkload(context.Background(), filepath.Join(t.TempDir(), "missing.yaml"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("loaded %+v from a missing file: %v", workload, err)
+	}
+
+	// Manifest files of a directory are checked before being published
+	dir = t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a manifest"), 0644)
+	os.WriteFile(filepath.Join(dir, "job.yaml"), []byte("inputs: 3\n"), 0644)
+	if _, err := LoadWorkload(context.Background(), dir); err == nil || !strings.Contains(err.Error(), "job.yaml") {
+		t.Fatalf("invalid manifest in a directory: error %v", err)
+	}
+}
+
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("no error, want %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("error %q, want %q", err, want)
+	}
+}